@@ -0,0 +1,550 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opengovern/opensecurity/services/tasks/worker/consts"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultConcurrency  = 1
+	DefaultMaxDeliver   = 5
+	DefaultDrainTimeout = 5 * time.Minute
+
+	DefaultHealthAddress          = ":8080"
+	DefaultHealthHeartbeatTimeout = 2 * time.Minute
+
+	DefaultTracingExporter    = "none"
+	DefaultTracingServiceName = "og-task-template"
+
+	DefaultSpoolMaxBytes     = 1 << 30
+	DefaultSpoolSegmentBytes = 16 << 20
+	DefaultSpoolPolicy       = "block"
+	DefaultSpoolBlockTimeout = 5 * time.Minute
+
+	DefaultResultsSinks = "grpc"
+	DefaultResultsDedup = true
+
+	DefaultPipelineSigV4Service = "osis"
+
+	DefaultReconcileMode             = "delete"
+	DefaultReconcileMaxDeletePercent = 50
+
+	DefaultAuthMode   = "none"
+	DefaultAuthJWTTTL = 5 * time.Minute
+	// MinAuthJWTTTL is the shortest JWT TTL allowed; a run JWT must outlive
+	// the margin it is refreshed at before expiry.
+	MinAuthJWTTTL = time.Minute
+
+	// ConfigFileEnv names the YAML config file when --config is not given.
+	ConfigFileEnv = "TASK_CONFIG_FILE"
+
+	// SecretMask replaces secrets in logged configs and credentials.
+	SecretMask = "********"
+)
+
+type NatsConfig struct {
+	URL                 string `yaml:"url"`
+	Consumer            string `yaml:"consumer"`
+	StreamName          string `yaml:"stream_name"`
+	TopicName           string `yaml:"topic_name"`
+	ResultTopicName     string `yaml:"result_topic_name"`
+	DeadLetterTopicName string `yaml:"dead_letter_topic_name"`
+}
+
+type ElasticSearchConfig struct {
+	Address       string `yaml:"address"`
+	Username      string `yaml:"username"`
+	Password      string `yaml:"password"`
+	IsOnAks       bool   `yaml:"is_on_aks"`
+	IsOpenSearch  bool   `yaml:"is_open_search"`
+	AwsRegion     string `yaml:"aws_region"`
+	AssumeRoleArn string `yaml:"assume_role_arn"`
+}
+
+type WorkerConfig struct {
+	Concurrency int `yaml:"concurrency"`
+	MaxDeliver  int `yaml:"max_deliver"`
+	// OutboxDir keeps final task responses until they are published. Empty
+	// publishes them directly, with no retry.
+	OutboxDir string `yaml:"outbox_dir"`
+	// DrainTimeout is how long a shutdown waits for running jobs to finish
+	// before cancelling them.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+type HealthConfig struct {
+	// Address serves /healthz, /readyz, /livez and /metrics. Empty disables
+	// them.
+	Address string `yaml:"address"`
+	// HeartbeatTimeout fails /livez when a running job has not extended its
+	// ack deadline for this long.
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"`
+}
+
+type TracingConfig struct {
+	// Exporter is one of none, otlp, stdout or file.
+	Exporter     string `yaml:"exporter"`
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	OTLPInsecure bool   `yaml:"otlp_insecure"`
+	// OutputFile is where the file exporter appends spans.
+	OutputFile  string `yaml:"output_file"`
+	ServiceName string `yaml:"service_name"`
+}
+
+// TLSConfig secures outbound gRPC connections. With no CA file the system
+// roots are used, and plaintext needs Insecure to be set explicitly.
+type TLSConfig struct {
+	Insecure   bool   `yaml:"insecure"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+}
+
+// AuthConfig adds a bearer token to every call to the result servers.
+type AuthConfig struct {
+	// Mode is one of none, static (token read once from TokenFile), file
+	// (TokenFile re-read when it changes) or jwt (a JWT naming the run and
+	// task type, signed with JWTKeyFile).
+	Mode      string `yaml:"mode"`
+	TokenFile string `yaml:"token_file"`
+	// JWTKeyFile holds an RSA, ECDSA or Ed25519 private key in PEM form, or
+	// else an HMAC secret.
+	JWTKeyFile  string        `yaml:"jwt_key_file"`
+	JWTIssuer   string        `yaml:"jwt_issuer"`
+	JWTAudience string        `yaml:"jwt_audience"`
+	JWTTTL      time.Duration `yaml:"jwt_ttl"`
+}
+
+// SpoolConfig keeps batches the ES sink did not accept on disk until they
+// can be replayed.
+type SpoolConfig struct {
+	// Dir holds one directory of segment files per run. Empty disables the
+	// spool.
+	Dir          string `yaml:"dir"`
+	MaxBytes     int    `yaml:"max_bytes"`
+	SegmentBytes int    `yaml:"segment_bytes"`
+	// Policy applies when the spool is full: block until replay frees space,
+	// drop-oldest or fail the new batch.
+	Policy string `yaml:"policy"`
+	// BlockTimeout is how long the block policy waits before failing the
+	// new batch.
+	BlockTimeout time.Duration `yaml:"block_timeout"`
+}
+
+// PipelineConfig posts results to an HTTP ingestion pipeline, such as
+// OpenSearch Ingestion or Data Prepper.
+type PipelineConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	Gzip     bool   `yaml:"gzip"`
+	// AwsRegion enables SigV4 signing. It and AssumeRoleArn default to the
+	// OpenSearch ones.
+	AwsRegion     string `yaml:"aws_region"`
+	AssumeRoleArn string `yaml:"assume_role_arn"`
+	SigV4Service  string `yaml:"sigv4_service"`
+}
+
+// ReconcileConfig removes, after a run, the documents an earlier run of the
+// same task wrote but this one did not.
+type ReconcileConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DryRun only logs what would be removed.
+	DryRun bool `yaml:"dry_run"`
+	// Mode is delete, to delete the documents, or tombstone, to write them
+	// again through the sinks marked as deleted.
+	Mode string `yaml:"mode"`
+	// MaxDeletePercent aborts reconciling an index when more than this
+	// percentage of the task's documents in it would be removed.
+	MaxDeletePercent int `yaml:"max_delete_percent"`
+}
+
+type ResultsConfig struct {
+	// GRPCServerURL is the results server tasks can deliver to through the
+	// run's ResultsClient. It is not dialed when empty.
+	GRPCServerURL string `yaml:"grpc_server_url"`
+	// Sinks is a comma separated list of where task results are written:
+	// grpc (the ES sink service), opensearch, pipeline, ndjson or stdout.
+	Sinks string `yaml:"sinks"`
+	// Dedup collapses results with the same ES ID and index that are
+	// flushed together into the one sent last.
+	Dedup bool `yaml:"dedup"`
+	// NDJSONFile is the file the ndjson sink appends to.
+	NDJSONFile string         `yaml:"ndjson_file"`
+	Pipeline   PipelineConfig `yaml:"pipeline"`
+	// TLS and Auth apply to both the ES sink and the results server.
+	TLS   TLSConfig   `yaml:"tls"`
+	Auth  AuthConfig  `yaml:"auth"`
+	Spool SpoolConfig `yaml:"spool"`
+	// Reconcile needs the OpenSearch client to find stale documents.
+	Reconcile ReconcileConfig `yaml:"reconcile"`
+}
+
+// Config is the full worker configuration. Values are resolved from
+// defaults, then the YAML file, then environment variables and finally
+// command line flags.
+type Config struct {
+	Nats          NatsConfig          `yaml:"nats"`
+	ElasticSearch ElasticSearchConfig `yaml:"elasticsearch"`
+	Worker        WorkerConfig        `yaml:"worker"`
+	Health        HealthConfig        `yaml:"health"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	Results       ResultsConfig       `yaml:"results"`
+
+	InventoryServiceEndpoint string `yaml:"inventory_service_endpoint"`
+}
+
+// binding ties a config field to its environment variable and flag. Redacted
+// masks a secret field, and the userinfo of the URLs in a url field.
+type binding struct {
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	url    bool
+	target any
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{env: consts.NatsURLEnv, flag: "nats-url", usage: "NATS server URL", url: true, target: &c.Nats.URL},
+		{env: consts.NatsConsumerEnv, flag: "nats-consumer", usage: "JetStream consumer name", target: &c.Nats.Consumer},
+		{env: consts.NatsStreamNameEnv, flag: "nats-stream", usage: "JetStream stream name", target: &c.Nats.StreamName},
+		{env: consts.NatsTopicNameEnv, flag: "nats-topic", usage: "Subject task requests are consumed from", target: &c.Nats.TopicName},
+		{env: consts.NatsResultTopicNameEnv, flag: "nats-result-topic", usage: "Subject task responses are published to", target: &c.Nats.ResultTopicName},
+		{env: "DEAD_LETTER_TOPIC_NAME", flag: "nats-dead-letter-topic", usage: "Subject failed jobs are moved to (default <topic>.dead-letter)", target: &c.Nats.DeadLetterTopicName},
+
+		{env: consts.ElasticSearchAddressEnv, flag: "es-address", usage: "Elasticsearch/OpenSearch address", url: true, target: &c.ElasticSearch.Address},
+		{env: consts.ElasticSearchUsernameEnv, flag: "es-username", usage: "Elasticsearch username", target: &c.ElasticSearch.Username},
+		{env: consts.ElasticSearchPasswordEnv, flag: "es-password", usage: "Elasticsearch password", secret: true, target: &c.ElasticSearch.Password},
+		{env: consts.ElasticSearchIsOnAksNameEnv, flag: "es-is-on-aks", usage: "Elasticsearch runs on AKS", target: &c.ElasticSearch.IsOnAks},
+		{env: consts.ElasticSearchIsOpenSearch, flag: "es-is-opensearch", usage: "The cluster is OpenSearch", target: &c.ElasticSearch.IsOpenSearch},
+		{env: consts.ElasticSearchAwsRegionEnv, flag: "es-aws-region", usage: "AWS region of the OpenSearch domain", target: &c.ElasticSearch.AwsRegion},
+		{env: consts.ElasticSearchAssumeRoleArnEnv, flag: "es-assume-role-arn", usage: "Role to assume for OpenSearch", secret: true, target: &c.ElasticSearch.AssumeRoleArn},
+
+		{env: "WORKER_CONCURRENCY", flag: "worker-concurrency", usage: "Number of jobs run in parallel", target: &c.Worker.Concurrency},
+		{env: "WORKER_MAX_DELIVER", flag: "worker-max-deliver", usage: "Delivery attempts before a job is dead-lettered", target: &c.Worker.MaxDeliver},
+		{env: "WORKER_OUTBOX_DIR", flag: "worker-outbox-dir", usage: "Directory task responses are kept in until published, empty to disable", target: &c.Worker.OutboxDir},
+		{env: "WORKER_DRAIN_TIMEOUT", flag: "worker-drain-timeout", usage: "How long a shutdown waits for running jobs before cancelling them", target: &c.Worker.DrainTimeout},
+
+		{env: "HEALTH_ADDRESS", flag: "health-address", usage: "Address of the health and metrics endpoints, empty to disable", target: &c.Health.Address},
+		{env: "HEALTH_HEARTBEAT_TIMEOUT", flag: "health-heartbeat-timeout", usage: "Fail liveness when a job heartbeat stalls this long", target: &c.Health.HeartbeatTimeout},
+
+		{env: "OTEL_TRACES_EXPORTER", flag: "tracing-exporter", usage: "Trace exporter: none, otlp, stdout or file", target: &c.Tracing.Exporter},
+		{env: "OTEL_EXPORTER_OTLP_ENDPOINT", flag: "tracing-otlp-endpoint", usage: "OTLP gRPC endpoint traces are exported to", url: true, target: &c.Tracing.OTLPEndpoint},
+		{env: "OTEL_EXPORTER_OTLP_INSECURE", flag: "tracing-otlp-insecure", usage: "Export traces over plaintext gRPC", target: &c.Tracing.OTLPInsecure},
+		{env: "TRACING_OUTPUT_FILE", flag: "tracing-output-file", usage: "File the file trace exporter appends to", target: &c.Tracing.OutputFile},
+		{env: "OTEL_SERVICE_NAME", flag: "tracing-service-name", usage: "Service name reported in traces", target: &c.Tracing.ServiceName},
+
+		{env: "GRPC_SERVER_URL", flag: "results-grpc-url", usage: "Task results gRPC server URL", url: true, target: &c.Results.GRPCServerURL},
+		{env: "RESULTS_SINKS", flag: "results-sinks", usage: "Comma separated result sinks: grpc, opensearch, pipeline, ndjson or stdout", target: &c.Results.Sinks},
+		{env: "RESULTS_DEDUP", flag: "results-dedup", usage: "Collapse results with the same ES ID and index flushed together into the one sent last", target: &c.Results.Dedup},
+		{env: "RESULTS_NDJSON_FILE", flag: "results-ndjson-file", usage: "File the ndjson result sink appends to", target: &c.Results.NDJSONFile},
+		{env: "RESULTS_PIPELINE_ENDPOINT", flag: "results-pipeline-endpoint", usage: "HTTP ingestion pipeline URL the pipeline sink posts to", url: true, target: &c.Results.Pipeline.Endpoint},
+		{env: "RESULTS_PIPELINE_GZIP", flag: "results-pipeline-gzip", usage: "Gzip requests to the ingestion pipeline", target: &c.Results.Pipeline.Gzip},
+		{env: "RESULTS_PIPELINE_AWS_REGION", flag: "results-pipeline-aws-region", usage: "AWS region requests to the ingestion pipeline are signed for (default the OpenSearch one)", target: &c.Results.Pipeline.AwsRegion},
+		{env: "RESULTS_PIPELINE_ASSUME_ROLE_ARN", flag: "results-pipeline-assume-role-arn", usage: "Role to assume for the ingestion pipeline (default the OpenSearch one)", secret: true, target: &c.Results.Pipeline.AssumeRoleArn},
+		{env: "RESULTS_PIPELINE_SIGV4_SERVICE", flag: "results-pipeline-sigv4-service", usage: "Service name requests to the ingestion pipeline are signed for", target: &c.Results.Pipeline.SigV4Service},
+		{env: "RESULTS_TLS_INSECURE", flag: "results-tls-insecure", usage: "Send results over plaintext gRPC", target: &c.Results.TLS.Insecure},
+		{env: "RESULTS_TLS_CA_FILE", flag: "results-tls-ca-file", usage: "CA bundle used to verify the result servers", target: &c.Results.TLS.CAFile},
+		{env: "RESULTS_TLS_CERT_FILE", flag: "results-tls-cert-file", usage: "Client certificate for mTLS to the result servers", target: &c.Results.TLS.CertFile},
+		{env: "RESULTS_TLS_KEY_FILE", flag: "results-tls-key-file", usage: "Client key for mTLS to the result servers", secret: true, target: &c.Results.TLS.KeyFile},
+		{env: "RESULTS_TLS_SERVER_NAME", flag: "results-tls-server-name", usage: "Override the server name verified on the result servers", target: &c.Results.TLS.ServerName},
+		{env: "RESULTS_SPOOL_DIR", flag: "results-spool-dir", usage: "Directory undelivered result batches are spooled to, empty to disable", target: &c.Results.Spool.Dir},
+		{env: "RESULTS_SPOOL_MAX_BYTES", flag: "results-spool-max-bytes", usage: "Size cap of the spool of one run", target: &c.Results.Spool.MaxBytes},
+		{env: "RESULTS_SPOOL_SEGMENT_BYTES", flag: "results-spool-segment-bytes", usage: "Size at which a new spool segment file is started", target: &c.Results.Spool.SegmentBytes},
+		{env: "RESULTS_SPOOL_POLICY", flag: "results-spool-policy", usage: "What to do when the spool is full: block, drop-oldest or fail", target: &c.Results.Spool.Policy},
+		{env: "RESULTS_SPOOL_BLOCK_TIMEOUT", flag: "results-spool-block-timeout", usage: "How long the block spool policy waits for space before failing the batch", target: &c.Results.Spool.BlockTimeout},
+		{env: "RESULTS_RECONCILE", flag: "results-reconcile", usage: "Remove documents earlier runs of the task wrote but this run did not", target: &c.Results.Reconcile.Enabled},
+		{env: "RESULTS_RECONCILE_DRY_RUN", flag: "results-reconcile-dry-run", usage: "Only log the stale documents reconciliation would remove", target: &c.Results.Reconcile.DryRun},
+		{env: "RESULTS_RECONCILE_MODE", flag: "results-reconcile-mode", usage: "How stale documents are removed: delete or tombstone", target: &c.Results.Reconcile.Mode},
+		{env: "RESULTS_RECONCILE_MAX_DELETE_PERCENT", flag: "results-reconcile-max-delete-percent", usage: "Abort reconciling an index when more than this percentage of its documents would be removed", target: &c.Results.Reconcile.MaxDeletePercent},
+		{env: "RESULTS_AUTH_MODE", flag: "results-auth-mode", usage: "Result server authentication: none, static, file or jwt", target: &c.Results.Auth.Mode},
+		{env: "RESULTS_AUTH_TOKEN_FILE", flag: "results-auth-token-file", usage: "File holding the bearer token for the static and file modes", secret: true, target: &c.Results.Auth.TokenFile},
+		{env: "RESULTS_AUTH_JWT_KEY_FILE", flag: "results-auth-jwt-key-file", usage: "Private key or HMAC secret run JWTs are signed with", secret: true, target: &c.Results.Auth.JWTKeyFile},
+		{env: "RESULTS_AUTH_JWT_ISSUER", flag: "results-auth-jwt-issuer", usage: "Issuer of run JWTs", target: &c.Results.Auth.JWTIssuer},
+		{env: "RESULTS_AUTH_JWT_AUDIENCE", flag: "results-auth-jwt-audience", usage: "Audience of run JWTs", target: &c.Results.Auth.JWTAudience},
+		{env: "RESULTS_AUTH_JWT_TTL", flag: "results-auth-jwt-ttl", usage: "Lifetime of run JWTs", target: &c.Results.Auth.JWTTTL},
+
+		{env: consts.InventoryBaseURL, flag: "inventory-endpoint", usage: "Inventory service base URL", url: true, target: &c.InventoryServiceEndpoint},
+	}
+}
+
+// Default returns the configuration used before any source is applied.
+func Default() Config {
+	return Config{
+		Worker: WorkerConfig{
+			Concurrency:  DefaultConcurrency,
+			MaxDeliver:   DefaultMaxDeliver,
+			DrainTimeout: DefaultDrainTimeout,
+		},
+		Health: HealthConfig{
+			Address:          DefaultHealthAddress,
+			HeartbeatTimeout: DefaultHealthHeartbeatTimeout,
+		},
+		Tracing: TracingConfig{
+			Exporter:    DefaultTracingExporter,
+			ServiceName: DefaultTracingServiceName,
+		},
+		Results: ResultsConfig{
+			Sinks: DefaultResultsSinks,
+			Dedup: DefaultResultsDedup,
+			Pipeline: PipelineConfig{
+				SigV4Service: DefaultPipelineSigV4Service,
+			},
+			Spool: SpoolConfig{
+				MaxBytes:     DefaultSpoolMaxBytes,
+				SegmentBytes: DefaultSpoolSegmentBytes,
+				Policy:       DefaultSpoolPolicy,
+				BlockTimeout: DefaultSpoolBlockTimeout,
+			},
+			Auth: AuthConfig{
+				Mode:   DefaultAuthMode,
+				JWTTTL: DefaultAuthJWTTTL,
+			},
+			Reconcile: ReconcileConfig{
+				Mode:             DefaultReconcileMode,
+				MaxDeletePercent: DefaultReconcileMaxDeletePercent,
+			},
+		},
+	}
+}
+
+// AddFlags registers the config file flag and one flag per config field.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.String("config", "", fmt.Sprintf("Path to a YAML config file (env %s)", ConfigFileEnv))
+
+	defaults := Default()
+	for _, b := range defaults.bindings() {
+		usage := fmt.Sprintf("%s (env %s)", b.usage, b.env)
+		switch t := b.target.(type) {
+		case *string:
+			fs.String(b.flag, *t, usage)
+		case *int:
+			fs.Int(b.flag, *t, usage)
+		case *bool:
+			fs.Bool(b.flag, *t, usage)
+		case *time.Duration:
+			fs.Duration(b.flag, *t, usage)
+		}
+	}
+}
+
+// Load resolves the configuration from all sources. fs may be nil, in which
+// case flags are not consulted.
+func Load(fs *pflag.FlagSet) (Config, error) {
+	cfg := Default()
+
+	path := os.Getenv(ConfigFileEnv)
+	if fs != nil && fs.Changed("config") {
+		path, _ = fs.GetString("config")
+	}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	for _, b := range cfg.bindings() {
+		if value, ok := os.LookupEnv(b.env); ok && value != "" {
+			if err := setValue(b.target, value); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", b.env, err)
+			}
+		}
+		if fs != nil && fs.Lookup(b.flag) != nil && fs.Changed(b.flag) {
+			if err := setValue(b.target, fs.Lookup(b.flag).Value.String()); err != nil {
+				return cfg, fmt.Errorf("invalid --%s: %w", b.flag, err)
+			}
+		}
+	}
+
+	if cfg.Nats.DeadLetterTopicName == "" && cfg.Nats.TopicName != "" {
+		cfg.Nats.DeadLetterTopicName = cfg.Nats.TopicName + ".dead-letter"
+	}
+	if cfg.Results.Pipeline.AwsRegion == "" {
+		cfg.Results.Pipeline.AwsRegion = cfg.ElasticSearch.AwsRegion
+	}
+	if cfg.Results.Pipeline.AssumeRoleArn == "" {
+		cfg.Results.Pipeline.AssumeRoleArn = cfg.ElasticSearch.AssumeRoleArn
+	}
+
+	return cfg, nil
+}
+
+func setValue(target any, value string) error {
+	switch t := target.(type) {
+	case *string:
+		*t = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	default:
+		return fmt.Errorf("unsupported config type %T", target)
+	}
+	return nil
+}
+
+// Validate checks everything the worker needs to connect and consume.
+func (c Config) Validate() error {
+	var errs []error
+	required := map[string]string{
+		consts.NatsURLEnv:              c.Nats.URL,
+		consts.NatsConsumerEnv:         c.Nats.Consumer,
+		consts.NatsStreamNameEnv:       c.Nats.StreamName,
+		consts.NatsTopicNameEnv:        c.Nats.TopicName,
+		consts.NatsResultTopicNameEnv:  c.Nats.ResultTopicName,
+		consts.ElasticSearchAddressEnv: c.ElasticSearch.Address,
+	}
+	for _, b := range c.bindings() {
+		if value, ok := required[b.env]; ok && value == "" {
+			errs = append(errs, fmt.Errorf("%s (--%s) is required", b.env, b.flag))
+		}
+	}
+	if c.Worker.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("worker concurrency must be at least 1, got %d", c.Worker.Concurrency))
+	}
+	if c.Worker.MaxDeliver < 1 {
+		errs = append(errs, fmt.Errorf("worker max deliver must be at least 1, got %d", c.Worker.MaxDeliver))
+	}
+	if c.Worker.DrainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("worker drain timeout must not be negative, got %s", c.Worker.DrainTimeout))
+	}
+	if c.Health.HeartbeatTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("health heartbeat timeout must be positive, got %s", c.Health.HeartbeatTimeout))
+	}
+	if (c.Results.TLS.CertFile == "") != (c.Results.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("results TLS cert file and key file must be set together"))
+	}
+	sinks := 0
+	for _, sink := range strings.Split(c.Results.Sinks, ",") {
+		switch sink = strings.TrimSpace(sink); sink {
+		case "":
+			continue
+		case "grpc", "opensearch", "stdout":
+		case "ndjson":
+			if c.Results.NDJSONFile == "" {
+				errs = append(errs, errors.New("results NDJSON file is required for the ndjson sink"))
+			}
+		case "pipeline":
+			if c.Results.Pipeline.Endpoint == "" {
+				errs = append(errs, errors.New("results pipeline endpoint is required for the pipeline sink"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown results sink %q", sink))
+		}
+		sinks++
+	}
+	if sinks == 0 {
+		errs = append(errs, errors.New("at least one results sink is required"))
+	}
+	if c.Results.Spool.Dir != "" {
+		switch c.Results.Spool.Policy {
+		case "block", "drop-oldest", "fail":
+		default:
+			errs = append(errs, fmt.Errorf("unknown results spool policy %q", c.Results.Spool.Policy))
+		}
+		if c.Results.Spool.MaxBytes <= 0 || c.Results.Spool.SegmentBytes <= 0 {
+			errs = append(errs, errors.New("results spool max bytes and segment bytes must be positive"))
+		}
+		if c.Results.Spool.Policy == "block" && c.Results.Spool.BlockTimeout <= 0 {
+			errs = append(errs, errors.New("results spool block timeout must be positive"))
+		}
+	}
+	if c.Results.Reconcile.Enabled {
+		switch c.Results.Reconcile.Mode {
+		case "delete", "tombstone":
+		default:
+			errs = append(errs, fmt.Errorf("unknown results reconcile mode %q", c.Results.Reconcile.Mode))
+		}
+		if c.Results.Reconcile.MaxDeletePercent < 0 || c.Results.Reconcile.MaxDeletePercent > 100 {
+			errs = append(errs, fmt.Errorf("results reconcile max delete percent must be between 0 and 100, got %d", c.Results.Reconcile.MaxDeletePercent))
+		}
+	}
+	switch c.Results.Auth.Mode {
+	case "", "none":
+	case "static", "file":
+		if c.Results.Auth.TokenFile == "" {
+			errs = append(errs, fmt.Errorf("results auth token file is required for the %s mode", c.Results.Auth.Mode))
+		}
+	case "jwt":
+		if c.Results.Auth.JWTKeyFile == "" {
+			errs = append(errs, errors.New("results auth JWT key file is required for the jwt mode"))
+		}
+		if c.Results.Auth.JWTTTL <= MinAuthJWTTTL {
+			errs = append(errs, fmt.Errorf("results auth JWT TTL must be longer than %s, got %s", MinAuthJWTTTL, c.Results.Auth.JWTTTL))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown results auth mode %q", c.Results.Auth.Mode))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	case "file":
+		if c.Tracing.OutputFile == "" {
+			errs = append(errs, errors.New("tracing output file is required for the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown trace exporter %q", c.Tracing.Exporter))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the config that is safe to log, with every
+// secret, and the credentials in every URL, replaced by a placeholder.
+func (c Config) Redacted() Config {
+	out := c
+	for _, b := range out.bindings() {
+		t, ok := b.target.(*string)
+		switch {
+		case !ok || *t == "":
+		case b.secret:
+			*t = SecretMask
+		case b.url:
+			*t = redactURLs(*t)
+		}
+	}
+	return out
+}
+
+// redactURLs masks the userinfo of each URL in the comma separated list s.
+// One that may hold credentials but has no userinfo to mask, like a URL
+// without a scheme, is masked whole.
+func redactURLs(s string) string {
+	urls := strings.Split(s, ",")
+	for i, raw := range urls {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		switch {
+		case err == nil && u.User != nil:
+			u.User = nil
+			urls[i] = u.Scheme + "://" + SecretMask + "@" + strings.TrimPrefix(u.String(), u.Scheme+"://")
+		case strings.Contains(raw, "@"):
+			urls[i] = SecretMask
+		}
+	}
+	return strings.Join(urls, ",")
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "og_task"
+
+var (
+	JobsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "jobs_received_total",
+		Help:      "Number of task jobs received from the queue.",
+	})
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of task jobs by final status.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200},
+	}, []string{"status"})
+	HeartbeatFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "heartbeat_failures_total",
+		Help:      "Number of InProgress ack extensions that failed.",
+	})
+	JobPanics = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "job_panics_total",
+		Help:      "Number of task jobs that panicked.",
+	})
+	CancellationRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "cancellation_requests_total",
+		Help:      "Number of task run cancellation requests received.",
+	})
+	OutboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "outbox_pending",
+		Help:      "Number of task responses waiting in the outbox to be published.",
+	})
+
+	BatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "batch_size",
+		Help:      "Number of documents per batch sent to the ES sink.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+	FlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "flush_duration_seconds",
+		Help:      "Time taken to flush a batch to the ES sink.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	IngestErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "ingest_errors_total",
+		Help:      "Number of failed Ingest calls to the ES sink.",
+	})
+	Reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "reconnects_total",
+		Help:      "Number of reconnects to the ES sink or the results server.",
+	})
+	IngestRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "ingest_retries_total",
+		Help:      "Number of retried Ingest calls to the ES sink.",
+	})
+	UndeliveredResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "undelivered_total",
+		Help:      "Number of results that were not delivered, by reason.",
+	}, []string{"reason"})
+	CollapsedResults = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "collapsed_total",
+		Help:      "Number of results merged into another one with the same ES ID before sending.",
+	})
+	SendBlockedDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "send_blocked_duration_seconds",
+		Help:      "Time tasks were blocked sending a result because the sender buffer was full.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+	SendRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "send_rejections_total",
+		Help:      "Number of results TrySend rejected because the sender buffer was full.",
+	})
+	SpoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "spool_bytes",
+		Help:      "Size of the batches waiting in the on-disk spool to be replayed.",
+	})
+	StaleResources = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "stale_resources_total",
+		Help:      "Number of stale documents found by reconciliation, by what was done with them.",
+	}, []string{"action"})
+	GRPCSendRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "results",
+		Name:      "grpc_send_retries_total",
+		Help:      "Number of retried attempts to deliver task results over gRPC.",
+	})
+)
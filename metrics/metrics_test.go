@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"testing"
+)
+
+func TestMetricsAreRegistered(t *testing.T) {
+	// Vectors are only gathered once they have a child.
+	JobDuration.WithLabelValues("FINISHED")
+	UndeliveredResults.WithLabelValues("failed")
+	StaleResources.WithLabelValues("deleted")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := make(map[string]string)
+	for _, family := range families {
+		gathered[family.GetName()] = family.GetType().String()
+		if strings.HasPrefix(family.GetName(), namespace+"_") && family.GetHelp() == "" {
+			t.Errorf("%s has no help text", family.GetName())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		wantType string
+	}{
+		{name: "og_task_worker_jobs_received_total", wantType: "COUNTER"},
+		{name: "og_task_worker_job_duration_seconds", wantType: "HISTOGRAM"},
+		{name: "og_task_worker_heartbeat_failures_total", wantType: "COUNTER"},
+		{name: "og_task_worker_job_panics_total", wantType: "COUNTER"},
+		{name: "og_task_worker_cancellation_requests_total", wantType: "COUNTER"},
+		{name: "og_task_worker_outbox_pending", wantType: "GAUGE"},
+		{name: "og_task_results_batch_size", wantType: "HISTOGRAM"},
+		{name: "og_task_results_flush_duration_seconds", wantType: "HISTOGRAM"},
+		{name: "og_task_results_ingest_errors_total", wantType: "COUNTER"},
+		{name: "og_task_results_reconnects_total", wantType: "COUNTER"},
+		{name: "og_task_results_ingest_retries_total", wantType: "COUNTER"},
+		{name: "og_task_results_undelivered_total", wantType: "COUNTER"},
+		{name: "og_task_results_collapsed_total", wantType: "COUNTER"},
+		{name: "og_task_results_send_blocked_duration_seconds", wantType: "HISTOGRAM"},
+		{name: "og_task_results_send_rejections_total", wantType: "COUNTER"},
+		{name: "og_task_results_spool_bytes", wantType: "GAUGE"},
+		{name: "og_task_results_stale_resources_total", wantType: "COUNTER"},
+		{name: "og_task_results_grpc_send_retries_total", wantType: "COUNTER"},
+	}
+	for _, tt := range tests {
+		if got, ok := gathered[tt.name]; !ok || got != tt.wantType {
+			t.Errorf("%s gathered as %q, want %s", tt.name, got, tt.wantType)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package results
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opengovern/og-task-template/config"
+	"google.golang.org/grpc/credentials"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthModeNone   = "none"
+	AuthModeStatic = "static"
+	AuthModeFile   = "file"
+	AuthModeJWT    = "jwt"
+
+	// jwtRefreshMargin is how long before expiry a cached JWT is replaced.
+	jwtRefreshMargin = 30 * time.Second
+)
+
+// RunInfo identifies the task run an RPC is made for.
+type RunInfo struct {
+	RunID    uint
+	TaskType string
+}
+
+type runInfoKey struct{}
+
+// WithRunInfo returns a context whose RPCs are authenticated for the run.
+func WithRunInfo(ctx context.Context, info RunInfo) context.Context {
+	return context.WithValue(ctx, runInfoKey{}, info)
+}
+
+func RunInfoFromContext(ctx context.Context) (RunInfo, bool) {
+	info, ok := ctx.Value(runInfoKey{}).(RunInfo)
+	return info, ok
+}
+
+// TokenSource returns the bearer token for one RPC.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// PerRPCCredentials builds the credentials attached to every call to the
+// result servers, or nil when authentication is disabled. requireTLS refuses
+// to send tokens over plaintext connections.
+func PerRPCCredentials(cfg config.AuthConfig, requireTLS bool) (credentials.PerRPCCredentials, error) {
+	var source TokenSource
+	switch cfg.Mode {
+	case "", AuthModeNone:
+		return nil, nil
+	case AuthModeStatic:
+		content, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file: %w", err)
+		}
+		source = staticTokenSource(strings.TrimSpace(string(content)))
+	case AuthModeFile:
+		fileSource := &fileTokenSource{path: cfg.TokenFile}
+		if _, err := fileSource.Token(context.Background()); err != nil {
+			return nil, err
+		}
+		source = fileSource
+	case AuthModeJWT:
+		jwtSource, err := newJWTTokenSource(cfg)
+		if err != nil {
+			return nil, err
+		}
+		source = jwtSource
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Mode)
+	}
+	return &bearerCredentials{source: source, requireTLS: requireTLS}, nil
+}
+
+type bearerCredentials struct {
+	source     TokenSource
+	requireTLS bool
+}
+
+func (c *bearerCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (c *bearerCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
+
+// String describes the credentials without the token, so they are safe to
+// log. The token sources below do the same.
+func (c *bearerCredentials) String() string {
+	return fmt.Sprintf("bearer(%v)", c.source)
+}
+
+func (c *bearerCredentials) GoString() string { return c.String() }
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+func (s staticTokenSource) String() string {
+	return "static(" + config.SecretMask + ")"
+}
+
+func (s staticTokenSource) GoString() string { return s.String() }
+
+// fileTokenSource re-reads the token whenever its file changes, which suits
+// projected service account tokens that the kubelet rotates in place.
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+func (s *fileTokenSource) Token(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if s.token != "" {
+			return s.token, nil
+		}
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	if s.token != "" && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		if s.token != "" {
+			return s.token, nil
+		}
+		return "", fmt.Errorf("token file %s is empty", s.path)
+	}
+	s.token, s.modTime = token, info.ModTime()
+	return s.token, nil
+}
+
+func (s *fileTokenSource) String() string {
+	return fmt.Sprintf("file(%s, %s)", s.path, config.SecretMask)
+}
+
+func (s *fileTokenSource) GoString() string { return s.String() }
+
+// jwtTokenSource signs a short-lived JWT naming the run and task type, taken
+// from the RPC context, and caches it until shortly before it expires.
+type jwtTokenSource struct {
+	method   jwt.SigningMethod
+	key      any
+	issuer   string
+	audience string
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	cached map[RunInfo]cachedToken
+}
+
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// runClaims are the claims of a run JWT.
+type runClaims struct {
+	RunID    uint   `json:"run_id"`
+	TaskType string `json:"task_type"`
+	jwt.RegisteredClaims
+}
+
+func newJWTTokenSource(cfg config.AuthConfig) (*jwtTokenSource, error) {
+	content, err := os.ReadFile(cfg.JWTKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT signing key: %w", err)
+	}
+	method, key, err := parseSigningKey(content)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.JWTTTL <= config.MinAuthJWTTTL {
+		return nil, fmt.Errorf("JWT TTL must be longer than %s, got %s", config.MinAuthJWTTTL, cfg.JWTTTL)
+	}
+	return &jwtTokenSource{
+		method:   method,
+		key:      key,
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+		ttl:      cfg.JWTTTL,
+		cached:   make(map[RunInfo]cachedToken),
+	}, nil
+}
+
+// parseSigningKey accepts an RSA, ECDSA (P-256, P-384 or P-521) or Ed25519
+// private key in PEM form, and otherwise uses the file content as an HMAC
+// secret.
+func parseSigningKey(content []byte) (jwt.SigningMethod, any, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		secret := []byte(strings.TrimSpace(string(content)))
+		if len(secret) == 0 {
+			return nil, nil, errors.New("JWT signing key is empty")
+		}
+		return jwt.SigningMethodHS256, secret, nil
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JWT signing key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, k, nil
+	case *ecdsa.PrivateKey:
+		// Each ES algorithm is defined for one curve only.
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, k, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, k, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, k, nil
+		default:
+			return nil, nil, fmt.Errorf("unsupported JWT signing key curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, k, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported JWT signing key type %T", key)
+	}
+}
+
+func (s *jwtTokenSource) String() string {
+	return fmt.Sprintf("jwt(%s, key %s)", s.method.Alg(), config.SecretMask)
+}
+
+func (s *jwtTokenSource) GoString() string { return s.String() }
+
+func (s *jwtTokenSource) Token(ctx context.Context) (string, error) {
+	info, _ := RunInfoFromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if cached, ok := s.cached[info]; ok && now.Add(jwtRefreshMargin).Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	expiresAt := now.Add(s.ttl)
+	claims := runClaims{
+		RunID:    info.RunID,
+		TaskType: info.TaskType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   "task-run/" + strconv.FormatUint(uint64(info.RunID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	if s.audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.audience}
+	}
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	// Runs are short-lived, so drop what expired instead of growing forever.
+	for key, cached := range s.cached {
+		if now.After(cached.expiresAt) {
+			delete(s.cached, key)
+		}
+	}
+	s.cached[info] = cachedToken{token: token, expiresAt: expiresAt}
+	return token, nil
+}
@@ -0,0 +1,274 @@
+package results
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opengovern/og-task-template/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to a new file in the test's temp dir.
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTTTLMatchesConfig(t *testing.T) {
+	keyFile := writeFile(t, "hmac", []byte("secret"))
+	tests := []struct {
+		ttl     time.Duration
+		wantErr bool
+	}{
+		{ttl: 30 * time.Second, wantErr: true},
+		{ttl: config.MinAuthJWTTTL, wantErr: true},
+		{ttl: config.MinAuthJWTTTL + time.Second},
+		{ttl: config.DefaultAuthJWTTTL},
+	}
+	for _, tt := range tests {
+		cfg := config.AuthConfig{Mode: AuthModeJWT, JWTKeyFile: keyFile, JWTTTL: tt.ttl}
+		_, err := PerRPCCredentials(cfg, true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PerRPCCredentials(ttl %s) error = %v, wantErr %v", tt.ttl, err, tt.wantErr)
+		}
+
+		// Validate must agree with the signer on every TTL.
+		c := config.Default()
+		c.Results.Auth = cfg
+		if validateErr := c.Validate(); tt.wantErr != strings.Contains(fmt.Sprint(validateErr), "JWT TTL") {
+			t.Errorf("Validate(ttl %s) error = %v, wantErr %v", tt.ttl, validateErr, tt.wantErr)
+		}
+	}
+}
+
+func TestCredentialsAreMasked(t *testing.T) {
+	const secret = "s3cr3t-token-value"
+	tokenFile := writeFile(t, "token", []byte(secret))
+
+	tests := []struct {
+		name string
+		cfg  config.AuthConfig
+	}{
+		{name: "static", cfg: config.AuthConfig{Mode: AuthModeStatic, TokenFile: tokenFile}},
+		{name: "file", cfg: config.AuthConfig{Mode: AuthModeFile, TokenFile: tokenFile}},
+		{name: "jwt", cfg: config.AuthConfig{Mode: AuthModeJWT, JWTKeyFile: tokenFile, JWTTTL: config.DefaultAuthJWTTTL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := PerRPCCredentials(tt.cfg, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+				if out := fmt.Sprintf(verb, creds); strings.Contains(out, secret) || !strings.Contains(out, config.SecretMask) {
+					t.Errorf("Sprintf(%s) = %s, want the secret masked", verb, out)
+				}
+			}
+		})
+	}
+}
+
+func pemBlock(t *testing.T, blockType string, der []byte, err error) []byte {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func ecKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM := func(curve elliptic.Curve) []byte {
+		der, err := x509.MarshalECPrivateKey(ecKey(t, curve))
+		return pemBlock(t, "EC PRIVATE KEY", der, err)
+	}
+	pkcs8PEM := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		return pemBlock(t, "PRIVATE KEY", der, err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantAlg string
+		wantErr bool
+	}{
+		{name: "HMAC secret", content: []byte("  secret\n"), wantAlg: "HS256"},
+		{name: "empty HMAC secret", content: []byte(" \n"), wantErr: true},
+		{name: "RSA PKCS#1", content: pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil), wantAlg: "RS256"},
+		{name: "RSA PKCS#8", content: pkcs8PEM(rsaKey), wantAlg: "RS256"},
+		{name: "EC P-256", content: ecPEM(elliptic.P256()), wantAlg: "ES256"},
+		{name: "EC P-384", content: ecPEM(elliptic.P384()), wantAlg: "ES384"},
+		{name: "EC P-521", content: ecPEM(elliptic.P521()), wantAlg: "ES512"},
+		{name: "EC P-384 PKCS#8", content: pkcs8PEM(ecKey(t, elliptic.P384())), wantAlg: "ES384"},
+		{name: "EC P-224", content: ecPEM(elliptic.P224()), wantErr: true},
+		{name: "Ed25519", content: pkcs8PEM(edKey), wantAlg: "EdDSA"},
+		{name: "corrupt PEM", content: pemBlock(t, "PRIVATE KEY", []byte("nope"), nil), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, _, err := parseSigningKey(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSigningKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && method.Alg() != tt.wantAlg {
+				t.Errorf("alg = %s, want %s", method.Alg(), tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestJWTTokenSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     *ecdsa.PrivateKey
+		wantAlg string
+	}{
+		{name: "P-256", key: ecKey(t, elliptic.P256()), wantAlg: "ES256"},
+		{name: "P-384", key: ecKey(t, elliptic.P384()), wantAlg: "ES384"},
+		{name: "P-521", key: ecKey(t, elliptic.P521()), wantAlg: "ES512"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.MarshalECPrivateKey(tt.key)
+			keyFile := writeFile(t, "key.pem", pemBlock(t, "EC PRIVATE KEY", der, err))
+			source, err := newJWTTokenSource(config.AuthConfig{
+				JWTKeyFile:  keyFile,
+				JWTIssuer:   "og-task-template",
+				JWTAudience: "es-sink",
+				JWTTTL:      config.DefaultAuthJWTTTL,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := WithRunInfo(context.Background(), RunInfo{RunID: 42, TaskType: "sbom"})
+			token, err := source.Token(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var claims runClaims
+			parsed, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) { return &tt.key.PublicKey, nil },
+				jwt.WithValidMethods([]string{tt.wantAlg}), jwt.WithIssuer("og-task-template"), jwt.WithAudience("es-sink"))
+			if err != nil || !parsed.Valid {
+				t.Fatalf("token does not verify as %s: %v", tt.wantAlg, err)
+			}
+			if claims.RunID != 42 || claims.TaskType != "sbom" || claims.Subject != "task-run/42" {
+				t.Errorf("claims = %+v", claims)
+			}
+			if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != config.DefaultAuthJWTTTL {
+				t.Errorf("token lives %s, want %s", ttl, config.DefaultAuthJWTTTL)
+			}
+
+			again, err := source.Token(ctx)
+			if err != nil || again != token {
+				t.Errorf("second token for the same run was not cached: %v", err)
+			}
+			other, err := source.Token(WithRunInfo(context.Background(), RunInfo{RunID: 43, TaskType: "sbom"}))
+			if err != nil || other == token {
+				t.Errorf("token for another run is the same one: %v", err)
+			}
+		})
+	}
+}
+
+func TestPerRPCCredentials(t *testing.T) {
+	tokenFile := writeFile(t, "token", []byte("first\n"))
+
+	tests := []struct {
+		name        string
+		cfg         config.AuthConfig
+		requireTLS  bool
+		wantNil     bool
+		wantErr     bool
+		rotate      string
+		wantHeaders []string
+	}{
+		{name: "none", cfg: config.AuthConfig{Mode: AuthModeNone}, wantNil: true},
+		{name: "empty mode", cfg: config.AuthConfig{}, wantNil: true},
+		{name: "unknown mode", cfg: config.AuthConfig{Mode: "oidc"}, wantErr: true},
+		{name: "static missing file", cfg: config.AuthConfig{Mode: AuthModeStatic, TokenFile: tokenFile + ".missing"}, wantErr: true},
+		{name: "file missing file", cfg: config.AuthConfig{Mode: AuthModeFile, TokenFile: tokenFile + ".missing"}, wantErr: true},
+		{
+			name:        "static ignores rotation",
+			cfg:         config.AuthConfig{Mode: AuthModeStatic, TokenFile: tokenFile},
+			requireTLS:  true,
+			rotate:      "second",
+			wantHeaders: []string{"Bearer first", "Bearer first"},
+		},
+		{
+			name:        "file follows rotation",
+			cfg:         config.AuthConfig{Mode: AuthModeFile, TokenFile: tokenFile},
+			rotate:      "second",
+			wantHeaders: []string{"Bearer first", "Bearer second"},
+		},
+		{
+			name:        "file keeps the old token while the new one is empty",
+			cfg:         config.AuthConfig{Mode: AuthModeFile, TokenFile: tokenFile},
+			rotate:      "\n",
+			wantHeaders: []string{"Bearer first", "Bearer first"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeAt(t, tokenFile, []byte("first\n"), time.Now().Add(-time.Hour))
+			creds, err := PerRPCCredentials(tt.cfg, tt.requireTLS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PerRPCCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil || tt.wantNil {
+				if creds != nil {
+					t.Errorf("credentials = %v, want nil", creds)
+				}
+				return
+			}
+			if creds.RequireTransportSecurity() != tt.requireTLS {
+				t.Errorf("RequireTransportSecurity() = %v, want %v", creds.RequireTransportSecurity(), tt.requireTLS)
+			}
+
+			for i, want := range tt.wantHeaders {
+				if i > 0 {
+					writeAt(t, tokenFile, []byte(tt.rotate), time.Now())
+				}
+				md, err := creds.GetRequestMetadata(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if md["authorization"] != want {
+					t.Errorf("call %d authorization = %q, want %q", i, md["authorization"], want)
+				}
+			}
+		})
+	}
+}
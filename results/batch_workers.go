@@ -0,0 +1,70 @@
+package results
+
+import (
+	"hash/fnv"
+)
+
+// startBatchWorkers starts the workers that send batches in parallel. With
+// index ordering each worker has its own queue, and an index always goes to
+// the same one.
+func (s *ResourceSender) startBatchWorkers() {
+	if s.maxInFlight <= 1 {
+		return
+	}
+
+	queues := 1
+	if s.indexOrdering {
+		queues = s.maxInFlight
+	}
+	s.batchQueues = make([]chan docBatch, queues)
+	for i := range s.batchQueues {
+		s.batchQueues[i] = make(chan docBatch)
+	}
+
+	for i := 0; i < s.maxInFlight; i++ {
+		queue := s.batchQueues[i%queues]
+		s.batchWorkers.Add(1)
+		go func() {
+			defer s.batchWorkers.Done()
+			for batch := range queue {
+				s.sendBatchRecovered(batch)
+			}
+		}()
+	}
+}
+
+// sendBatchRecovered sends batch, keeping the worker alive if it panics.
+func (s *ResourceSender) sendBatchRecovered(batch docBatch) {
+	defer s.recoverPanic()
+	s.sendBatch(batch)
+}
+
+// dispatch sends batch on the handler goroutine, or hands it to a batch
+// worker, waiting while all of them are busy.
+func (s *ResourceSender) dispatch(batch docBatch) {
+	if s.batchQueues == nil {
+		s.sendBatch(batch)
+		return
+	}
+
+	queue := s.batchQueues[0]
+	if len(s.batchQueues) > 1 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(batch.Index))
+		queue = s.batchQueues[h.Sum32()%uint32(len(s.batchQueues))]
+	}
+	queue <- batch
+}
+
+// stopBatchWorkers waits for every dispatched batch to finish. It is only
+// called from the handler goroutine.
+func (s *ResourceSender) stopBatchWorkers() {
+	if s.batchQueues == nil {
+		return
+	}
+	for _, queue := range s.batchQueues {
+		close(queue)
+	}
+	s.batchWorkers.Wait()
+	s.batchQueues = nil
+}
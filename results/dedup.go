@@ -0,0 +1,68 @@
+package results
+
+import (
+	"github.com/opengovern/og-util/pkg/es"
+)
+
+// MergeFunc combines two results with the same ES ID and index that were
+// buffered together; previous was sent first. The result it returns is sent
+// in their place, or next when it returns nil.
+type MergeFunc func(previous, next *es.TaskResult) *es.TaskResult
+
+// LastWriteWins keeps the result sent last. It is the default merge.
+func LastWriteWins(_, next *es.TaskResult) *es.TaskResult {
+	return next
+}
+
+// WithDedup collapses results with the same ES ID and index that are flushed
+// together into one, combined with merge, which must not be nil.
+// Deduplication is on by default, with LastWriteWins.
+func WithDedup(merge MergeFunc) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.dedupEnabled = true
+		s.merge = merge
+	}
+}
+
+// WithoutDedup sends every result, even several with the same ES ID and
+// index.
+func WithoutDedup() ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.dedupEnabled = false
+		s.merge = nil
+	}
+}
+
+type dedupKey struct {
+	index string
+	id    string
+}
+
+// dedup collapses resources with the same ES ID and index into the position
+// of the first of them, and counts the others in the delivery report.
+func (s *ResourceSender) dedup(resources []*es.TaskResult) []*es.TaskResult {
+	if !s.dedupEnabled || len(resources) < 2 {
+		return resources
+	}
+
+	positions := make(map[dedupKey]int, len(resources))
+	unique := make([]*es.TaskResult, 0, len(resources))
+	for _, resource := range resources {
+		keys, index := resource.KeysAndIndex()
+		key := dedupKey{index: index, id: es.HashOf(keys...)}
+		if i, ok := positions[key]; ok {
+			if merged := s.merge(unique[i], resource); merged != nil {
+				unique[i] = merged
+			} else {
+				unique[i] = resource
+			}
+			continue
+		}
+		positions[key] = len(unique)
+		unique = append(unique, resource)
+	}
+	if collapsed := len(resources) - len(unique); collapsed > 0 {
+		s.report.collapse(collapsed)
+	}
+	return unique
+}
@@ -0,0 +1,96 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opengovern/og-util/pkg/es"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	result := func(id, resultType, value string) *es.TaskResult {
+		r := testResult(id)
+		r.ResultType = resultType
+		r.Description = map[string]string{"value": value}
+		return r
+	}
+	sent := func() []*es.TaskResult {
+		return []*es.TaskResult{
+			result("a", "t1", "a1"),
+			result("b", "t1", "b1"),
+			result("a", "t1", "a2"),
+			result("a", "t2", "other index"),
+			result("a", "t1", "a3"),
+		}
+	}
+	concat := func(previous, next *es.TaskResult) *es.TaskResult {
+		merged := *next
+		merged.Description = map[string]string{
+			"value": previous.Description.(map[string]string)["value"] + "+" + next.Description.(map[string]string)["value"],
+		}
+		return &merged
+	}
+
+	tests := []struct {
+		name          string
+		opts          []ResourceSenderOption
+		wantValues    []string
+		wantCollapsed int
+	}{
+		{name: "last write wins by default", wantValues: []string{"a3", "b1", "other index"}, wantCollapsed: 2},
+		{name: "turned off", opts: []ResourceSenderOption{WithoutDedup()}, wantValues: []string{"a1", "b1", "a2", "other index", "a3"}},
+		{name: "turned on again", opts: []ResourceSenderOption{WithoutDedup(), WithDedup(LastWriteWins)}, wantValues: []string{"a3", "b1", "other index"}, wantCollapsed: 2},
+		{name: "custom merge", opts: []ResourceSenderOption{WithDedup(concat)}, wantValues: []string{"a1+a2+a3", "b1", "other index"}, wantCollapsed: 2},
+		{
+			name:          "merge returning nil keeps the later result",
+			opts:          []ResourceSenderOption{WithDedup(func(_, _ *es.TaskResult) *es.TaskResult { return nil })},
+			wantValues:    []string{"a3", "b1", "other index"},
+			wantCollapsed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			opts := append([]ResourceSenderOption{WithSink(sink), WithSenderBackoff(fastBackoff)}, tt.opts...)
+			sender, err := NewResourceSender("", 1, false, zap.NewNop(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range sent() {
+				if err := sender.Send(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sender.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			var values []string
+			for _, batch := range sink.batches {
+				for _, doc := range batch {
+					var body struct {
+						Description map[string]string `json:"description"`
+					}
+					if err := json.Unmarshal(doc.Body, &body); err != nil {
+						t.Fatal(err)
+					}
+					values = append(values, body.Description["value"])
+				}
+			}
+			if !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("sent %v, want %v", values, tt.wantValues)
+			}
+			if report := sender.Report(); report.Collapsed != tt.wantCollapsed || report.Sent != len(tt.wantValues) || report.Err() != nil {
+				t.Errorf("report = %+v, want %d collapsed", report, tt.wantCollapsed)
+			}
+		})
+	}
+}
+
+func TestDedupNilMerge(t *testing.T) {
+	if _, err := NewResourceSender("", 1, false, zap.NewNop(), WithSink(&fakeSink{}), WithDedup(nil)); err == nil {
+		t.Error("NewResourceSender() accepted a nil merge function")
+	}
+}
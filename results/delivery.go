@@ -0,0 +1,143 @@
+package results
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/metrics"
+	"slices"
+	"sync"
+)
+
+// DeliveryReport summarizes what a Sender delivered over a task run.
+type DeliveryReport struct {
+	// Sent is the number of results the sink acknowledged.
+	Sent int `json:"sent"`
+	// Failed is the number of results in batches the sink kept rejecting.
+	Failed int `json:"failed"`
+	// Dropped is the number of results that were never sent, for example
+	// because they could not be encoded.
+	Dropped int `json:"dropped"`
+	// Rejected is the number of results larger than the maximum batch size.
+	Rejected int `json:"rejected"`
+	// Collapsed is the number of results merged into another one with the
+	// same ES ID and index before sending. They count as delivered.
+	Collapsed int `json:"collapsed"`
+	// LostSegments is the number of spool segments that could not be read
+	// back. The results in them are in none of the counts above.
+	LostSegments int `json:"lost_segments,omitempty"`
+	// FailedIDs are the resource IDs of every result that was not delivered.
+	FailedIDs []string `json:"failed_ids,omitempty"`
+}
+
+func (r *DeliveryReport) fail(resourceIDs ...string) {
+	r.Failed += len(resourceIDs)
+	r.FailedIDs = append(r.FailedIDs, resourceIDs...)
+	metrics.UndeliveredResults.WithLabelValues("failed").Add(float64(len(resourceIDs)))
+}
+
+func (r *DeliveryReport) drop(resourceIDs ...string) {
+	r.Dropped += len(resourceIDs)
+	r.FailedIDs = append(r.FailedIDs, resourceIDs...)
+	metrics.UndeliveredResults.WithLabelValues("dropped").Add(float64(len(resourceIDs)))
+}
+
+func (r *DeliveryReport) reject(resourceIDs ...string) {
+	r.Rejected += len(resourceIDs)
+	r.FailedIDs = append(r.FailedIDs, resourceIDs...)
+	metrics.UndeliveredResults.WithLabelValues("rejected").Add(float64(len(resourceIDs)))
+}
+
+// undelivered is the number of results that were not delivered.
+func (r DeliveryReport) undelivered() int {
+	return r.Failed + r.Dropped + r.Rejected
+}
+
+// deliveryTracker builds a DeliveryReport from batches sent in parallel.
+type deliveryTracker struct {
+	mu     sync.Mutex
+	report DeliveryReport
+	// lostErr joins the reasons spool segments were lost.
+	lostErr error
+}
+
+func (t *deliveryTracker) sent(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Sent += n
+}
+
+func (t *deliveryTracker) fail(resourceIDs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.fail(resourceIDs...)
+}
+
+func (t *deliveryTracker) drop(resourceIDs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.drop(resourceIDs...)
+}
+
+func (t *deliveryTracker) reject(resourceIDs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.reject(resourceIDs...)
+}
+
+// loseSegment records a spool segment whose results are unaccounted for.
+func (t *deliveryTracker) loseSegment(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.LostSegments++
+	t.lostErr = errors.Join(t.lostErr, err)
+}
+
+// lost returns why spool segments were lost, if any were.
+func (t *deliveryTracker) lost() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lostErr
+}
+
+func (t *deliveryTracker) collapse(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Collapsed += n
+	metrics.CollapsedResults.Add(float64(n))
+}
+
+func (t *deliveryTracker) snapshot() DeliveryReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := t.report
+	report.FailedIDs = slices.Clone(report.FailedIDs)
+	return report
+}
+
+// Err returns a *DeliveryError if any result was not delivered, or may not
+// have been.
+func (r DeliveryReport) Err() error {
+	if r.undelivered() == 0 && r.LostSegments == 0 {
+		return nil
+	}
+	return &DeliveryError{Report: r}
+}
+
+// DeliveryError is returned by Sender.Finish when some results were lost.
+type DeliveryError struct {
+	Report DeliveryReport
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("%d of %d results were not delivered (%d failed, %d dropped, %d rejected)",
+		e.Report.undelivered(), e.Report.Sent+e.Report.undelivered(), e.Report.Failed, e.Report.Dropped, e.Report.Rejected)
+	if e.Report.LostSegments > 0 {
+		msg += fmt.Sprintf(", and %d spool segments with an unknown number of results were lost", e.Report.LostSegments)
+	}
+	return msg
+}
+
+// Partial reports whether some results were delivered despite the error.
+func (e *DeliveryError) Partial() bool {
+	return e.Report.Sent > 0
+}
@@ -0,0 +1,122 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"strconv"
+	"time"
+)
+
+// Resource is a result type a task emits through an Emitter.
+type Resource interface {
+	// UniqueID identifies the resource across runs.
+	UniqueID() string
+	// ResourceType is the result type, which selects the index the
+	// resource is stored in.
+	ResourceType() string
+}
+
+// NamedResource is a Resource with a display name.
+type NamedResource interface {
+	Resource
+	ResourceName() string
+}
+
+// MetadataResource is a Resource that attaches metadata to its TaskResult,
+// such as documents derived from it.
+type MetadataResource interface {
+	Resource
+	ResultMetadata() (map[string]string, error)
+}
+
+// Emitter turns a task's own result type into TaskResults and sends them, so
+// tasks never build documents by hand.
+type Emitter[T Resource] struct {
+	sender     Sender
+	runID      uint
+	taskType   string
+	platformID string
+	now        func() time.Time
+}
+
+type EmitterOption func(*emitterOptions)
+
+type emitterOptions struct {
+	platformID string
+	now        func() time.Time
+}
+
+// WithPlatformID sets the platform ID of every result.
+func WithPlatformID(platformID string) EmitterOption {
+	return func(o *emitterOptions) {
+		o.platformID = platformID
+	}
+}
+
+// WithClock sets the clock results are timestamped with.
+func WithClock(now func() time.Time) EmitterOption {
+	return func(o *emitterOptions) {
+		o.now = now
+	}
+}
+
+// NewEmitter sends the resources of the run of request through sender.
+func NewEmitter[T Resource](sender Sender, request tasks.TaskRequest, opts ...EmitterOption) *Emitter[T] {
+	o := emitterOptions{now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Emitter[T]{
+		sender:     sender,
+		runID:      request.TaskDefinition.RunID,
+		taskType:   request.TaskDefinition.TaskType,
+		platformID: o.platformID,
+		now:        o.now,
+	}
+}
+
+// Emit sends resource, waiting while the sender is busy.
+func (e *Emitter[T]) Emit(ctx context.Context, resource T) error {
+	result, err := e.Result(resource)
+	if err != nil {
+		return err
+	}
+	return e.sender.Send(ctx, result)
+}
+
+// Result builds the TaskResult resource is sent as. Its description is the
+// JSON encoding of resource.
+func (e *Emitter[T]) Result(resource T) (*es.TaskResult, error) {
+	id := resource.UniqueID()
+	if id == "" {
+		return nil, fmt.Errorf("%s resource has no unique ID", resource.ResourceType())
+	}
+	description, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s resource %s: %w", resource.ResourceType(), id, err)
+	}
+
+	result := &es.TaskResult{
+		PlatformID:  e.platformID,
+		ResourceID:  id,
+		Description: json.RawMessage(description),
+		ResultType:  resource.ResourceType(),
+		TaskType:    e.taskType,
+		DescribedBy: strconv.FormatUint(uint64(e.runID), 10),
+		DescribedAt: e.now().UnixMilli(),
+	}
+	if named, ok := any(resource).(NamedResource); ok {
+		result.ResourceName = named.ResourceName()
+	}
+	if withMetadata, ok := any(resource).(MetadataResource); ok {
+		metadata, err := withMetadata.ResultMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build metadata of %s resource %s: %w", resource.ResourceType(), id, err)
+		}
+		result.Metadata = metadata
+	}
+	return result, nil
+}
@@ -0,0 +1,190 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingSender keeps what is sent, or fails every Send with err.
+type recordingSender struct {
+	err  error
+	sent []*es.TaskResult
+}
+
+func (s *recordingSender) Send(_ context.Context, resource *es.TaskResult) error {
+	return s.TrySend(resource)
+}
+
+func (s *recordingSender) TrySend(resource *es.TaskResult) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, resource)
+	return nil
+}
+
+func (s *recordingSender) Finish() error { return nil }
+
+func (s *recordingSender) GetResourceIDs() []string {
+	var ids []string
+	for _, resource := range s.sent {
+		ids = append(ids, resource.ResourceID)
+	}
+	return ids
+}
+
+type testResource struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+func (r testResource) UniqueID() string     { return r.ID }
+func (r testResource) ResourceType() string { return "TestResource" }
+
+type namedResource struct {
+	testResource
+}
+
+func (r namedResource) ResourceName() string { return "name of " + r.ID }
+
+type metadataResource struct {
+	testResource
+	metadataErr error
+}
+
+func (r metadataResource) ResultMetadata() (map[string]string, error) {
+	return map[string]string{"value": r.Value}, r.metadataErr
+}
+
+type unencodableResource struct {
+	testResource
+	Ch chan int `json:"ch"`
+}
+
+func TestEmitterResult(t *testing.T) {
+	request := tasks.TaskRequest{TaskDefinition: tasks.TaskDefinition{RunID: 42, TaskType: "test"}}
+	now := time.UnixMilli(1700000000000)
+	opts := []EmitterOption{WithPlatformID("platform"), WithClock(func() time.Time { return now })}
+
+	base := es.TaskResult{
+		PlatformID:  "platform",
+		ResourceID:  "a",
+		ResultType:  "TestResource",
+		TaskType:    "test",
+		DescribedBy: "42",
+		DescribedAt: now.UnixMilli(),
+	}
+	withName, withMetadata := base, base
+	withName.ResourceName = "name of a"
+	withMetadata.Metadata = map[string]string{"value": "v"}
+
+	tests := []struct {
+		name    string
+		result  func() (*es.TaskResult, error)
+		want    es.TaskResult
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[testResource](nil, request, opts...).Result(testResource{ID: "a", Value: "v"})
+			},
+			want: base,
+		},
+		{
+			name: "named",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[namedResource](nil, request, opts...).Result(namedResource{testResource{ID: "a", Value: "v"}})
+			},
+			want: withName,
+		},
+		{
+			name: "metadata",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[metadataResource](nil, request, opts...).Result(metadataResource{testResource: testResource{ID: "a", Value: "v"}})
+			},
+			want: withMetadata,
+		},
+		{
+			name: "metadata fails",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[metadataResource](nil, request, opts...).Result(metadataResource{testResource: testResource{ID: "a"}, metadataErr: errors.New("boom")})
+			},
+			wantErr: true,
+		},
+		{
+			name: "no unique ID",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[testResource](nil, request, opts...).Result(testResource{})
+			},
+			wantErr: true,
+		},
+		{
+			name: "cannot be encoded",
+			result: func() (*es.TaskResult, error) {
+				return NewEmitter[unencodableResource](nil, request, opts...).Result(unencodableResource{testResource: testResource{ID: "a"}, Ch: make(chan int)})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.result()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Result() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var description testResource
+			if err := json.Unmarshal(got.Description.(json.RawMessage), &description); err != nil {
+				t.Fatal(err)
+			}
+			if description != (testResource{ID: "a", Value: "v"}) {
+				t.Errorf("description = %+v", description)
+			}
+			got.Description = nil
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Result() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmitterEmit(t *testing.T) {
+	request := tasks.TaskRequest{TaskDefinition: tasks.TaskDefinition{RunID: 1, TaskType: "test"}}
+	tests := []struct {
+		name     string
+		sender   *recordingSender
+		resource testResource
+		wantErr  bool
+		wantSent []string
+	}{
+		{name: "sent", sender: &recordingSender{}, resource: testResource{ID: "a"}, wantSent: []string{"a"}},
+		{name: "invalid", sender: &recordingSender{}, resource: testResource{}, wantErr: true},
+		{name: "sender fails", sender: &recordingSender{err: ErrSenderClosed}, resource: testResource{ID: "a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			err := NewEmitter[testResource](tt.sender, request).Emit(context.Background(), tt.resource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Emit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := tt.sender.GetResourceIDs(); !reflect.DeepEqual(got, tt.wantSent) {
+				t.Errorf("sent %v, want %v", got, tt.wantSent)
+			}
+			for _, result := range tt.sender.sent {
+				if result.DescribedAt < before.UnixMilli() {
+					t.Errorf("described at %d, before the emit at %d", result.DescribedAt, before.UnixMilli())
+				}
+			}
+		})
+	}
+}
@@ -1,69 +1,270 @@
 package results
 
 import (
+	"context"
+	"errors"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/metrics"
+	"github.com/opengovern/og-util/proto/src/golang"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
-	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/protobuf/runtime/protoimpl"
-	"os"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"math/rand/v2"
+	"sync"
 	"time"
 )
 
-var (
-	GRPCServerURL = os.Getenv("GRPC_SERVER_URL")
-)
+// ResultsMethod is the RPC task results are delivered to.
+const ResultsMethod = "/Tasks/Results"
 
-// function to send data with grpc
-type ResponseOK struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
-}
-
-func SendGRPC(ctx context.Context, data []byte, logger *zap.Logger) error {
-	// create a grpc connection
-	// send the data to the grpc server
-	// the grpc server will save the data to the database
-	// create a grpc connection
-	grpcCtx := metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{}))
-	var client grpc.ClientConnInterface
-
-	logger.Info("Setting grpc connection opts")
-	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	for retry := 0; retry < 5; retry++ {
-		conn, err := grpc.NewClient(
-			GRPCServerURL,
-			opts...,
-		)
-		if err != nil {
-			logger.Error("[result delivery] connection failure:", zap.Error(err))
-			if retry == 4 {
-				return err
-			}
-			time.Sleep(1 * time.Second)
-			continue
+// BackoffConfig controls how ResultsClient and ResourceSender retry a failed
+// call.
+type BackoffConfig struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	// Jitter randomizes each delay by up to this fraction of it, in [0, 1].
+	Jitter float64
+}
+
+var DefaultBackoff = BackoffConfig{
+	MaxAttempts: 5,
+	Initial:     500 * time.Millisecond,
+	Max:         10 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+}
+
+// delay returns the wait before the given retry, counting from 1.
+func (b BackoffConfig) delay(retry int) time.Duration {
+	d := float64(b.Initial)
+	for i := 1; i < retry; i++ {
+		d *= b.Multiplier
+		if d >= float64(b.Max) {
+			d = float64(b.Max)
+			break
 		}
-		client = conn
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryableCodes are the status codes a results call is retried on.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.DeadlineExceeded:  true,
+}
+
+// isRetryable reports whether err is a gRPC status with a retryable code.
+// Errors that are not statuses are not.
+func isRetryable(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && retryableCodes[s.Code()]
+}
+
+// isConnectionError reports whether err is a gRPC status saying the
+// transport broke, in which case a fresh connection is dialed before
+// retrying. Errors the server returned are not a reason to reconnect.
+func isConnectionError(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unavailable
+}
+
+type resultsClientKey struct{}
+
+// WithResultsClient returns a context whose task runs can deliver results to
+// the results server through client.
+func WithResultsClient(ctx context.Context, client *ResultsClient) context.Context {
+	return context.WithValue(ctx, resultsClientKey{}, client)
+}
+
+// ResultsClientFromContext returns the client set with WithResultsClient, or
+// nil when no results server is configured.
+func ResultsClientFromContext(ctx context.Context) *ResultsClient {
+	client, _ := ctx.Value(resultsClientKey{}).(*ResultsClient)
+	return client
+}
+
+type ResultsClientOption func(*ResultsClient)
+
+func WithBackoff(backoff BackoffConfig) ResultsClientOption {
+	return func(c *ResultsClient) {
+		c.backoff = backoff
+	}
+}
+
+// WithResultsTLS sets the transport security of the results connection.
+func WithResultsTLS(tlsConfig config.TLSConfig) ResultsClientOption {
+	return func(c *ResultsClient) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithResultsAuth sets how calls to the results server are authenticated. Run
+// JWTs take the run from the context of each call, see WithRunInfo.
+func WithResultsAuth(authConfig config.AuthConfig) ResultsClientOption {
+	return func(c *ResultsClient) {
+		c.authConfig = authConfig
+	}
+}
+
+// WithDialOptions adds options used when connecting to the results server.
+func WithDialOptions(opts ...grpc.DialOption) ResultsClientOption {
+	return func(c *ResultsClient) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// ResultsClient calls a results gRPC server, the ES sink service or the
+// server at GRPC_SERVER_URL, over one long-lived connection. A call is
+// retried with backoff on retryable status codes, and a connection that
+// broke is dialed again before the retry.
+type ResultsClient struct {
+	logger     *zap.Logger
+	serverURL  string
+	backoff    BackoffConfig
+	tlsConfig  config.TLSConfig
+	authConfig config.AuthConfig
+	dialOpts   []grpc.DialOption
+
+	// mu guards conn, which is replaced on reconnect.
+	mu   sync.RWMutex
+	conn *grpc.ClientConn
+}
 
-		break
+func NewResultsClient(serverURL string, logger *zap.Logger, opts ...ResultsClientOption) (*ResultsClient, error) {
+	c := &ResultsClient{
+		logger:    logger,
+		serverURL: serverURL,
+		backoff:   DefaultBackoff,
 	}
-	for retry := 0; retry < 5; retry++ {
-		// send the data to the grpc server
-		out := new(ResponseOK)
-		err := client.Invoke(grpcCtx, "/Tasks/Results", data, out)
-		if err != nil {
-			logger.Error("[result delivery] failed to send result:", zap.Error(err))
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.backoff.MaxAttempts < 1 {
+		return nil, errors.New("backoff max attempts must be at least 1")
+	}
+
+	// Built once so certificate reloading carries over reconnects.
+	creds, err := TransportCredentials(c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	perRPC, err := PerRPCCredentials(c.authConfig, !c.tlsConfig.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if perRPC != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(perRPC))
+	}
+	c.dialOpts = append(dialOpts, c.dialOpts...)
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect dials a new connection and closes the previous one.
+func (c *ResultsClient) connect() error {
+	conn, err := grpc.NewClient(c.serverURL, c.dialOpts...)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.conn = conn
+	return nil
+}
+
+// reconnect replaces conn, unless a concurrent call already did.
+func (c *ResultsClient) reconnect(conn *grpc.ClientConn) {
+	c.mu.RLock()
+	current := c.conn
+	c.mu.RUnlock()
+	if current != conn {
+		return
+	}
+
+	metrics.Reconnects.Inc()
+	if err := c.connect(); err != nil {
+		c.logger.Error("failed to reconnect", zap.String("server", c.serverURL), zap.Error(err))
+	}
+}
+
+// Send delivers data to ResultsMethod.
+func (c *ResultsClient) Send(ctx context.Context, data []byte) error {
+	return c.Invoke(ctx, ResultsMethod, wrapperspb.Bytes(data), new(emptypb.Empty))
+}
+
+// Ingest writes the documents of req through the EsSinkService.
+func (c *ResultsClient) Ingest(ctx context.Context, req *golang.IngestRequest) error {
+	return c.call(ctx, func(conn *grpc.ClientConn) error {
+		_, err := golang.NewEsSinkServiceClient(conn).Ingest(ctx, req)
+		return err
+	})
+}
+
+// Invoke calls method with in and decodes the response into out.
+func (c *ResultsClient) Invoke(ctx context.Context, method string, in, out proto.Message) error {
+	return c.call(ctx, func(conn *grpc.ClientConn) error {
+		return conn.Invoke(ctx, method, in, out)
+	})
+}
+
+// call runs fn until it succeeds, fails with a status that is not
+// retryable, the attempts run out or ctx is done.
+func (c *ResultsClient) call(ctx context.Context, fn func(conn *grpc.ClientConn) error) error {
+	var err error
+	for attempt := 1; attempt <= c.backoff.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			metrics.GRPCSendRetries.Inc()
+			if waitErr := sleep(ctx, c.backoff.delay(attempt-1)); waitErr != nil {
+				return errors.Join(waitErr, err)
+			}
+		}
+
+		c.mu.RLock()
+		conn := c.conn
+		c.mu.RUnlock()
+		err = fn(conn)
+		if err == nil {
+			return nil
+		}
+		if isConnectionError(err) {
+			c.reconnect(conn)
 		}
-		if retry == 4 {
+		if !isRetryable(err) || ctx.Err() != nil {
 			return err
 		}
-		time.Sleep(1 * time.Second)
-		continue
+		if attempt < c.backoff.MaxAttempts {
+			c.logger.Warn("[result delivery] call failed, retrying", zap.String("server", c.serverURL), zap.Int("attempt", attempt), zap.Error(err))
+		}
 	}
+	return err
+}
 
-	return nil
-
+func (c *ResultsClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.Close()
 }
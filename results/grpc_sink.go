@@ -0,0 +1,41 @@
+package results
+
+import (
+	"context"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-util/proto/src/golang"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// GRPCSink ingests documents through the EsSinkService.
+type GRPCSink struct {
+	client *ResultsClient
+}
+
+func NewGRPCSink(endpoint string, tlsConfig config.TLSConfig, authConfig config.AuthConfig, logger *zap.Logger) (*GRPCSink, error) {
+	// ResourceSender retries failed batches itself, so the client makes a
+	// single attempt and only dials again when the connection broke.
+	client, err := NewResultsClient(endpoint, logger,
+		WithBackoff(BackoffConfig{MaxAttempts: 1}),
+		WithResultsTLS(tlsConfig),
+		WithResultsAuth(authConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCSink{client: client}, nil
+}
+
+// Write ingests docs in one call.
+func (s *GRPCSink) Write(ctx context.Context, docs []Document) error {
+	anyDocs := make([]*anypb.Any, 0, len(docs))
+	for _, doc := range docs {
+		anyDocs = append(anyDocs, &anypb.Any{Value: doc.Body})
+	}
+	return s.client.Ingest(ctx, &golang.IngestRequest{Docs: anyDocs})
+}
+
+func (s *GRPCSink) Close() error {
+	return s.client.Close()
+}
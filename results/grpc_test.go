@@ -0,0 +1,172 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	noJitter := BackoffConfig{MaxAttempts: 5, Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	tests := []struct {
+		name    string
+		backoff BackoffConfig
+		retry   int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "first retry", backoff: noJitter, retry: 1, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "grows", backoff: noJitter, retry: 3, min: 400 * time.Millisecond, max: 400 * time.Millisecond},
+		{name: "capped", backoff: noJitter, retry: 10, min: time.Second, max: time.Second},
+		{name: "retry zero is the initial delay", backoff: noJitter, retry: 0, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "jitter", backoff: DefaultBackoff, retry: 2, min: 800 * time.Millisecond, max: 1200 * time.Millisecond},
+		{name: "jitter when capped", backoff: DefaultBackoff, retry: 20, min: 8 * time.Second, max: 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := tt.backoff.delay(tt.retry); got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %s, want within [%s, %s]", tt.retry, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), want: true},
+		{name: "wrapped unavailable", err: fmt.Errorf("ingest: %w", status.Error(codes.Unavailable, "connection reset")), want: true},
+		{name: "unknown", err: status.Error(codes.Unknown, "handler failed")},
+		{name: "internal", err: status.Error(codes.Internal, "bad document")},
+		{name: "resource exhausted", err: status.Error(codes.ResourceExhausted, "slow down")},
+		{name: "EOF", err: io.EOF},
+		{name: "not a status", err: errors.New("unavailable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// resultsServer answers ResultsMethod with the errors in fail, one per call,
+// and then succeeds.
+type resultsServer struct {
+	mu       sync.Mutex
+	fail     []error
+	received [][]byte
+}
+
+func (s *resultsServer) handle(_ any, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	if method != ResultsMethod {
+		return status.Error(codes.Unimplemented, method)
+	}
+	in := new(wrapperspb.BytesValue)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, in.GetValue())
+	var err error
+	if len(s.fail) > 0 {
+		err, s.fail = s.fail[0], s.fail[1:]
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(new(emptypb.Empty))
+}
+
+func TestResultsClientSend(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "try again")
+	tests := []struct {
+		name         string
+		fail         []error
+		maxAttempts  int
+		wantCode     codes.Code
+		wantAttempts int
+	}{
+		{name: "first attempt", maxAttempts: 3, wantAttempts: 1},
+		{name: "retried until it succeeds", fail: []error{unavailable, status.Error(codes.ResourceExhausted, "slow down")}, maxAttempts: 3, wantAttempts: 3},
+		{name: "attempts run out", fail: []error{unavailable, unavailable, unavailable}, maxAttempts: 2, wantCode: codes.Unavailable, wantAttempts: 2},
+		{name: "not retryable", fail: []error{status.Error(codes.InvalidArgument, "bad result")}, maxAttempts: 3, wantCode: codes.InvalidArgument, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &resultsServer{fail: tt.fail}
+			listener := bufconn.Listen(1 << 20)
+			grpcServer := grpc.NewServer(grpc.UnknownServiceHandler(server.handle))
+			go grpcServer.Serve(listener)
+			defer grpcServer.Stop()
+
+			client, err := NewResultsClient("passthrough:///results", zap.NewNop(),
+				WithResultsTLS(config.TLSConfig{Insecure: true}),
+				WithBackoff(BackoffConfig{MaxAttempts: tt.maxAttempts, Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}),
+				WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+					return listener.DialContext(ctx)
+				})),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			err = client.Send(context.Background(), []byte("result"))
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("Send() = %v, want code %s", err, tt.wantCode)
+			}
+			if len(server.received) != tt.wantAttempts {
+				t.Errorf("server got %d calls, want %d", len(server.received), tt.wantAttempts)
+			}
+			for _, data := range server.received {
+				if string(data) != "result" {
+					t.Errorf("server got %q, want %q", data, "result")
+				}
+			}
+		})
+	}
+}
+
+func TestResultsClientStopsWhenCancelled(t *testing.T) {
+	client, err := NewResultsClient("passthrough:///results", zap.NewNop(),
+		WithResultsTLS(config.TLSConfig{Insecure: true}),
+		WithBackoff(BackoffConfig{MaxAttempts: 5, Initial: time.Hour, Max: time.Hour, Multiplier: 2}),
+		WithDialOptions(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Send(ctx, []byte("result")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,107 @@
+package results
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"net/http"
+)
+
+// OpenSearchSink indexes documents straight into OpenSearch with the _bulk
+// API, using each document's ID so writing a batch again is harmless.
+type OpenSearchSink struct {
+	client *opensearch.Client
+}
+
+func NewOpenSearchSink(client *opensearch.Client) *OpenSearchSink {
+	return &OpenSearchSink{client: client}
+}
+
+type bulkAction struct {
+	Index bulkActionMeta `json:"index"`
+}
+
+type bulkActionMeta struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (s *OpenSearchSink) Write(ctx context.Context, docs []Document) error {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action, err := json.Marshal(bulkAction{Index: bulkActionMeta{Index: doc.Index, ID: doc.ID}})
+		if err != nil {
+			return err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.Body)
+		body.WriteByte('\n')
+	}
+
+	res, err := opensearchapi.BulkRequest{Body: &body}.Do(ctx, s.client)
+	if err != nil {
+		return Retryable(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		err := fmt.Errorf("bulk request failed: %s", res.String())
+		if isRetryableStatus(res.StatusCode) {
+			return Retryable(err)
+		}
+		return err
+	}
+
+	var response bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !response.Errors {
+		return nil
+	}
+
+	failed, retryable := 0, true
+	var firstErr json.RawMessage
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			failed++
+			if firstErr == nil {
+				firstErr = result.Error
+			}
+			retryable = retryable && isRetryableStatus(result.Status)
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	err = fmt.Errorf("%d of %d documents failed to index: %s", failed, len(docs), firstErr)
+	if retryable {
+		return Retryable(err)
+	}
+	return err
+}
+
+func (s *OpenSearchSink) Close() error {
+	return nil
+}
+
+// isRetryableStatus reports whether an HTTP status is worth retrying.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
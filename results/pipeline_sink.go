@@ -0,0 +1,146 @@
+package results
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/opengovern/og-task-template/config"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PipelineSink posts documents as a JSON array to an HTTP ingestion pipeline,
+// such as OpenSearch Ingestion or Data Prepper.
+type PipelineSink struct {
+	endpoint string
+	client   *http.Client
+	gzip     bool
+
+	// signer is nil when requests are not signed.
+	signer      *v4.Signer
+	credentials aws.CredentialsProvider
+	region      string
+	service     string
+}
+
+// NewPipelineSink posts to cfg.Endpoint with client. Requests are signed with
+// SigV4 when cfg.AwsRegion is set, assuming cfg.AssumeRoleArn if given.
+func NewPipelineSink(ctx context.Context, cfg config.PipelineConfig, client *http.Client) (*PipelineSink, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("no ingestion pipeline endpoint is configured")
+	}
+	s := &PipelineSink{
+		endpoint: cfg.Endpoint,
+		client:   client,
+		gzip:     cfg.Gzip,
+	}
+	if cfg.AwsRegion == "" {
+		return s, nil
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AwsRegion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	s.credentials = awsCfg.Credentials
+	if cfg.AssumeRoleArn != "" {
+		s.credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.AssumeRoleArn))
+	}
+	s.signer = v4.NewSigner()
+	s.region = cfg.AwsRegion
+	s.service = cfg.SigV4Service
+	return s, nil
+}
+
+func (s *PipelineSink) Write(ctx context.Context, docs []Document) error {
+	var body bytes.Buffer
+	var w io.Writer = &body
+	var zw *gzip.Writer
+	if s.gzip {
+		zw = gzip.NewWriter(&body)
+		w = zw
+	}
+
+	if _, err := w.Write([]byte{'['}); err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		if i > 0 {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(doc.Body); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte{']'}); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.signer != nil {
+		creds, err := s.credentials.Retrieve(ctx)
+		if err != nil {
+			return Retryable(fmt.Errorf("failed to retrieve AWS credentials: %w", err))
+		}
+		payloadHash := sha256.Sum256(body.Bytes())
+		if err := s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), s.service, s.region, time.Now()); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Retryable(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	err = fmt.Errorf("ingestion pipeline returned %s", res.Status)
+	if message, _ := io.ReadAll(io.LimitReader(res.Body, 1024)); len(bytes.TrimSpace(message)) > 0 {
+		err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(message))
+	}
+	if isRetryableStatus(res.StatusCode) {
+		return Retryable(err)
+	}
+	return err
+}
+
+func (s *PipelineSink) Close() error {
+	return nil
+}
+
+// String describes the sink with its SigV4 credentials masked.
+func (s *PipelineSink) String() string {
+	if s.signer == nil {
+		return fmt.Sprintf("pipeline(%s)", s.endpoint)
+	}
+	return fmt.Sprintf("pipeline(%s, sigv4 %s/%s, credentials %s)", s.endpoint, s.region, s.service, config.SecretMask)
+}
+
+func (s *PipelineSink) GoString() string { return s.String() }
@@ -0,0 +1,132 @@
+package results
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipelineSinkWrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		gzip          bool
+		status        int
+		response      string
+		wantErr       string
+		wantTransient bool
+	}{
+		{name: "accepted", status: http.StatusOK},
+		{name: "gzipped", gzip: true, status: http.StatusAccepted},
+		{name: "throttled", status: http.StatusTooManyRequests, wantErr: "429", wantTransient: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantErr: "503", wantTransient: true},
+		{name: "rejected with a message", status: http.StatusBadRequest, response: " invalid JSON \n", wantErr: "400 Bad Request: invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("%s with content type %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				var body io.Reader = r.Body
+				if tt.gzip {
+					if r.Header.Get("Content-Encoding") != "gzip" {
+						t.Error("gzipped body without a gzip content encoding")
+					}
+					zr, err := gzip.NewReader(r.Body)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					body = zr
+				}
+				if err := json.NewDecoder(body).Decode(&got); err != nil {
+					t.Errorf("body is not a JSON array: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			sink, err := NewPipelineSink(context.Background(), config.PipelineConfig{Endpoint: server.URL, Gzip: tt.gzip}, server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = sink.Write(context.Background(), testDocs("a", "b"))
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Write() error = %v, want %q", err, tt.wantErr)
+			}
+			if isTransient(err) != tt.wantTransient {
+				t.Errorf("Write() error %v transient = %v, want %v", err, isTransient(err), tt.wantTransient)
+			}
+			if want := []map[string]string{{"id": "a"}, {"id": "b"}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("posted %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		sink, err := NewPipelineSink(context.Background(), config.PipelineConfig{Endpoint: "http://127.0.0.1:1"}, http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(context.Background(), testDocs("a")); !isTransient(err) {
+			t.Errorf("Write() error = %v, want it transient", err)
+		}
+	})
+
+	t.Run("no endpoint", func(t *testing.T) {
+		if _, err := NewPipelineSink(context.Background(), config.PipelineConfig{}, http.DefaultClient); err == nil {
+			t.Error("NewPipelineSink() succeeded without an endpoint")
+		}
+	})
+}
+
+func TestPipelineSinkSigV4(t *testing.T) {
+	const accessKey, secretKey = "AKIDPIPELINETEST", "pipeline-secret-key"
+	t.Setenv("AWS_ACCESS_KEY_ID", accessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", secretKey)
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	var authorization, date string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, date = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Date")
+	}))
+	defer server.Close()
+
+	cfg := config.PipelineConfig{Endpoint: server.URL, AwsRegion: "eu-west-1", SigV4Service: config.DefaultPipelineSigV4Service}
+	sink, err := NewPipelineSink(context.Background(), cfg, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), testDocs("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(date) < 8 {
+		t.Fatalf("X-Amz-Date = %q", date)
+	}
+	wantPrefix := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s/eu-west-1/osis/aws4_request, SignedHeaders=", accessKey, date[:8])
+	if !strings.HasPrefix(authorization, wantPrefix) || !strings.Contains(authorization, "Signature=") {
+		t.Errorf("Authorization = %q, want prefix %q", authorization, wantPrefix)
+	}
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(verb, sink)
+		if strings.Contains(out, accessKey) || strings.Contains(out, secretKey) || !strings.Contains(out, config.SecretMask) {
+			t.Errorf("Sprintf(%s) = %s, want the credentials masked", verb, out)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package results
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/metrics"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	ReconcileDelete    = "delete"
+	ReconcileTombstone = "tombstone"
+
+	reconcilePageSize  = 1000
+	reconcileChunkSize = 1000
+	reconcileScroll    = time.Minute
+	// reconcileLogIDs is how many stale IDs a dry run logs.
+	reconcileLogIDs = 20
+)
+
+// ErrReconcileThreshold is returned when more documents would be removed
+// than ReconcileConfig.MaxDeletePercent allows.
+var ErrReconcileThreshold = errors.New("results: too many stale documents to reconcile")
+
+// ReconcileReport is the outcome of reconciling one index.
+type ReconcileReport struct {
+	Index string
+	// Total is how many documents of the task the index held.
+	Total   int
+	Stale   int
+	Removed int
+	DryRun  bool
+}
+
+// ReconcileScope selects the documents a run owns: those of its task type
+// written for the same platform. Runs of one task type against different
+// targets set their own platform ID, see WithPlatformID, so they never
+// remove each other's documents.
+type ReconcileScope struct {
+	TaskType   string
+	PlatformID string
+}
+
+// Reconciler removes the documents an earlier run of a task wrote but the
+// current one did not, so resources that disappeared leave the index.
+type Reconciler struct {
+	client *opensearch.Client
+	cfg    config.ReconcileConfig
+	logger *zap.Logger
+	// write stores tombstones.
+	write func(ctx context.Context, docs []Document) error
+}
+
+// NewReconciler finds stale documents with client. Tombstones are written to
+// sink, which may be nil in the delete mode.
+func NewReconciler(client *opensearch.Client, sink Sink, cfg config.ReconcileConfig, logger *zap.Logger) (*Reconciler, error) {
+	var write func(ctx context.Context, docs []Document) error
+	if sink != nil {
+		write = sink.Write
+	}
+	return newReconciler(client, cfg, logger, write)
+}
+
+func newReconciler(client *opensearch.Client, cfg config.ReconcileConfig, logger *zap.Logger, write func(ctx context.Context, docs []Document) error) (*Reconciler, error) {
+	if client == nil {
+		return nil, errors.New("no OpenSearch client is configured")
+	}
+	switch cfg.Mode {
+	case ReconcileDelete:
+	case ReconcileTombstone:
+		if write == nil {
+			return nil, errors.New("the tombstone mode needs a sink")
+		}
+	default:
+		return nil, fmt.Errorf("unknown reconcile mode %q", cfg.Mode)
+	}
+	return &Reconciler{
+		client: client,
+		cfg:    cfg,
+		logger: logger,
+		write:  write,
+	}, nil
+}
+
+type staleDoc struct {
+	id         string
+	resourceID string
+	source     json.RawMessage
+}
+
+type searchPage struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Reconcile removes the documents of scope in index whose resource ID is not
+// in keep.
+func (r *Reconciler) Reconcile(ctx context.Context, index string, scope ReconcileScope, keep []string) (ReconcileReport, error) {
+	report := ReconcileReport{Index: index, DryRun: r.cfg.DryRun}
+	keepSet := make(map[string]struct{}, len(keep))
+	for _, id := range keep {
+		keepSet[id] = struct{}{}
+	}
+
+	total, stale, err := r.findStale(ctx, index, scope, keepSet)
+	if err != nil {
+		return report, fmt.Errorf("failed to find stale documents in %s: %w", index, err)
+	}
+	report.Total, report.Stale = total, len(stale)
+	if len(stale) == 0 {
+		return report, nil
+	}
+	if len(stale)*100 > total*r.cfg.MaxDeletePercent {
+		return report, fmt.Errorf("%w: %d of %d documents in %s, the limit is %d%%", ErrReconcileThreshold,
+			len(stale), total, index, r.cfg.MaxDeletePercent)
+	}
+
+	if r.cfg.DryRun {
+		ids := make([]string, 0, reconcileLogIDs)
+		for _, doc := range stale[:min(len(stale), reconcileLogIDs)] {
+			ids = append(ids, doc.resourceID)
+		}
+		r.logger.Info("dry run, not removing stale documents", zap.String("index", index), zap.String("platformID", scope.PlatformID),
+			zap.Int("stale", len(stale)), zap.Int("total", total), zap.Strings("resourceIDs", ids))
+		metrics.StaleResources.WithLabelValues("dry_run").Add(float64(len(stale)))
+		return report, nil
+	}
+
+	for start := 0; start < len(stale); start += reconcileChunkSize {
+		chunk := stale[start:min(start+reconcileChunkSize, len(stale))]
+		var removed int
+		if r.cfg.Mode == ReconcileTombstone {
+			removed, err = r.tombstone(ctx, index, chunk)
+		} else {
+			removed, err = r.delete(ctx, index, chunk)
+		}
+		report.Removed += removed
+		metrics.StaleResources.WithLabelValues(r.cfg.Mode).Add(float64(removed))
+		if err != nil {
+			return report, fmt.Errorf("failed to remove stale documents from %s: %w", index, err)
+		}
+	}
+	r.logger.Info("removed stale documents", zap.String("index", index), zap.String("platformID", scope.PlatformID), zap.String("mode", r.cfg.Mode),
+		zap.Int("removed", report.Removed), zap.Int("total", total))
+	return report, nil
+}
+
+// findStale scrolls through the documents of scope in index and returns how
+// many there are and those whose resource ID is not in keep.
+func (r *Reconciler) findStale(ctx context.Context, index string, scope ReconcileScope, keep map[string]struct{}) (int, []staleDoc, error) {
+	// Documents tombstoned by an earlier run are not counted again.
+	query := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []any{
+					map[string]any{"term": map[string]any{"task_type": scope.TaskType}},
+					map[string]any{"term": map[string]any{"platform_id": scope.PlatformID}},
+				},
+				"must_not": map[string]any{"term": map[string]any{"deleted": true}},
+			},
+		},
+	}
+	if r.cfg.Mode != ReconcileTombstone {
+		query["_source"] = []string{"resource_id"}
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := reconcilePageSize
+	res, err := opensearchapi.SearchRequest{
+		Index:  []string{index},
+		Body:   bytes.NewReader(body),
+		Scroll: reconcileScroll,
+		Size:   &size,
+	}.Do(ctx, r.client)
+	if err != nil {
+		return 0, nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return 0, nil, nil
+	}
+
+	var scrollID string
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+		res, err := opensearchapi.ClearScrollRequest{ScrollID: []string{scrollID}}.Do(context.WithoutCancel(ctx), r.client)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	total := 0
+	var stale []staleDoc
+	for {
+		page, err := decodeSearchPage(res)
+		if err != nil {
+			return 0, nil, err
+		}
+		if page.ScrollID != "" {
+			scrollID = page.ScrollID
+		}
+		if len(page.Hits.Hits) == 0 {
+			return total, stale, nil
+		}
+
+		for _, hit := range page.Hits.Hits {
+			var source struct {
+				ResourceID string `json:"resource_id"`
+			}
+			if err := json.Unmarshal(hit.Source, &source); err != nil {
+				return 0, nil, fmt.Errorf("failed to decode document %s: %w", hit.ID, err)
+			}
+			total++
+			if _, ok := keep[source.ResourceID]; !ok {
+				stale = append(stale, staleDoc{id: hit.ID, resourceID: source.ResourceID, source: hit.Source})
+			}
+		}
+
+		res, err = opensearchapi.ScrollRequest{ScrollID: scrollID, Scroll: reconcileScroll}.Do(ctx, r.client)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func decodeSearchPage(res *opensearchapi.Response) (searchPage, error) {
+	defer res.Body.Close()
+	var page searchPage
+	if res.IsError() {
+		return page, fmt.Errorf("search failed: %s", res.String())
+	}
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		return page, fmt.Errorf("failed to decode search response: %w", err)
+	}
+	return page, nil
+}
+
+// delete removes docs with a delete-by-query on their IDs.
+func (r *Reconciler) delete(ctx context.Context, index string, docs []staleDoc) (int, error) {
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.id)
+	}
+	body, err := json.Marshal(map[string]any{
+		"query": map[string]any{
+			"ids": map[string]any{"values": ids},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := opensearchapi.DeleteByQueryRequest{
+		Index:     []string{index},
+		Body:      bytes.NewReader(body),
+		Conflicts: "proceed",
+	}.Do(ctx, r.client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("delete by query failed: %s", res.String())
+	}
+
+	var response struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode delete by query response: %w", err)
+	}
+	if len(response.Failures) > 0 {
+		return response.Deleted, fmt.Errorf("%d documents failed to delete: %s", len(response.Failures), response.Failures[0])
+	}
+	return response.Deleted, nil
+}
+
+// tombstone writes docs again marked as deleted, so consumers of the sinks
+// see the removal.
+func (r *Reconciler) tombstone(ctx context.Context, index string, docs []staleDoc) (int, error) {
+	now := time.Now().UnixMilli()
+	tombstones := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		var source map[string]any
+		if err := json.Unmarshal(doc.source, &source); err != nil {
+			return 0, fmt.Errorf("failed to decode document %s: %w", doc.id, err)
+		}
+		source["deleted"] = true
+		source["deleted_at"] = now
+		body, err := json.Marshal(source)
+		if err != nil {
+			return 0, err
+		}
+		tombstones = append(tombstones, Document{
+			ID:         doc.id,
+			Index:      index,
+			ResourceID: doc.resourceID,
+			Body:       body,
+		})
+	}
+	if err := r.write(ctx, tombstones); err != nil {
+		return 0, err
+	}
+	return len(tombstones), nil
+}
@@ -0,0 +1,397 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"go.uber.org/zap"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOpenSearch emulates the search, scroll and delete by query APIs the
+// reconciler uses, over the documents of each index.
+type fakeOpenSearch struct {
+	mu      sync.Mutex
+	indexes map[string]map[string]map[string]any
+	// scrolls holds the hits each scroll still has to return.
+	scrolls     map[string][]string
+	scrollIndex map[string]string
+	nextScroll  int
+	cleared     []string
+	searchFails bool
+}
+
+func newFakeOpenSearch() *fakeOpenSearch {
+	return &fakeOpenSearch{
+		indexes:     make(map[string]map[string]map[string]any),
+		scrolls:     make(map[string][]string),
+		scrollIndex: make(map[string]string),
+	}
+}
+
+// add stores a document of taskType and platformID for each resource ID.
+func (f *fakeOpenSearch) add(index, taskType, platformID string, resourceIDs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.indexes[index] == nil {
+		f.indexes[index] = make(map[string]map[string]any)
+	}
+	for _, id := range resourceIDs {
+		f.indexes[index]["doc-"+id] = map[string]any{"resource_id": id, "task_type": taskType, "platform_id": platformID}
+	}
+}
+
+// resourceIDs returns the resource IDs left in index, sorted.
+func (f *fakeOpenSearch) resourceIDs(index string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, source := range f.indexes[index] {
+		ids = append(ids, source["resource_id"].(string))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodDelete && len(parts) == 3 && parts[0] == "_search" && parts[1] == "scroll":
+		f.cleared = append(f.cleared, parts[2])
+		delete(f.scrolls, parts[2])
+		_, _ = w.Write([]byte(`{"succeeded":true}`))
+	case len(parts) == 2 && parts[0] == "_search" && parts[1] == "scroll":
+		scrollID := r.URL.Query().Get("scroll_id")
+		if _, ok := f.scrolls[scrollID]; !ok {
+			http.Error(w, `{"error":"no such scroll"}`, http.StatusNotFound)
+			return
+		}
+		f.page(w, scrollID, reconcilePageSize)
+	case len(parts) == 2 && parts[1] == "_search":
+		if f.searchFails {
+			http.Error(w, `{"error":"cluster red"}`, http.StatusInternalServerError)
+			return
+		}
+		docs, ok := f.indexes[parts[0]]
+		if !ok {
+			http.Error(w, `{"error":"index_not_found_exception"}`, http.StatusNotFound)
+			return
+		}
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []struct {
+						Term map[string]any `json:"term"`
+					} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var hits []string
+		for id, source := range docs {
+			matches := source["deleted"] != true
+			for _, filter := range query.Query.Bool.Filter {
+				for field, value := range filter.Term {
+					matches = matches && source[field] == value
+				}
+			}
+			if matches {
+				hits = append(hits, id)
+			}
+		}
+		sort.Strings(hits)
+		f.nextScroll++
+		scrollID := fmt.Sprintf("scroll-%d", f.nextScroll)
+		f.scrolls[scrollID], f.scrollIndex[scrollID] = hits, parts[0]
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		f.page(w, scrollID, size)
+	case len(parts) == 2 && parts[1] == "_delete_by_query":
+		var query struct {
+			Query struct {
+				IDs struct {
+					Values []string `json:"values"`
+				} `json:"ids"`
+			} `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deleted := 0
+		for _, id := range query.Query.IDs.Values {
+			if _, ok := f.indexes[parts[0]][id]; ok {
+				delete(f.indexes[parts[0]], id)
+				deleted++
+			}
+		}
+		_, _ = fmt.Fprintf(w, `{"deleted":%d,"failures":[]}`, deleted)
+	default:
+		http.Error(w, `{"error":"unexpected request"}`, http.StatusBadRequest)
+	}
+}
+
+// page writes the next size hits of the scroll.
+func (f *fakeOpenSearch) page(w http.ResponseWriter, scrollID string, size int) {
+	hits := f.scrolls[scrollID]
+	n := min(size, len(hits))
+	f.scrolls[scrollID] = hits[n:]
+
+	type hit struct {
+		ID     string         `json:"_id"`
+		Source map[string]any `json:"_source"`
+	}
+	var page struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []hit `json:"hits"`
+		} `json:"hits"`
+	}
+	page.ScrollID = scrollID
+	page.Hits.Hits = []hit{}
+	for _, id := range hits[:n] {
+		page.Hits.Hits = append(page.Hits.Hits, hit{ID: id, Source: f.indexes[f.scrollIndex[scrollID]][id]})
+	}
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+func reconcileConfig(mode string) config.ReconcileConfig {
+	return config.ReconcileConfig{Enabled: true, Mode: mode, MaxDeletePercent: config.DefaultReconcileMaxDeletePercent}
+}
+
+func TestNewReconcilerInvalid(t *testing.T) {
+	client := newTestOpenSearch(t, newFakeOpenSearch().ServeHTTP)
+	tests := []struct {
+		name   string
+		client bool
+		mode   string
+		sink   Sink
+	}{
+		{name: "no client", mode: ReconcileDelete},
+		{name: "unknown mode", client: true, mode: "archive"},
+		{name: "tombstone without a sink", client: true, mode: ReconcileTombstone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := reconcileConfig(tt.mode)
+			var err error
+			if tt.client {
+				_, err = NewReconciler(client, tt.sink, cfg, zap.NewNop())
+			} else {
+				_, err = NewReconciler(nil, tt.sink, cfg, zap.NewNop())
+			}
+			if err == nil {
+				t.Error("NewReconciler() succeeded")
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	many := make([]string, 1500)
+	for i := range many {
+		many[i] = fmt.Sprintf("r%04d", i)
+	}
+
+	tests := []struct {
+		name          string
+		mode          string
+		dryRun        bool
+		index         string
+		stored        []string
+		keep          []string
+		searchFails   bool
+		wantReport    ReconcileReport
+		wantErr       error
+		wantLeft      []string
+		wantTombstone []string
+	}{
+		{
+			name: "nothing stale", mode: ReconcileDelete, stored: []string{"a", "b"}, keep: []string{"a", "b", "new"},
+			wantReport: ReconcileReport{Total: 2}, wantLeft: []string{"a", "b", "other"},
+		},
+		{
+			name: "stale deleted", mode: ReconcileDelete, stored: []string{"a", "b", "c"}, keep: []string{"a", "b"},
+			wantReport: ReconcileReport{Total: 3, Stale: 1, Removed: 1}, wantLeft: []string{"a", "b", "other"},
+		},
+		{
+			name: "over the threshold", mode: ReconcileDelete, stored: []string{"a", "b", "c"}, keep: []string{"a"},
+			wantReport: ReconcileReport{Total: 3, Stale: 2}, wantErr: ErrReconcileThreshold, wantLeft: []string{"a", "b", "c", "other"},
+		},
+		{
+			name: "dry run", mode: ReconcileDelete, dryRun: true, stored: []string{"a", "b", "c"}, keep: []string{"a", "b"},
+			wantReport: ReconcileReport{Total: 3, Stale: 1, DryRun: true}, wantLeft: []string{"a", "b", "c", "other"},
+		},
+		{
+			name: "tombstoned", mode: ReconcileTombstone, stored: []string{"a", "b", "c"}, keep: []string{"a", "b"},
+			wantReport: ReconcileReport{Total: 3, Stale: 1, Removed: 1}, wantLeft: []string{"a", "b", "c", "other"}, wantTombstone: []string{"c"},
+		},
+		{
+			name: "several pages", mode: ReconcileDelete, stored: many, keep: many[10:],
+			wantReport: ReconcileReport{Total: 1500, Stale: 10, Removed: 10}, wantLeft: append([]string{"other"}, many[10:]...),
+		},
+		{
+			name: "missing index", mode: ReconcileDelete, index: "missing", keep: []string{"a"},
+			wantReport: ReconcileReport{}, wantLeft: []string{"other"},
+		},
+		{
+			name: "search fails", mode: ReconcileDelete, stored: []string{"a"}, searchFails: true,
+			wantReport: ReconcileReport{}, wantErr: errors.New("search failed"), wantLeft: []string{"a", "other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOpenSearch()
+			fake.searchFails = tt.searchFails
+			if tt.stored != nil {
+				fake.add("results", "sbom", "", tt.stored...)
+			}
+			fake.add("results", "other-task", "", "other")
+			index := "results"
+			if tt.index != "" {
+				index = tt.index
+			}
+
+			var tombstones []Document
+			cfg := reconcileConfig(tt.mode)
+			cfg.DryRun = tt.dryRun
+			reconciler, err := newReconciler(newTestOpenSearch(t, fake.ServeHTTP), cfg, zap.NewNop(), func(_ context.Context, docs []Document) error {
+				tombstones = append(tombstones, docs...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := reconciler.Reconcile(context.Background(), index, ReconcileScope{TaskType: "sbom"}, tt.keep)
+			switch {
+			case tt.wantErr == nil && err != nil,
+				tt.wantErr != nil && err == nil,
+				tt.wantErr != nil && !errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error()):
+				t.Fatalf("Reconcile() error = %v, want %v", err, tt.wantErr)
+			}
+			tt.wantReport.Index = index
+			if report != tt.wantReport {
+				t.Errorf("report = %+v, want %+v", report, tt.wantReport)
+			}
+			if got := fake.resourceIDs("results"); !reflect.DeepEqual(got, tt.wantLeft) {
+				t.Errorf("index holds %d documents %v, want %d", len(got), got[:min(len(got), 5)], len(tt.wantLeft))
+			}
+
+			var tombstoned []string
+			for _, doc := range tombstones {
+				var source map[string]any
+				if err := json.Unmarshal(doc.Body, &source); err != nil {
+					t.Fatal(err)
+				}
+				if source["deleted"] != true || source["deleted_at"] == nil || doc.Index != index {
+					t.Errorf("tombstone %s = %v in %s", doc.ID, source, doc.Index)
+				}
+				tombstoned = append(tombstoned, doc.ResourceID)
+			}
+			if !reflect.DeepEqual(tombstoned, tt.wantTombstone) {
+				t.Errorf("tombstoned %v, want %v", tombstoned, tt.wantTombstone)
+			}
+			if fake.nextScroll > 0 && len(fake.cleared) != fake.nextScroll {
+				t.Errorf("%d of %d scrolls cleared", len(fake.cleared), fake.nextScroll)
+			}
+		})
+	}
+}
+
+func TestResourceSenderReconcile(t *testing.T) {
+	doc, err := NewDocument(testResult("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		cancelled bool
+		wantLeft  []string
+	}{
+		{name: "every result delivered", wantLeft: []string{"a", "b"}},
+		{name: "a result failed", errs: []error{errors.New("rejected")}, wantLeft: []string{"a", "b", "stale"}},
+		{name: "run cancelled", cancelled: true, wantLeft: []string{"a", "b", "stale"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOpenSearch()
+			fake.add(doc.Index, "test", "", "a", "b", "stale")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			sink := &fakeSink{errs: tt.errs}
+			cfg := reconcileConfig(ReconcileDelete)
+			cfg.MaxDeletePercent = 100
+			_, _ = sendAll(t, sink, []string{"a", "b"}, withTaskType("test"), withRunContext(ctx),
+				WithReconcile(cfg, newTestOpenSearch(t, fake.ServeHTTP)))
+
+			if got := fake.resourceIDs(doc.Index); !reflect.DeepEqual(got, tt.wantLeft) {
+				t.Errorf("index holds %v, want %v", got, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestResourceSenderReconcileTargets(t *testing.T) {
+	doc, err := NewDocument(testResult("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeOpenSearch()
+	// Earlier runs of the task against two targets.
+	fake.add(doc.Index, "test", "target-a", "a", "gone-a")
+	fake.add(doc.Index, "test", "target-b", "b", "gone-b")
+	fake.add(doc.Index, "test", "target-c", "c")
+
+	cfg := reconcileConfig(ReconcileDelete)
+	cfg.MaxDeletePercent = 100
+	runs := []struct {
+		platformID string
+		ids        []string
+	}{
+		{platformID: "target-a", ids: []string{"a"}},
+		{platformID: "target-b", ids: []string{"b"}},
+	}
+	for _, run := range runs {
+		sender, err := NewResourceSender("", 1, false, zap.NewNop(), WithSink(&fakeSink{}), WithSenderBackoff(fastBackoff),
+			withTaskType("test"), WithReconcile(cfg, newTestOpenSearch(t, fake.ServeHTTP)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range run.ids {
+			result := testResult(id)
+			result.PlatformID = run.platformID
+			if err := sender.Send(context.Background(), result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sender.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each run only removed the stale documents of its own target.
+	if got, want := fake.resourceIDs(doc.Index), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+}
@@ -2,18 +2,21 @@ package results
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/metrics"
+	"github.com/opengovern/og-task-template/tracing"
 	"github.com/opengovern/og-util/pkg/es"
-	"github.com/opengovern/og-util/proto/src/golang"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/protobuf/types/known/anypb"
-	"io"
-	"net/http"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -22,115 +25,502 @@ const (
 	MaxBufferSize   int           = 100
 	ChannelSize     int           = 1000
 	BufferEmptyRate time.Duration = 5 * time.Second
+	// MaxInFlightBatches is how many batches are sent at the same time.
+	MaxInFlightBatches int = 1
+	// MaxBatchBytes keeps a batch under the default 4 MiB gRPC message size.
+	MaxBatchBytes int = 4<<20 - 64<<10
+
+	// docOverheadBytes is the protobuf framing added to each document.
+	docOverheadBytes = 16
+)
+
+var (
+	// ErrSenderClosed is returned when sending after Finish, or after the
+	// sender stopped.
+	ErrSenderClosed = errors.New("results: sender is closed")
+	// ErrSenderFull is returned by TrySend when the buffer is full.
+	ErrSenderFull = errors.New("results: sender buffer is full")
 )
 
 type ResourceSender struct {
-	logger                    *zap.Logger
-	resourceChannel           chan *es.TaskResult
-	resourceIDs               []string
-	doneChannel               chan interface{}
-	conn                      *grpc.ClientConn
-	grpcEndpoint              string
-	ingestionPipelineEndpoint string
-	jobID                     uint
-
-	client     golang.EsSinkServiceClient
-	httpClient *http.Client
+	logger          *zap.Logger
+	resourceChannel chan *es.TaskResult
+	// idsMu guards resourceIDs, which the handler appends to while the task
+	// may read it.
+	idsMu        sync.Mutex
+	resourceIDs  []string
+	doneChannel  chan interface{}
+	grpcEndpoint string
+	jobID        uint
+	taskType     string
+
+	sink Sink
 
 	sendBuffer    []*es.TaskResult
 	useOpenSearch bool
+	// merge combines duplicates in sendBuffer when dedupEnabled is set.
+	dedupEnabled bool
+	merge        MergeFunc
+
+	minBufferSize   int
+	maxBufferSize   int
+	channelSize     int
+	bufferEmptyRate time.Duration
+	maxBatchBytes   int
+
+	maxInFlight   int
+	indexOrdering bool
+	// batchQueues feed the batch workers; nil when batches are sent by the
+	// handler itself.
+	batchQueues  []chan docBatch
+	batchWorkers sync.WaitGroup
+
+	// traceCtx carries the span batches are traced under.
+	traceCtx context.Context
+	// runCtx is cancelled with the task run, which stops retries and waits
+	// for spool space. sendCtx is traceCtx cancelled along with it.
+	runCtx     context.Context
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+	tlsConfig  config.TLSConfig
+	authConfig config.AuthConfig
+
+	backoff     BackoffConfig
+	spoolConfig config.SpoolConfig
+	spool       *spool
+	// spoolMu serializes spool access between the batch workers.
+	spoolMu sync.Mutex
+	report  deliveryTracker
+
+	// reconciler removes stale documents from indexes after the run. indexes
+	// holds the platform IDs written to each index, and is only touched by
+	// the handler until it is done.
+	reconciler      *Reconciler
+	reconcileConfig config.ReconcileConfig
+	reconcileClient *opensearch.Client
+	indexes         map[string]map[string]struct{}
+
+	// mu guards closed. Send holds it for reading so Finish never closes
+	// resourceChannel under a pending send.
+	mu         sync.RWMutex
+	closed     bool
+	finishOnce sync.Once
+	finishErr  error
+	// handlerErr is guarded by report.mu.
+	handlerErr error
 }
 
-func NewResourceSender(grpcEndpoint string, jobID uint, useOpenSearch bool, logger *zap.Logger) (*ResourceSender, error) {
-	rs := ResourceSender{
-		logger:          logger,
-		resourceChannel: make(chan *es.TaskResult, ChannelSize),
-		resourceIDs:     nil,
-		doneChannel:     make(chan interface{}),
-		conn:            nil,
-		grpcEndpoint:    grpcEndpoint,
-		jobID:           jobID,
-		useOpenSearch:   useOpenSearch,
+type ResourceSenderOption func(*ResourceSender)
 
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+// WithSink sends results to sink instead of the ES sink service at the
+// sender's gRPC endpoint. The sender closes it in Finish.
+func WithSink(sink Sink) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.sink = sink
 	}
-	if err := rs.Connect(); err != nil {
-		return nil, err
+}
+
+// WithReconcile removes, once the run delivered every result, the documents
+// of the task that earlier runs wrote to the same indexes for the same
+// platform IDs and this one did not. client finds them, and deletes them in the delete mode.
+func WithReconcile(cfg config.ReconcileConfig, client *opensearch.Client) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.reconcileConfig = cfg
+		s.reconcileClient = client
 	}
+}
 
-	go rs.ResourceHandler()
-	return &rs, nil
+// WithSenderTLS sets the transport security of the ES sink connection.
+func WithSenderTLS(tlsConfig config.TLSConfig) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.tlsConfig = tlsConfig
+	}
 }
 
-func (s *ResourceSender) Connect() error {
-	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// WithMinBufferSize sets how many results must be buffered before a
+// periodic flush sends them.
+func WithMinBufferSize(n int) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.minBufferSize = n
+	}
+}
 
-	conn, err := grpc.NewClient(
-		s.grpcEndpoint,
-		opts...,
-	)
-	if err != nil {
-		return err
+// WithMaxBufferSize sets how many results are buffered before they are sent
+// right away.
+func WithMaxBufferSize(n int) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.maxBufferSize = n
 	}
-	s.conn = conn
+}
 
-	client := golang.NewEsSinkServiceClient(conn)
-	s.client = client
-	return nil
+// WithChannelSize sets how many results Send queues before it blocks.
+func WithChannelSize(n int) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.channelSize = n
+	}
+}
+
+// WithBufferEmptyRate sets how often buffered results are flushed.
+func WithBufferEmptyRate(d time.Duration) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.bufferEmptyRate = d
+	}
+}
+
+// WithMaxBatchBytes sets the largest batch sent in one Ingest call. Larger
+// buffers are split, and a single result over the limit is rejected.
+func WithMaxBatchBytes(n int) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.maxBatchBytes = n
+	}
+}
+
+// WithMaxInFlightBatches sets how many batches are sent at the same time.
+func WithMaxInFlightBatches(n int) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.maxInFlight = n
+	}
+}
+
+// WithIndexOrdering keeps the documents of each index in the order they were
+// sent when batches are sent in parallel, by sending every index through one
+// batch worker.
+func WithIndexOrdering(enabled bool) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.indexOrdering = enabled
+	}
+}
+
+// WithSenderBackoff sets how failed batches are retried.
+func WithSenderBackoff(backoff BackoffConfig) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.backoff = backoff
+	}
+}
+
+// WithSenderSpool spools batches the ES sink does not accept to disk, see
+// config.SpoolConfig. An empty directory disables it.
+func WithSenderSpool(spoolConfig config.SpoolConfig) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.spoolConfig = spoolConfig
+	}
+}
+
+// WithSenderAuth sets how calls to the ES sink are authenticated.
+func WithSenderAuth(authConfig config.AuthConfig) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.authConfig = authConfig
+	}
+}
+
+// withTaskType sets the task type run JWTs are issued for.
+func withTaskType(taskType string) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.taskType = taskType
+	}
+}
+
+// withRunContext sets the context whose cancellation gives up on sending.
+func withRunContext(ctx context.Context) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.runCtx = ctx
+	}
+}
+
+// withTraceContext sets the context whose span sent batches are traced under.
+func withTraceContext(ctx context.Context) ResourceSenderOption {
+	return func(s *ResourceSender) {
+		s.traceCtx = ctx
+	}
+}
+
+func NewResourceSender(grpcEndpoint string, jobID uint, useOpenSearch bool, logger *zap.Logger, opts ...ResourceSenderOption) (*ResourceSender, error) {
+	rs := ResourceSender{
+		logger:        logger,
+		resourceIDs:   nil,
+		doneChannel:   make(chan interface{}),
+		grpcEndpoint:  grpcEndpoint,
+		jobID:         jobID,
+		useOpenSearch: useOpenSearch,
+		traceCtx:      context.Background(),
+		runCtx:        context.Background(),
+		backoff:       DefaultBackoff,
+
+		minBufferSize:   MinBufferSize,
+		maxBufferSize:   MaxBufferSize,
+		channelSize:     ChannelSize,
+		bufferEmptyRate: BufferEmptyRate,
+		maxBatchBytes:   MaxBatchBytes,
+		maxInFlight:     MaxInFlightBatches,
+		dedupEnabled:    true,
+		merge:           LastWriteWins,
+	}
+	for _, opt := range opts {
+		opt(&rs)
+	}
+	if rs.backoff.MaxAttempts < 1 {
+		return nil, errors.New("backoff max attempts must be at least 1")
+	}
+	if rs.dedupEnabled && rs.merge == nil {
+		return nil, errors.New("dedup merge function must not be nil, use WithoutDedup to turn deduplication off")
+	}
+	switch {
+	case rs.minBufferSize < 1 || rs.maxBufferSize < rs.minBufferSize:
+		return nil, fmt.Errorf("invalid buffer sizes: min %d, max %d", rs.minBufferSize, rs.maxBufferSize)
+	case rs.channelSize < 0:
+		return nil, fmt.Errorf("invalid channel size %d", rs.channelSize)
+	case rs.bufferEmptyRate <= 0:
+		return nil, fmt.Errorf("invalid buffer empty rate %s", rs.bufferEmptyRate)
+	case rs.maxBatchBytes <= docOverheadBytes:
+		return nil, fmt.Errorf("invalid max batch bytes %d", rs.maxBatchBytes)
+	case rs.maxInFlight < 1:
+		return nil, fmt.Errorf("invalid max in-flight batches %d", rs.maxInFlight)
+	}
+	rs.resourceChannel = make(chan *es.TaskResult, rs.channelSize)
+
+	if rs.reconcileConfig.Enabled {
+		var err error
+		rs.reconciler, err = newReconciler(rs.reconcileClient, rs.reconcileConfig, logger, rs.writeBatches)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create reconciler: %w", err)
+		}
+	}
+
+	if rs.spoolConfig.Dir != "" {
+		var err error
+		rs.spool, err = openSpool(rs.spoolConfig, jobID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spool: %w", err)
+		}
+	}
+
+	if rs.sink == nil {
+		sink, err := NewGRPCSink(grpcEndpoint, rs.tlsConfig, rs.authConfig, logger)
+		if err != nil {
+			return nil, err
+		}
+		rs.sink = sink
+	}
+
+	sendCtx, cancel := context.WithCancelCause(rs.traceCtx)
+	stop := context.AfterFunc(rs.runCtx, func() { cancel(context.Cause(rs.runCtx)) })
+	rs.sendCtx = sendCtx
+	rs.cancelSend = func() {
+		stop()
+		cancel(context.Canceled)
+	}
+
+	rs.startBatchWorkers()
+	go rs.ResourceHandler()
+	return &rs, nil
 }
 
 func (s *ResourceSender) ResourceHandler() {
-	t := time.NewTicker(BufferEmptyRate)
+	defer close(s.doneChannel)
+	// Every batch must be acknowledged before Finish returns, even if the
+	// handler panicked.
+	defer s.stopBatchWorkers()
+	defer s.recoverPanic()
+
+	t := time.NewTicker(s.bufferEmptyRate)
 	defer t.Stop()
 
 	for {
 		select {
-		case resource := <-s.resourceChannel:
-			if resource == nil {
+		case resource, ok := <-s.resourceChannel:
+			if !ok {
 				s.flushBuffer(true)
-				s.doneChannel <- struct{}{}
+				s.stopBatchWorkers()
+				if s.spool != nil {
+					s.spoolMu.Lock()
+					s.drainSpool(s.sendCtx)
+					s.spoolMu.Unlock()
+				}
 				return
 			}
 
+			s.idsMu.Lock()
 			s.resourceIDs = append(s.resourceIDs, resource.ResourceID)
+			s.idsMu.Unlock()
 			s.sendBuffer = append(s.sendBuffer, resource)
 
-			if len(s.sendBuffer) > MaxBufferSize {
+			if len(s.sendBuffer) > s.maxBufferSize {
 				s.flushBuffer(true)
 			}
 		case <-t.C:
+			if s.spool != nil {
+				s.spoolMu.Lock()
+				if s.spool.pending() {
+					s.replaySpool(s.sendCtx)
+				}
+				s.spoolMu.Unlock()
+			}
 			s.flushBuffer(false)
 		}
 	}
 }
 
-func (s *ResourceSender) sendToBackend(resourcesToSend []es.Doc) {
-	grpcCtx := metadata.NewOutgoingContext(context.Background(), metadata.New(map[string]string{
-		"resource-job-id": fmt.Sprintf("%d", s.jobID),
-	}))
+// recoverPanic records a panic of the handler or a batch worker, so Finish
+// reports it instead of the process crashing.
+func (s *ResourceSender) recoverPanic() {
+	if r := recover(); r != nil {
+		s.logger.Error("result handler panicked", zap.Any("panic", r), zap.Stack("stack"))
+		s.report.mu.Lock()
+		s.handlerErr = errors.Join(s.handlerErr, fmt.Errorf("result handler panicked: %v", r))
+		s.report.mu.Unlock()
+	}
+}
 
-	docs := make([]*anypb.Any, 0, len(resourcesToSend))
+// sendToBackend collapses duplicates among the buffered resources, encodes
+// them and dispatches them in batches of at most maxBatchBytes, in order.
+// With index ordering a batch only holds documents of one index.
+func (s *ResourceSender) sendToBackend(resourcesToSend []*es.TaskResult) {
+	resourcesToSend = s.dedup(resourcesToSend)
+
+	var batch docBatch
+	batchBytes := 0
 	for _, resource := range resourcesToSend {
-		docBytes, err := json.Marshal(resource)
+		doc, err := NewDocument(resource)
 		if err != nil {
-			s.logger.Error("failed to marshal resource", zap.Error(err))
+			s.logger.Error("failed to marshal resource", zap.String("resourceID", resource.ResourceID), zap.Error(err))
+			s.report.drop(resource.ResourceID)
+			continue
+		}
+		size := len(doc.Body) + docOverheadBytes
+		if size > s.maxBatchBytes {
+			s.logger.Error("resource is larger than the maximum batch size", zap.String("resourceID", resource.ResourceID),
+				zap.Int("bytes", len(doc.Body)), zap.Int("maxBatchBytes", s.maxBatchBytes))
+			s.report.reject(resource.ResourceID)
 			continue
 		}
-		docs = append(docs, &anypb.Any{Value: docBytes})
+		if batchBytes+size > s.maxBatchBytes || (s.indexOrdering && len(batch.Docs) > 0 && batch.Index != doc.Index) {
+			s.dispatch(batch)
+			batch, batchBytes = docBatch{}, 0
+		}
+		if s.reconciler != nil {
+			if s.indexes == nil {
+				s.indexes = make(map[string]map[string]struct{})
+			}
+			if s.indexes[doc.Index] == nil {
+				s.indexes[doc.Index] = make(map[string]struct{})
+			}
+			s.indexes[doc.Index][doc.PlatformID] = struct{}{}
+		}
+		batch.Index = doc.Index
+		batch.Docs = append(batch.Docs, doc)
+		batchBytes += size
 	}
+	if len(batch.Docs) > 0 {
+		s.dispatch(batch)
+	}
+}
+
+// sendBatch writes one batch to the sink, retrying with backoff, and records
+// the outcome in the delivery report. With a spool, a batch the sink cannot
+// take right now, or that would overtake spooled ones, is spooled instead.
+func (s *ResourceSender) sendBatch(batch docBatch) {
+	ctx, span := tracing.Tracer().Start(s.sendCtx, "results.send_batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("results.batch_size", len(batch.Docs))))
+	defer span.End()
 
-	_, err := s.client.Ingest(grpcCtx, &golang.IngestRequest{Docs: docs})
+	if s.spool != nil {
+		s.spoolMu.Lock()
+		spooled := s.spool.pending() && !s.replaySpool(ctx)
+		if spooled {
+			s.spoolBatch(ctx, batch)
+		}
+		s.spoolMu.Unlock()
+		if spooled {
+			return
+		}
+	}
+
+	start := time.Now()
+	err := s.ingest(s.ingestContext(ctx), batch.Docs, s.backoff.MaxAttempts)
+	metrics.FlushDuration.Observe(time.Since(start).Seconds())
+	metrics.BatchSize.Observe(float64(len(batch.Docs)))
 	if err != nil {
-		s.logger.Error("failed to send resource", zap.Error(err))
-		if errors.Is(err, io.EOF) {
-			err = s.Connect()
-			if err != nil {
-				s.logger.Error("failed to reconnect", zap.Error(err))
-			}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		// A batch cut short by the run being cancelled fails, since the
+		// spool is dropped when the run ends.
+		if s.spool != nil && isTransient(err) && ctx.Err() == nil {
+			s.logger.Warn("failed to send resources, spooling them", zap.Int("batchSize", len(batch.Docs)), zap.Error(err))
+			s.spoolMu.Lock()
+			s.spoolBatch(ctx, batch)
+			s.spoolMu.Unlock()
+			return
 		}
+		s.logger.Error("failed to send resources", zap.Int("batchSize", len(batch.Docs)), zap.Error(err))
+		s.report.fail(batch.resourceIDs()...)
 		return
 	}
+	s.report.sent(len(batch.Docs))
+}
+
+// ingestContext returns the context the sink is written with, carrying the
+// run the batch belongs to.
+func (s *ResourceSender) ingestContext(ctx context.Context) context.Context {
+	ctx = WithRunInfo(ctx, RunInfo{RunID: s.jobID, TaskType: s.taskType})
+	return metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{
+		"resource-job-id": fmt.Sprintf("%d", s.jobID),
+	}))
+}
+
+// ingest writes docs to the sink until it succeeds, fails with an error that
+// is not transient, the attempts run out or ctx is done.
+func (s *ResourceSender) ingest(ctx context.Context, docs []Document, attempts int) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			metrics.IngestRetries.Inc()
+			if waitErr := sleep(ctx, s.backoff.delay(attempt-1)); waitErr != nil {
+				return fmt.Errorf("%w, last error: %w", waitErr, err)
+			}
+		}
+
+		err = s.sink.Write(ctx, docs)
+		if err == nil {
+			return nil
+		}
+		metrics.IngestErrors.Inc()
+		if !isTransient(err) {
+			return err
+		}
+		if attempt < attempts {
+			s.logger.Warn("failed to send resources, retrying", zap.Int("attempt", attempt), zap.Error(err))
+		}
+	}
+	return err
+}
+
+// sleep waits for d, and returns the cause of ctx if it is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// writeBatches ingests docs in batches of at most maxBatchBytes.
+func (s *ResourceSender) writeBatches(ctx context.Context, docs []Document) error {
+	for len(docs) > 0 {
+		n, size := 0, 0
+		for n < len(docs) && (n == 0 || size+len(docs[n].Body)+docOverheadBytes <= s.maxBatchBytes) {
+			size += len(docs[n].Body) + docOverheadBytes
+			n++
+		}
+		if err := s.ingest(s.ingestContext(ctx), docs[:n], s.backoff.MaxAttempts); err != nil {
+			return err
+		}
+		docs = docs[n:]
+	}
+	return nil
 }
 
 func (s *ResourceSender) flushBuffer(force bool) {
@@ -138,35 +528,150 @@ func (s *ResourceSender) flushBuffer(force bool) {
 		return
 	}
 
-	if !force && len(s.sendBuffer) < MinBufferSize {
+	if !force && len(s.sendBuffer) < s.minBufferSize {
 		return
 	}
 
-	resourcesToSend := make([]es.Doc, 0, 2*len(s.sendBuffer))
+	s.sendToBackend(s.sendBuffer)
+	s.sendBuffer = nil
+}
 
-	for _, resource := range s.sendBuffer {
-		kafkaResource := resource
-		keys, idx := kafkaResource.KeysAndIndex()
-		kafkaResource.EsID = es.HashOf(keys...)
-		kafkaResource.EsIndex = idx
+// Finish flushes what is buffered, waits until every batch is acknowledged,
+// failed or spooled, replays the spool, reconciles stale documents and closes
+// the connection. It returns a *DeliveryError if any result was lost. Later
+// calls return the same error.
+func (s *ResourceSender) Finish() error {
+	s.finishOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		close(s.resourceChannel)
+		s.mu.Unlock()
+
+		<-s.doneChannel
+		report := s.report.snapshot()
+		reconcileErr := s.reconcile(report)
+		s.cancelSend()
+		if err := s.sink.Close(); err != nil {
+			s.logger.Warn("failed to close the result sink", zap.Error(err))
+		}
 
-		resourcesToSend = append(resourcesToSend, kafkaResource)
+		if report.Err() != nil {
+			s.logger.Error("results were not fully delivered", zap.Int("sent", report.Sent),
+				zap.Int("failed", report.Failed), zap.Int("dropped", report.Dropped), zap.Int("rejected", report.Rejected),
+				zap.Int("lostSpoolSegments", report.LostSegments))
+		}
+		s.finishErr = errors.Join(s.handlerErr, report.Err(), s.report.lost(), reconcileErr)
+	})
+	return s.finishErr
+}
+
+// reconcile removes stale documents from the indexes the run wrote to. It is
+// skipped unless every result was delivered, so a failed write never makes a
+// live resource look stale. Errors are only returned outside of dry runs.
+func (s *ResourceSender) reconcile(report DeliveryReport) error {
+	if s.reconciler == nil || len(s.indexes) == 0 {
+		return nil
+	}
+	if report.Err() != nil || s.handlerErr != nil {
+		s.logger.Warn("not reconciling stale documents, the run did not deliver every result")
+		return nil
+	}
+	if err := s.sendCtx.Err(); err != nil {
+		s.logger.Warn("not reconciling stale documents, the run was cancelled", zap.Error(context.Cause(s.sendCtx)))
+		return nil
 	}
 
-	s.sendToBackend(resourcesToSend)
-	s.sendBuffer = nil
+	indexes := make([]string, 0, len(s.indexes))
+	for index := range s.indexes {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+
+	var errs []error
+	for _, index := range indexes {
+		platformIDs := make([]string, 0, len(s.indexes[index]))
+		for platformID := range s.indexes[index] {
+			platformIDs = append(platformIDs, platformID)
+		}
+		sort.Strings(platformIDs)
+
+		for _, platformID := range platformIDs {
+			scope := ReconcileScope{TaskType: s.taskType, PlatformID: platformID}
+			if _, err := s.reconciler.Reconcile(s.sendCtx, index, scope, s.GetResourceIDs()); err != nil {
+				s.logger.Error("failed to reconcile stale documents", zap.String("index", index), zap.String("platformID", platformID), zap.Error(err))
+				if !s.reconcileConfig.DryRun {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (s *ResourceSender) Finish() {
-	s.resourceChannel <- nil
-	_ = <-s.doneChannel
-	s.conn.Close()
+// Report returns the delivery report. It is complete once Finish returned.
+func (s *ResourceSender) Report() DeliveryReport {
+	return s.report.snapshot()
 }
 
+// GetResourceIDs returns a copy of the IDs of the resources handled so far.
 func (s *ResourceSender) GetResourceIDs() []string {
-	return s.resourceIDs
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+	return slices.Clone(s.resourceIDs)
+}
+
+// Send queues resource for delivery, waiting while the buffer is full. It
+// returns ctx.Err() if ctx is done first, and ErrSenderClosed after Finish
+// or once the sender stopped.
+func (s *ResourceSender) Send(ctx context.Context, resource *es.TaskResult) error {
+	return s.send(ctx, resource, true)
+}
+
+// TrySend queues resource for delivery without waiting, and returns
+// ErrSenderFull if the buffer is full.
+func (s *ResourceSender) TrySend(resource *es.TaskResult) error {
+	return s.send(context.Background(), resource, false)
 }
 
-func (s *ResourceSender) Send(resource *es.TaskResult) {
-	s.resourceChannel <- resource
+func (s *ResourceSender) send(ctx context.Context, resource *es.TaskResult, wait bool) error {
+	if resource == nil {
+		return errors.New("results: nil resource")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrSenderClosed
+	}
+	select {
+	case <-s.doneChannel:
+		return ErrSenderClosed
+	default:
+	}
+
+	select {
+	case s.resourceChannel <- resource:
+		return nil
+	default:
+	}
+	if !wait {
+		metrics.SendRejections.Inc()
+		return ErrSenderFull
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.SendBlockedDuration.Observe(time.Since(start).Seconds())
+	}()
+	select {
+	case s.resourceChannel <- resource:
+		return nil
+	case <-s.doneChannel:
+		return ErrSenderClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
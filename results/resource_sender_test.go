@@ -0,0 +1,455 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-util/pkg/es"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSink returns errs in turn, one per Write, and records the documents of
+// the writes that succeeded. onWrite, if set, is called before each Write.
+type fakeSink struct {
+	mu      sync.Mutex
+	errs    []error
+	onWrite func()
+	writes  int
+	batches [][]Document
+	closed  bool
+}
+
+func (s *fakeSink) Write(_ context.Context, docs []Document) error {
+	if s.onWrite != nil {
+		s.onWrite()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.batches = append(s.batches, docs)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) resourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, batch := range s.batches {
+		for _, doc := range batch {
+			ids = append(ids, doc.ResourceID)
+		}
+	}
+	return ids
+}
+
+// batchIDs returns the resource IDs of each batch written.
+func (s *fakeSink) batchIDs() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batches := make([][]string, 0, len(s.batches))
+	for _, batch := range s.batches {
+		batches = append(batches, docBatch{Docs: batch}.resourceIDs())
+	}
+	return batches
+}
+
+// waitForWrites waits until the sink took n writes.
+func (s *fakeSink) waitForWrites(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		writes := s.writes
+		s.mu.Unlock()
+		if writes >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sink took %d writes, want %d", writes, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fastBackoff retries right away so tests do not wait.
+var fastBackoff = BackoffConfig{MaxAttempts: 3, Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+
+func testResult(id string) *es.TaskResult {
+	return &es.TaskResult{ResourceID: id, ResultType: "test_result", TaskType: "test", Description: map[string]string{"id": id}}
+}
+
+// sendAll sends one result per id through a new ResourceSender on sink and
+// finishes it.
+func sendAll(t *testing.T, sink Sink, ids []string, opts ...ResourceSenderOption) (*ResourceSender, error) {
+	t.Helper()
+	opts = append([]ResourceSenderOption{WithSink(sink), WithSenderBackoff(fastBackoff)}, opts...)
+	sender, err := NewResourceSender("", 1, false, zap.NewNop(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := sender.Send(context.Background(), testResult(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sender, sender.Finish()
+}
+
+func TestResourceSenderRetries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name       string
+		errs       []error
+		wantWrites int
+		wantSent   int
+		wantFailed int
+	}{
+		{name: "delivered", wantWrites: 1, wantSent: 2},
+		{name: "transient error is retried", errs: []error{unavailable}, wantWrites: 2, wantSent: 2},
+		{name: "marked retryable is retried", errs: []error{Retryable(errors.New("503"))}, wantWrites: 2, wantSent: 2},
+		{name: "attempts run out", errs: []error{unavailable, unavailable, unavailable}, wantWrites: 3, wantFailed: 2},
+		{name: "permanent error is not retried", errs: []error{status.Error(codes.InvalidArgument, "bad document")}, wantWrites: 1, wantFailed: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{errs: tt.errs}
+			sender, err := sendAll(t, sink, []string{"a", "b"})
+
+			var deliveryErr *DeliveryError
+			if got := errors.As(err, &deliveryErr); got != (tt.wantFailed > 0) {
+				t.Fatalf("Finish() error = %v, want a delivery error %v", err, tt.wantFailed > 0)
+			}
+			report := sender.Report()
+			if report.Sent != tt.wantSent || report.Failed != tt.wantFailed || len(report.FailedIDs) != tt.wantFailed {
+				t.Errorf("report = %+v, want %d sent and %d failed", report, tt.wantSent, tt.wantFailed)
+			}
+			if deliveryErr != nil && deliveryErr.Partial() {
+				t.Errorf("Partial() = true with nothing delivered")
+			}
+			if sink.writes != tt.wantWrites {
+				t.Errorf("%d writes, want %d", sink.writes, tt.wantWrites)
+			}
+			if !sink.closed {
+				t.Error("sink was not closed")
+			}
+		})
+	}
+}
+
+func TestResourceSenderFinish(t *testing.T) {
+	sink := &fakeSink{}
+	sender, err := sendAll(t, sink, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := sender.Finish(); again != nil {
+		t.Errorf("second Finish() = %v", again)
+	}
+	if err := sender.Send(context.Background(), testResult("d")); !errors.Is(err, ErrSenderClosed) {
+		t.Errorf("Send() after Finish = %v, want ErrSenderClosed", err)
+	}
+	if got := fmt.Sprint(sink.resourceIDs()); got != "[a b c]" {
+		t.Errorf("wrote %s, want [a b c]", got)
+	}
+	if got := fmt.Sprint(sender.GetResourceIDs()); got != "[a b c]" {
+		t.Errorf("GetResourceIDs() = %s", got)
+	}
+}
+
+func TestResourceSenderGetResourceIDs(t *testing.T) {
+	sender, err := NewResourceSender("", 1, false, zap.NewNop(), WithSink(&fakeSink{}), WithSenderBackoff(fastBackoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read the IDs while the handler appends to them, for the race detector.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if ids := sender.GetResourceIDs(); len(ids) > 0 {
+				ids[0] = "changed"
+			}
+		}
+	}()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := sender.Send(context.Background(), testResult(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if err := sender.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	ids := sender.GetResourceIDs()
+	ids[0] = "changed"
+	if got := fmt.Sprint(sender.GetResourceIDs()); got != "[a b c]" {
+		t.Errorf("GetResourceIDs() = %s, want a copy of [a b c]", got)
+	}
+}
+
+func TestResourceSenderRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	sink := &fakeSink{errs: []error{unavailable, unavailable, unavailable}, onWrite: cancel}
+	slow := BackoffConfig{MaxAttempts: 3, Initial: time.Hour, Max: time.Hour, Multiplier: 1}
+
+	start := time.Now()
+	sender, err := sendAll(t, sink, []string{"a", "b"}, WithSenderBackoff(slow), withRunContext(ctx))
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Finish() took %s after the run was cancelled", elapsed)
+	}
+	if !errors.As(err, new(*DeliveryError)) {
+		t.Fatalf("Finish() error = %v, want a delivery error", err)
+	}
+	if report := sender.Report(); report.Failed != 2 {
+		t.Errorf("report = %+v, want 2 failed", report)
+	}
+	if sink.writes != 1 {
+		t.Errorf("%d writes, want 1 before the run was cancelled", sink.writes)
+	}
+}
+
+func TestIngestStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	sender := &ResourceSender{
+		logger:  zap.NewNop(),
+		sink:    &fakeSink{errs: []error{unavailable}, onWrite: cancel},
+		backoff: BackoffConfig{MaxAttempts: 2, Initial: time.Hour, Max: time.Hour, Multiplier: 1},
+	}
+
+	err := sender.ingest(ctx, []Document{{ResourceID: "a"}}, 2)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, unavailable) {
+		t.Errorf("ingest() error = %v, want the cancellation and the last error", err)
+	}
+}
+
+func TestNewResourceSenderOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []ResourceSenderOption
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "tuned", opts: []ResourceSenderOption{WithMinBufferSize(1), WithMaxBufferSize(1), WithChannelSize(0), WithBufferEmptyRate(time.Millisecond), WithMaxBatchBytes(1024)}},
+		{name: "no min buffer", opts: []ResourceSenderOption{WithMinBufferSize(0)}, wantErr: true},
+		{name: "max below min buffer", opts: []ResourceSenderOption{WithMinBufferSize(5), WithMaxBufferSize(4)}, wantErr: true},
+		{name: "negative channel size", opts: []ResourceSenderOption{WithChannelSize(-1)}, wantErr: true},
+		{name: "no flush interval", opts: []ResourceSenderOption{WithBufferEmptyRate(0)}, wantErr: true},
+		{name: "batch limit below the framing", opts: []ResourceSenderOption{WithMaxBatchBytes(docOverheadBytes)}, wantErr: true},
+		{name: "no attempts", opts: []ResourceSenderOption{WithSenderBackoff(BackoffConfig{})}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			sender, err := NewResourceSender("", 1, false, zap.NewNop(), append([]ResourceSenderOption{WithSink(sink)}, tt.opts...)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewResourceSender() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := sender.Finish(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceSenderFlushes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ResourceSenderOption
+		send int
+	}{
+		{name: "buffer full", opts: []ResourceSenderOption{WithMinBufferSize(1), WithMaxBufferSize(2)}, send: 3},
+		{name: "periodic flush", opts: []ResourceSenderOption{WithMinBufferSize(1), WithBufferEmptyRate(10 * time.Millisecond)}, send: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			sender, err := NewResourceSender("", 1, false, zap.NewNop(), append([]ResourceSenderOption{WithSink(sink)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.send; i++ {
+				if err := sender.Send(context.Background(), testResult(fmt.Sprint(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Sent before Finish flushes what is left.
+			sink.waitForWrites(t, 1)
+			if err := sender.Finish(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestResourceSenderBatchBytes(t *testing.T) {
+	doc, err := NewDocument(testResult("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docBytes := len(doc.Body) + docOverheadBytes
+
+	large := testResult("large")
+	large.Description = map[string]string{"id": string(make([]byte, 4*docBytes))}
+	tests := []struct {
+		name         string
+		maxBatch     int
+		results      []*es.TaskResult
+		wantBatches  [][]string
+		wantRejected int
+	}{
+		{name: "one batch", maxBatch: 3 * docBytes, results: []*es.TaskResult{testResult("a"), testResult("b"), testResult("c")}, wantBatches: [][]string{{"a", "b", "c"}}},
+		{name: "split", maxBatch: 2 * docBytes, results: []*es.TaskResult{testResult("a"), testResult("b"), testResult("c")}, wantBatches: [][]string{{"a", "b"}, {"c"}}},
+		{name: "one per batch", maxBatch: docBytes, results: []*es.TaskResult{testResult("a"), testResult("b")}, wantBatches: [][]string{{"a"}, {"b"}}},
+		{name: "too large", maxBatch: 2 * docBytes, results: []*es.TaskResult{testResult("a"), large, testResult("b")}, wantBatches: [][]string{{"a", "b"}}, wantRejected: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			sender, err := NewResourceSender("", 1, false, zap.NewNop(), WithSink(sink), WithMaxBatchBytes(tt.maxBatch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, result := range tt.results {
+				if err := sender.Send(context.Background(), result); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = sender.Finish()
+			if (err != nil) != (tt.wantRejected > 0) {
+				t.Fatalf("Finish() error = %v", err)
+			}
+			if got := sink.batchIDs(); !reflect.DeepEqual(got, tt.wantBatches) {
+				t.Errorf("batches %v, want %v", got, tt.wantBatches)
+			}
+			if report := sender.Report(); report.Rejected != tt.wantRejected {
+				t.Errorf("report = %+v, want %d rejected", report, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestResourceSenderParallelBatches(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	sink := &fakeSink{onWrite: func() {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if n <= seen || maxInFlight.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}}
+	doc, err := NewDocument(testResult("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 10)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	sender, err := sendAll(t, sink, ids, WithMaxInFlightBatches(3), WithMaxBatchBytes(len(doc.Body)+docOverheadBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := maxInFlight.Load(); got < 2 || got > 3 {
+		t.Errorf("%d batches were in flight at once, want 2 to 3", got)
+	}
+	if report := sender.Report(); report.Sent != 10 {
+		t.Errorf("report = %+v, want 10 sent", report)
+	}
+}
+
+func TestResourceSenderIndexOrdering(t *testing.T) {
+	var results []*es.TaskResult
+	for i := 0; i < 30; i++ {
+		result := testResult(fmt.Sprint(i))
+		result.ResultType = fmt.Sprintf("type_%d", i%3)
+		results = append(results, result)
+	}
+	want := make(map[string][]string)
+	for _, result := range results {
+		doc, err := NewDocument(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[doc.Index] = append(want[doc.Index], doc.ResourceID)
+	}
+
+	sink := &fakeSink{onWrite: func() { time.Sleep(time.Duration(rand.IntN(3)) * time.Millisecond) }}
+	sender, err := NewResourceSender("", 1, false, zap.NewNop(), WithSink(sink), WithMaxInFlightBatches(4), WithIndexOrdering(true),
+		WithMinBufferSize(1), WithMaxBufferSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if err := sender.Send(context.Background(), result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][]string)
+	sink.mu.Lock()
+	for _, batch := range sink.batches {
+		for _, doc := range batch {
+			if doc.Index != batch[0].Index {
+				t.Errorf("batch mixes indexes %s and %s", batch[0].Index, doc.Index)
+			}
+			got[doc.Index] = append(got[doc.Index], doc.ResourceID)
+		}
+	}
+	sink.mu.Unlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("documents per index %v, want in send order %v", got, want)
+	}
+}
+
+func TestResourceSenderBatchWorkerPanics(t *testing.T) {
+	var once sync.Once
+	sink := &fakeSink{onWrite: func() { once.Do(func() { panic("sink broke") }) }}
+	doc, err := NewDocument(testResult("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := sendAll(t, sink, []string{"0", "1", "2", "3"}, WithMaxInFlightBatches(2), WithMaxBatchBytes(len(doc.Body)+docOverheadBytes))
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("Finish() error = %v, want the panic", err)
+	}
+	if report := sender.Report(); report.Sent != 3 {
+		t.Errorf("report = %+v, want the other 3 batches sent", report)
+	}
+}
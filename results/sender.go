@@ -0,0 +1,145 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/tracing"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"go.uber.org/zap"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Sender delivers the results of a task run. ResourceSender implements it by
+// streaming to the ES sink service.
+type Sender interface {
+	// Send queues a result, waiting while the sender is busy. It fails once
+	// ctx is done or the sender is finished.
+	Send(ctx context.Context, resource *es.TaskResult) error
+	// TrySend is Send without waiting; it fails with ErrSenderFull instead.
+	TrySend(resource *es.TaskResult) error
+	// Finish delivers what is left and returns a *DeliveryError if any
+	// result was lost.
+	Finish() error
+	GetResourceIDs() []string
+}
+
+// SenderFactory builds the Sender for one task run, tuned with opts where it
+// applies.
+type SenderFactory func(ctx context.Context, request tasks.TaskRequest, logger *zap.Logger, opts ...ResourceSenderOption) (Sender, error)
+
+type senderFactoryKey struct{}
+
+// WithSenderFactory returns a context whose task runs deliver their results
+// through the senders factory builds.
+func WithSenderFactory(ctx context.Context, factory SenderFactory) context.Context {
+	return context.WithValue(ctx, senderFactoryKey{}, factory)
+}
+
+// NewRunSender returns the Sender a task should emit its results through,
+// built by the factory set with WithSenderFactory, tuned with opts. Without
+// one it is NewTaskSender with the default config.
+func NewRunSender(ctx context.Context, request tasks.TaskRequest, logger *zap.Logger, opts ...ResourceSenderOption) (Sender, error) {
+	if factory, ok := ctx.Value(senderFactoryKey{}).(SenderFactory); ok && factory != nil {
+		return factory(ctx, request, logger, opts...)
+	}
+	return NewTaskSender(ctx, config.Default().Results, nil, request, logger, opts...)
+}
+
+// NewTaskSender returns a ResourceSender for one task run, writing to the
+// sinks of cfg and tuned with opts. client is used by the opensearch sink and
+// reconciliation, and may be nil when neither is configured. The run is
+// cancelled with ctx.
+func NewTaskSender(ctx context.Context, cfg config.ResultsConfig, client *opensearch.Client, request tasks.TaskRequest, logger *zap.Logger, opts ...ResourceSenderOption) (Sender, error) {
+	sink, err := NewSink(ctx, cfg, request.EsDeliverEndpoint, client, logger)
+	if err != nil {
+		return nil, err
+	}
+	senderOpts := []ResourceSenderOption{
+		withTraceContext(tracing.Detach(ctx)),
+		withRunContext(ctx),
+		withTaskType(request.TaskDefinition.TaskType),
+		WithSink(sink),
+		WithSenderSpool(cfg.Spool),
+	}
+	if !cfg.Dedup {
+		senderOpts = append(senderOpts, WithoutDedup())
+	}
+	if cfg.Reconcile.Enabled {
+		senderOpts = append(senderOpts, WithReconcile(cfg.Reconcile, client))
+	}
+	senderOpts = append(senderOpts, opts...)
+
+	sender, err := NewResourceSender(request.EsDeliverEndpoint, request.TaskDefinition.RunID, request.UseOpenSearch, logger, senderOpts...)
+	if err != nil {
+		_ = sink.Close()
+		return nil, err
+	}
+	return sender, nil
+}
+
+// NDJSONSender writes every result as one JSON line to a writer.
+type NDJSONSender struct {
+	logger      *zap.Logger
+	mu          sync.Mutex
+	encoder     *json.Encoder
+	resourceIDs []string
+	report      DeliveryReport
+	closed      bool
+}
+
+func NewNDJSONSender(w io.Writer, logger *zap.Logger) *NDJSONSender {
+	return &NDJSONSender{
+		logger:  logger,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (s *NDJSONSender) Send(ctx context.Context, resource *es.TaskResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.TrySend(resource)
+}
+
+func (s *NDJSONSender) TrySend(resource *es.TaskResult) error {
+	if resource == nil {
+		return errors.New("results: nil resource")
+	}
+	keys, idx := resource.KeysAndIndex()
+	resource.EsID = es.HashOf(keys...)
+	resource.EsIndex = idx
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSenderClosed
+	}
+	s.resourceIDs = append(s.resourceIDs, resource.ResourceID)
+	if err := s.encoder.Encode(resource); err != nil {
+		s.logger.Error("failed to write resource", zap.String("resourceID", resource.ResourceID), zap.Error(err))
+		s.report.fail(resource.ResourceID)
+		return nil
+	}
+	s.report.Sent++
+	return nil
+}
+
+func (s *NDJSONSender) Finish() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.report.Err()
+}
+
+// GetResourceIDs returns a copy of the IDs of the resources written so far.
+func (s *NDJSONSender) GetResourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.resourceIDs)
+}
@@ -0,0 +1,214 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SinkGRPC       = "grpc"
+	SinkOpenSearch = "opensearch"
+	SinkPipeline   = "pipeline"
+	SinkNDJSON     = "ndjson"
+	SinkStdout     = "stdout"
+)
+
+// Sink stores batches of result documents. ResourceSender buffers, batches,
+// retries and spools on top of it.
+type Sink interface {
+	// Write stores docs. Errors marked with Retryable, and transient gRPC
+	// statuses, are retried.
+	Write(ctx context.Context, docs []Document) error
+	Close() error
+}
+
+// Document is one encoded result and where it is stored.
+type Document struct {
+	ID         string `json:"id"`
+	Index      string `json:"index"`
+	ResourceID string `json:"resource_id"`
+	PlatformID string `json:"platform_id,omitempty"`
+	Body       []byte `json:"body"`
+}
+
+// NewDocument sets the ES ID and index of resource from its keys and encodes
+// it.
+func NewDocument(resource *es.TaskResult) (Document, error) {
+	keys, idx := resource.KeysAndIndex()
+	resource.EsID = es.HashOf(keys...)
+	resource.EsIndex = idx
+
+	body, err := json.Marshal(resource)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{
+		ID:         resource.EsID,
+		Index:      resource.EsIndex,
+		ResourceID: resource.ResourceID,
+		PlatformID: resource.PlatformID,
+		Body:       body,
+	}, nil
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient, so the batch is written again.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// isTransient reports whether a failed write is worth retrying: it was
+// marked with Retryable, or is a gRPC status with a retryable code.
+func isTransient(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable) || isRetryable(err)
+}
+
+// NewSink builds the sinks named in cfg.Sinks for one task run. The grpc sink
+// writes to grpcEndpoint and the opensearch sink through client. More than
+// one is combined with a FanOutSink.
+func NewSink(ctx context.Context, cfg config.ResultsConfig, grpcEndpoint string, client *opensearch.Client, logger *zap.Logger) (Sink, error) {
+	var sinks []Sink
+	closeAll := func() {
+		for _, sink := range sinks {
+			_ = sink.Close()
+		}
+	}
+
+	for _, name := range strings.Split(cfg.Sinks, ",") {
+		var sink Sink
+		var err error
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			continue
+		case SinkGRPC:
+			sink, err = NewGRPCSink(grpcEndpoint, cfg.TLS, cfg.Auth, logger)
+		case SinkOpenSearch:
+			if client == nil {
+				err = errors.New("no OpenSearch client is configured")
+				break
+			}
+			sink = NewOpenSearchSink(client)
+		case SinkPipeline:
+			sink, err = NewPipelineSink(ctx, cfg.Pipeline, &http.Client{Timeout: 10 * time.Second})
+		case SinkNDJSON:
+			sink, err = NewNDJSONFileSink(cfg.NDJSONFile)
+		case SinkStdout:
+			sink = NewNDJSONSink(os.Stdout)
+		default:
+			err = errors.New("unknown sink")
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to create %s sink: %w", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, errors.New("no result sink is configured")
+	case 1:
+		return sinks[0], nil
+	default:
+		return NewFanOutSink(sinks...), nil
+	}
+}
+
+// NDJSONSink writes every document as one JSON line.
+type NDJSONSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewNDJSONSink writes to w, which is left open on Close.
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{w: w}
+}
+
+// NewNDJSONFileSink appends to the file at path.
+func NewNDJSONFileSink(path string) (*NDJSONSink, error) {
+	if path == "" {
+		return nil, errors.New("no NDJSON file is configured")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &NDJSONSink{w: f, closer: f}, nil
+}
+
+func (s *NDJSONSink) Write(_ context.Context, docs []Document) error {
+	var content []byte
+	for _, doc := range docs {
+		content = append(content, doc.Body...)
+		content = append(content, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(content)
+	return err
+}
+
+func (s *NDJSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// FanOutSink writes every batch to several sinks at once. A batch is written
+// again to all of them when any fails with a retryable error, so sinks that
+// are not idempotent, like NDJSON files, may see it twice.
+type FanOutSink struct {
+	sinks []Sink
+}
+
+func NewFanOutSink(sinks ...Sink) *FanOutSink {
+	return &FanOutSink{sinks: sinks}
+}
+
+func (s *FanOutSink) Write(ctx context.Context, docs []Document) error {
+	errs := make([]error, len(s.sinks))
+	var wg sync.WaitGroup
+	for i, sink := range s.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sink.Write(ctx, docs)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *FanOutSink) Close() error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
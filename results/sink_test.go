@@ -0,0 +1,246 @@
+package results
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestOpenSearch returns a client of an OpenSearch emulated by handler.
+func newTestOpenSearch(t *testing.T, handler http.HandlerFunc) *opensearch.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testDocs(ids ...string) []Document {
+	return testBatch(ids...).Docs
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil"},
+		{name: "marked retryable", err: Retryable(errors.New("bulk request failed: 429")), want: true},
+		{name: "wrapped retryable", err: fmt.Errorf("write: %w", Retryable(errors.New("timeout"))), want: true},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), want: true},
+		{name: "resource exhausted", err: status.Error(codes.ResourceExhausted, "slow down"), want: true},
+		{name: "aborted", err: status.Error(codes.Aborted, "conflict"), want: true},
+		{name: "deadline exceeded", err: status.Error(codes.DeadlineExceeded, "too slow"), want: true},
+		{name: "unknown", err: status.Error(codes.Unknown, "handler failed")},
+		{name: "internal", err: status.Error(codes.Internal, "bad document")},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "bad document")},
+		{name: "plain error", err: errors.New("unavailable")},
+		{name: "EOF", err: io.EOF},
+		{name: "cancelled", err: context.Canceled},
+		{name: "one sink of several retryable", err: errors.Join(errors.New("disk full"), status.Error(codes.Unavailable, "down")), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ndjson")
+	for _, ids := range [][]string{{"a", "b"}, {"c"}} {
+		sink, err := NewNDJSONFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(context.Background(), testDocs(ids...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var body struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &body); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, body.ID)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("file holds %v, want %v appended in order", got, want)
+	}
+
+	if _, err := NewNDJSONFileSink(""); err == nil {
+		t.Error("NewNDJSONFileSink(\"\") succeeded")
+	}
+}
+
+func TestFanOutSink(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	tests := []struct {
+		name          string
+		errs          [][]error
+		wantErr       bool
+		wantTransient bool
+	}{
+		{name: "all succeed", errs: [][]error{nil, nil}},
+		{name: "one fails", errs: [][]error{nil, {unavailable}}, wantErr: true, wantTransient: true},
+		{name: "one rejects", errs: [][]error{{status.Error(codes.InvalidArgument, "bad")}, nil}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sinks []Sink
+			var fakes []*fakeSink
+			for _, errs := range tt.errs {
+				fake := &fakeSink{errs: errs}
+				fakes = append(fakes, fake)
+				sinks = append(sinks, fake)
+			}
+			sink := NewFanOutSink(sinks...)
+
+			err := sink.Write(context.Background(), testDocs("a"))
+			if (err != nil) != tt.wantErr || isTransient(err) != tt.wantTransient {
+				t.Errorf("Write() error = %v, want error %v, transient %v", err, tt.wantErr, tt.wantTransient)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for i, fake := range fakes {
+				if fake.writes != 1 || !fake.closed {
+					t.Errorf("sink %d: %d writes, closed %v", i, fake.writes, fake.closed)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	ndjsonFile := filepath.Join(t.TempDir(), "results.ndjson")
+	client := newTestOpenSearch(t, func(http.ResponseWriter, *http.Request) {})
+	tests := []struct {
+		name     string
+		sinks    string
+		client   *opensearch.Client
+		wantType string
+		wantErr  string
+	}{
+		{name: "grpc", sinks: SinkGRPC, wantType: "*results.GRPCSink"},
+		{name: "opensearch", sinks: SinkOpenSearch, client: client, wantType: "*results.OpenSearchSink"},
+		{name: "opensearch without a client", sinks: SinkOpenSearch, wantErr: "no OpenSearch client"},
+		{name: "ndjson", sinks: SinkNDJSON, wantType: "*results.NDJSONSink"},
+		{name: "stdout", sinks: SinkStdout, wantType: "*results.NDJSONSink"},
+		{name: "several", sinks: " ndjson , stdout,", wantType: "*results.FanOutSink"},
+		{name: "unknown", sinks: "ndjson,kafka", wantErr: "kafka"},
+		{name: "none", sinks: " , ", wantErr: "no result sink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ResultsConfig{Sinks: tt.sinks, NDJSONFile: ndjsonFile}
+			sink, err := NewSink(context.Background(), cfg, "localhost:5051", tt.client, zap.NewNop())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewSink() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+			if got := fmt.Sprintf("%T", sink); got != tt.wantType {
+				t.Errorf("NewSink() = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestOpenSearchSink(t *testing.T) {
+	item := func(status int) string {
+		return fmt.Sprintf(`{"index":{"_id":"x","status":%d,"error":{"type":"e%d"}}}`, status, status)
+	}
+	tests := []struct {
+		name          string
+		status        int
+		response      string
+		wantErr       bool
+		wantTransient bool
+	}{
+		{name: "indexed", status: http.StatusOK, response: `{"errors":false,"items":[` + item(201) + `,` + item(200) + `]}`},
+		{name: "throttled", status: http.StatusTooManyRequests, response: `{}`, wantErr: true, wantTransient: true},
+		{name: "server error", status: http.StatusInternalServerError, response: `{}`, wantErr: true, wantTransient: true},
+		{name: "bad request", status: http.StatusBadRequest, response: `{}`, wantErr: true},
+		{name: "items throttled", status: http.StatusOK, response: `{"errors":true,"items":[` + item(201) + `,` + item(429) + `]}`, wantErr: true, wantTransient: true},
+		{name: "item rejected", status: http.StatusOK, response: `{"errors":true,"items":[` + item(429) + `,` + item(400) + `]}`, wantErr: true},
+		{name: "errors without a failed item", status: http.StatusOK, response: `{"errors":true,"items":[` + item(200) + `]}`},
+		{name: "undecodable response", status: http.StatusOK, response: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			client := newTestOpenSearch(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/_bulk" {
+					t.Errorf("request to %s", r.URL.Path)
+				}
+				body, _ := io.ReadAll(r.Body)
+				lines = strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.response)
+			})
+
+			err := NewOpenSearchSink(client).Write(context.Background(), testDocs("a", "b"))
+			if (err != nil) != tt.wantErr || isTransient(err) != tt.wantTransient {
+				t.Errorf("Write() error = %v, want error %v, transient %v", err, tt.wantErr, tt.wantTransient)
+			}
+			want := []string{
+				`{"index":{"_index":"test_result","_id":"a"}}`, `{"id":"a"}`,
+				`{"index":{"_index":"test_result","_id":"b"}}`, `{"id":"b"}`,
+			}
+			if !reflect.DeepEqual(lines, want) {
+				t.Errorf("bulk body %q, want %q", lines, want)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{"http://127.0.0.1:1"}, DisableRetry: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewOpenSearchSink(client).Write(context.Background(), testDocs("a")); !isTransient(err) {
+			t.Errorf("Write() error = %v, want it transient", err)
+		}
+	})
+}
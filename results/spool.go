@@ -0,0 +1,401 @@
+package results
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/metrics"
+	"github.com/opengovern/og-task-template/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SpoolPolicyBlock      = "block"
+	SpoolPolicyDropOldest = "drop-oldest"
+	SpoolPolicyFail       = "fail"
+
+	spoolSegmentSuffix = ".ndjson"
+)
+
+// errSegmentLost means a spool segment could not be read back, so neither
+// its results nor their number are known.
+var errSegmentLost = errors.New("lost spool segment")
+
+// docBatch is a batch of encoded resources. The spool stores each batch it
+// keeps as one JSON line.
+type docBatch struct {
+	// Index is set when every document belongs to the same index.
+	Index string     `json:"index,omitempty"`
+	Docs  []Document `json:"docs"`
+}
+
+func (b docBatch) resourceIDs() []string {
+	resourceIDs := make([]string, 0, len(b.Docs))
+	for _, doc := range b.Docs {
+		resourceIDs = append(resourceIDs, doc.ResourceID)
+	}
+	return resourceIDs
+}
+
+type spoolSegment struct {
+	path string
+	size int64
+}
+
+// spool keeps batches the ES sink did not accept in append-only segment
+// files, so they survive an outage or a crash and can be replayed in order.
+// It is only used with ResourceSender.spoolMu held.
+type spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	policy       string
+	blockTimeout time.Duration
+
+	segments []spoolSegment
+	size     int64
+	nextSeq  int
+}
+
+// openSpool opens the spool of one run, picking up segments left by an
+// earlier attempt of the same run.
+func openSpool(cfg config.SpoolConfig, jobID uint) (*spool, error) {
+	switch cfg.Policy {
+	case SpoolPolicyBlock, SpoolPolicyDropOldest, SpoolPolicyFail:
+	default:
+		return nil, fmt.Errorf("unknown spool policy %q", cfg.Policy)
+	}
+	if cfg.MaxBytes <= 0 || cfg.SegmentBytes <= 0 {
+		return nil, errors.New("spool max bytes and segment bytes must be positive")
+	}
+	if cfg.Policy == SpoolPolicyBlock && cfg.BlockTimeout <= 0 {
+		return nil, errors.New("spool block timeout must be positive")
+	}
+
+	dir := filepath.Join(cfg.Dir, fmt.Sprintf("run-%d", jobID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:          dir,
+		maxBytes:     int64(cfg.MaxBytes),
+		segmentBytes: int64(cfg.SegmentBytes),
+		policy:       cfg.Policy,
+		blockTimeout: cfg.BlockTimeout,
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spoolSegmentSuffix) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		var seq int
+		if _, err := fmt.Sscanf(file.Name(), "%d"+spoolSegmentSuffix, &seq); err != nil {
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{path: filepath.Join(dir, file.Name()), size: info.Size()})
+		s.size += info.Size()
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	// Names are zero padded, so lexical order is write order.
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].path < s.segments[j].path
+	})
+	metrics.SpoolBytes.Add(float64(s.size))
+	return s, nil
+}
+
+func (s *spool) pending() bool {
+	return len(s.segments) > 0
+}
+
+// fits reports whether n more bytes stay within the size cap.
+func (s *spool) fits(n int64) bool {
+	return s.size+n <= s.maxBytes
+}
+
+func encodeSpoolRecord(record docBatch) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// append writes line to the newest segment, starting a new one when it is
+// full, and syncs it to disk.
+func (s *spool) append(line []byte) error {
+	if len(s.segments) == 0 || s.segments[len(s.segments)-1].size >= s.segmentBytes {
+		s.segments = append(s.segments, spoolSegment{
+			path: filepath.Join(s.dir, fmt.Sprintf("%010d%s", s.nextSeq, spoolSegmentSuffix)),
+		})
+		s.nextSeq++
+	}
+	segment := &s.segments[len(s.segments)-1]
+
+	f, err := os.OpenFile(segment.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	segment.size += int64(len(line))
+	s.size += int64(len(line))
+	metrics.SpoolBytes.Add(float64(len(line)))
+	return nil
+}
+
+// oldest reads the records of the oldest segment. Lines that cannot be
+// decoded, such as one cut short by a crash, are skipped.
+func (s *spool) oldest() ([]docBatch, error) {
+	f, err := os.Open(s.segments[0].path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []docBatch
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var record docBatch
+			if json.Unmarshal(line, &record) == nil {
+				records = append(records, record)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// removeOldest deletes the oldest segment. It is forgotten even if the file
+// cannot be deleted, so the spool never gets stuck on it.
+func (s *spool) removeOldest() error {
+	segment := s.segments[0]
+	s.segments = s.segments[1:]
+	s.size -= segment.size
+	metrics.SpoolBytes.Sub(float64(segment.size))
+	if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// replaceOldest rewrites the oldest segment with the records still to be
+// replayed.
+func (s *spool) replaceOldest(records []docBatch) error {
+	var content []byte
+	for _, record := range records {
+		line, err := encodeSpoolRecord(record)
+		if err != nil {
+			return err
+		}
+		content = append(content, line...)
+	}
+
+	segment := &s.segments[0]
+	tmp := segment.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, segment.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	delta := int64(len(content)) - segment.size
+	segment.size += delta
+	s.size += delta
+	metrics.SpoolBytes.Add(float64(delta))
+	return nil
+}
+
+// dropOldest deletes the oldest segment and returns the resource IDs that
+// were in it. A segment that cannot be read is deleted too, and the error
+// wraps errSegmentLost.
+func (s *spool) dropOldest() ([]string, error) {
+	path := s.segments[0].path
+	records, readErr := s.oldest()
+	removeErr := s.removeOldest()
+	if readErr != nil {
+		return nil, errors.Join(fmt.Errorf("%w %s: %w", errSegmentLost, path, readErr), removeErr)
+	}
+	var resourceIDs []string
+	for _, record := range records {
+		resourceIDs = append(resourceIDs, record.resourceIDs()...)
+	}
+	return resourceIDs, removeErr
+}
+
+// close removes the run's spool directory once it is empty.
+func (s *spool) close() {
+	if !s.pending() {
+		_ = os.Remove(s.dir)
+	}
+}
+
+// spoolBatch stores a batch for replay, applying the spool policy when the
+// spool is full. The block policy fails the batch once the spool stayed full
+// for the block timeout, or ctx is done.
+func (s *ResourceSender) spoolBatch(ctx context.Context, record docBatch) {
+	line, err := encodeSpoolRecord(record)
+	if err != nil {
+		s.logger.Error("failed to encode batch for the spool", zap.Error(err))
+		s.report.fail(record.resourceIDs()...)
+		return
+	}
+	if int64(len(line)) > s.spool.maxBytes {
+		s.logger.Error("batch is larger than the spool", zap.Int("bytes", len(line)))
+		s.report.fail(record.resourceIDs()...)
+		return
+	}
+
+	var deadline time.Time
+	for retry := 1; !s.spool.fits(int64(len(line))); retry++ {
+		switch s.spool.policy {
+		case SpoolPolicyBlock:
+			if retry == 1 {
+				s.logger.Warn("spool is full, waiting for it to be replayed", zap.Int64("bytes", s.spool.size))
+				deadline = time.Now().Add(s.spool.blockTimeout)
+			}
+			if s.replaySpool(ctx) {
+				continue
+			}
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				s.logger.Error("spool stayed full, failing batch", zap.Duration("blockTimeout", s.spool.blockTimeout),
+					zap.Int("resources", len(record.Docs)))
+				s.report.fail(record.resourceIDs()...)
+				return
+			}
+			if err := sleep(ctx, min(wait, s.backoff.delay(retry))); err != nil {
+				s.logger.Error("run cancelled while the spool was full, failing batch", zap.Int("resources", len(record.Docs)),
+					zap.Error(err))
+				s.report.fail(record.resourceIDs()...)
+				return
+			}
+		case SpoolPolicyDropOldest:
+			resourceIDs, err := s.spool.dropOldest()
+			s.logger.Warn("spool is full, dropped the oldest segment", zap.Int("resources", len(resourceIDs)))
+			s.report.drop(resourceIDs...)
+			s.segmentError(err)
+		default:
+			s.logger.Error("spool is full, failing batch", zap.Int("resources", len(record.Docs)))
+			s.report.fail(record.resourceIDs()...)
+			return
+		}
+	}
+
+	if err := s.spool.append(line); err != nil {
+		s.logger.Error("failed to write batch to the spool", zap.Error(err))
+		s.report.fail(record.resourceIDs()...)
+	}
+}
+
+// segmentError logs err from dropping or reading a spool segment, and
+// records the segment as lost if its results are unknown.
+func (s *ResourceSender) segmentError(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errSegmentLost):
+		s.logger.Error("lost a spool segment, its results were not reported", zap.Error(err))
+		s.report.loseSegment(err)
+	default:
+		s.logger.Error("failed to remove a spool segment", zap.Error(err))
+	}
+}
+
+// replaySpool sends spooled batches in order until the spool is empty, the
+// sink fails again or ctx is done, and reports whether the spool is empty.
+func (s *ResourceSender) replaySpool(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return !s.spool.pending()
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "results.replay_spool",
+		trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	ingestCtx := s.ingestContext(ctx)
+
+	for s.spool.pending() {
+		records, err := s.spool.oldest()
+		if err != nil {
+			// Retrying it would hold back every later segment.
+			_, err := s.spool.dropOldest()
+			s.segmentError(err)
+			continue
+		}
+		for i, record := range records {
+			if err := s.ingest(ingestCtx, record.Docs, 1); err != nil {
+				if isTransient(err) || ctx.Err() != nil {
+					span.RecordError(err)
+					if err := s.spool.replaceOldest(records[i:]); err != nil {
+						s.logger.Error("failed to update the spool", zap.Error(err))
+					}
+					return false
+				}
+				// The sink rejects the batch itself, replaying it again won't help.
+				s.logger.Error("spooled batch was rejected", zap.Error(err))
+				s.report.fail(record.resourceIDs()...)
+				continue
+			}
+			s.report.sent(len(record.Docs))
+		}
+		if err := s.spool.removeOldest(); err != nil {
+			s.logger.Error("failed to remove replayed spool segment", zap.Error(err))
+		}
+	}
+	return true
+}
+
+// drainSpool replays what is left when the run finishes. Batches still not
+// accepted after the retries, or when ctx is done, are reported as failed
+// and removed: the run is over either way, so nothing would replay them.
+// Only a worker that crashes leaves segments for the redelivered run.
+func (s *ResourceSender) drainSpool(ctx context.Context) {
+	defer s.spool.close()
+	for attempt := 1; !s.replaySpool(ctx); attempt++ {
+		if attempt < s.backoff.MaxAttempts {
+			err := sleep(ctx, s.backoff.delay(attempt))
+			if err == nil {
+				continue
+			}
+			s.logger.Warn("run cancelled, dropping spooled results", zap.Error(err))
+		}
+		for s.spool.pending() {
+			resourceIDs, err := s.spool.dropOldest()
+			s.report.fail(resourceIDs...)
+			s.segmentError(err)
+		}
+		return
+	}
+}
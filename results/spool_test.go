@@ -0,0 +1,409 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSpoolConfig(t *testing.T, policy string) config.SpoolConfig {
+	t.Helper()
+	return config.SpoolConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 1 << 10, Policy: policy, BlockTimeout: time.Second}
+}
+
+func testBatch(ids ...string) docBatch {
+	var batch docBatch
+	for _, id := range ids {
+		batch.Docs = append(batch.Docs, Document{ID: id, Index: "test_result", ResourceID: id, Body: []byte(`{"id":"` + id + `"}`)})
+	}
+	return batch
+}
+
+func appendBatch(t *testing.T, s *spool, batch docBatch) int {
+	t.Helper()
+	line, err := encodeSpoolRecord(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.append(line); err != nil {
+		t.Fatal(err)
+	}
+	return len(line)
+}
+
+// addLostSegment adds a segment to the spool directory that opens but cannot
+// be read, as it links to a directory.
+func addLostSegment(t *testing.T, dir string, seq int) {
+	t.Helper()
+	target := filepath.Join(t.TempDir(), "not-a-file")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, fmt.Sprintf("%010d%s", seq, spoolSegmentSuffix))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenSpoolInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.SpoolConfig
+	}{
+		{name: "unknown policy", cfg: config.SpoolConfig{Dir: t.TempDir(), MaxBytes: 1, SegmentBytes: 1, Policy: "spill"}},
+		{name: "no max bytes", cfg: config.SpoolConfig{Dir: t.TempDir(), SegmentBytes: 1, Policy: SpoolPolicyFail}},
+		{name: "no segment bytes", cfg: config.SpoolConfig{Dir: t.TempDir(), MaxBytes: 1, Policy: SpoolPolicyFail}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openSpool(tt.cfg, 1); err == nil {
+				t.Error("openSpool() succeeded")
+			}
+		})
+	}
+}
+
+func TestSpoolSegments(t *testing.T) {
+	cfg := testSpoolConfig(t, SpoolPolicyFail)
+	cfg.SegmentBytes = 100
+	s, err := openSpool(cfg, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	for _, id := range []string{"a", "b", "c"} {
+		total += appendBatch(t, s, testBatch(id, id+"2"))
+	}
+	if len(s.segments) != 3 || s.size != int64(total) {
+		t.Fatalf("%d segments of %d bytes, want 3 of %d", len(s.segments), s.size, total)
+	}
+
+	// A line cut short by a crash is skipped.
+	f, err := os.OpenFile(s.segments[2].path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"docs":[{"id":`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// A later attempt of the run picks up the segments in order.
+	s, err = openSpool(cfg, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for s.pending() {
+		records, err := s.oldest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			got = append(got, record.resourceIDs()...)
+		}
+		if err := s.removeOldest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"a", "a2", "b", "b2", "c", "c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if s.size != 0 {
+		t.Errorf("%d bytes left after removing every segment", s.size)
+	}
+	s.close()
+	if _, err := os.Stat(s.dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("empty spool directory was not removed: %v", err)
+	}
+}
+
+func TestSpoolDropOldest(t *testing.T) {
+	tests := []struct {
+		name     string
+		lost     bool
+		wantIDs  []string
+		wantLost bool
+	}{
+		{name: "readable segment", wantIDs: []string{"a", "b"}},
+		{name: "unreadable segment", lost: true, wantLost: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testSpoolConfig(t, SpoolPolicyDropOldest)
+			dir := filepath.Join(cfg.Dir, "run-1")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.lost {
+				addLostSegment(t, dir, 0)
+			}
+			s, err := openSpool(cfg, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.lost {
+				appendBatch(t, s, testBatch("a", "b"))
+			}
+
+			ids, err := s.dropOldest()
+			if got := errors.Is(err, errSegmentLost); got != tt.wantLost {
+				t.Fatalf("dropOldest() error = %v, want lost %v", err, tt.wantLost)
+			}
+			if !tt.wantLost && err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("dropped %v, want %v", ids, tt.wantIDs)
+			}
+			if s.pending() || s.size != 0 {
+				t.Errorf("segment is still tracked: %d segments, %d bytes", len(s.segments), s.size)
+			}
+			if left, err := os.ReadDir(s.dir); err != nil || len(left) > 0 {
+				t.Errorf("segment files left: %v, %v", left, err)
+			}
+		})
+	}
+}
+
+// newSpoolingSender returns a sender whose spool the test drives directly,
+// without a handler.
+func newSpoolingSender(sink Sink, s *spool) *ResourceSender {
+	return &ResourceSender{
+		logger:   zap.NewNop(),
+		sink:     sink,
+		spool:    s,
+		backoff:  fastBackoff,
+		traceCtx: context.Background(),
+		runCtx:   context.Background(),
+		sendCtx:  context.Background(),
+	}
+}
+
+func TestDrainSpool(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name       string
+		lost       bool
+		errs       []error
+		wantSent   int
+		cancelled  bool
+		wantFailed []string
+		wantLost   int
+	}{
+		{name: "replayed", wantSent: 4},
+		{name: "replayed after a retry", errs: []error{unavailable}, wantSent: 4},
+		{name: "sink stays down", errs: []error{unavailable, unavailable, unavailable}, wantFailed: []string{"a", "b", "c", "d"}},
+		{name: "rejected batch is not retried", errs: []error{status.Error(codes.InvalidArgument, "bad")}, wantSent: 2, wantFailed: []string{"a", "b"}},
+		{name: "unreadable segment", lost: true, wantSent: 4, wantLost: 1},
+		{name: "unreadable segment with the sink down", lost: true, errs: []error{unavailable, unavailable, unavailable}, wantFailed: []string{"a", "b", "c", "d"}, wantLost: 1},
+		{name: "run cancelled", cancelled: true, wantFailed: []string{"a", "b", "c", "d"}},
+		{name: "run cancelled with an unreadable segment", lost: true, cancelled: true, wantFailed: []string{"a", "b", "c", "d"}, wantLost: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testSpoolConfig(t, SpoolPolicyFail)
+			cfg.SegmentBytes = 1
+			if tt.lost {
+				dir := filepath.Join(cfg.Dir, "run-1")
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				addLostSegment(t, dir, 0)
+			}
+			s, err := openSpool(cfg, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			appendBatch(t, s, testBatch("a", "b"))
+			appendBatch(t, s, testBatch("c", "d"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancelled {
+				cancel()
+			}
+			defer cancel()
+			sink := &fakeSink{errs: tt.errs}
+			sender := newSpoolingSender(sink, s)
+			sender.drainSpool(ctx)
+			if tt.cancelled && sink.writes != 0 {
+				t.Errorf("%d writes after the run was cancelled", sink.writes)
+			}
+
+			report := sender.report.snapshot()
+			if report.Sent != tt.wantSent || !reflect.DeepEqual(report.FailedIDs, tt.wantFailed) || report.LostSegments != tt.wantLost {
+				t.Errorf("report = %+v, want %d sent, failed %v and %d lost", report, tt.wantSent, tt.wantFailed, tt.wantLost)
+			}
+			if lost := sender.report.lost(); (lost != nil) != (tt.wantLost > 0) || (lost != nil && !errors.Is(lost, errSegmentLost)) {
+				t.Errorf("lost() = %v", lost)
+			}
+			if err := report.Err(); (err != nil) != (len(tt.wantFailed) > 0 || tt.wantLost > 0) {
+				t.Errorf("Err() = %v", err)
+			}
+			if s.pending() {
+				t.Errorf("%d segments left after the drain", len(s.segments))
+			}
+			// Nothing replays the segments once the run ended.
+			if _, err := os.Stat(s.dir); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("spool directory left after the drain: %v", err)
+			}
+		})
+	}
+}
+
+func TestSpoolBatchWhenFull(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		lost        bool
+		wantDropped []string
+		wantFailed  []string
+		wantLost    int
+		wantSpooled []string
+	}{
+		{name: "fail", policy: SpoolPolicyFail, wantFailed: []string{"c", "d"}, wantSpooled: []string{"a", "b"}},
+		{name: "drop oldest", policy: SpoolPolicyDropOldest, wantDropped: []string{"a", "b"}, wantSpooled: []string{"c", "d"}},
+		{name: "drop oldest unreadable", policy: SpoolPolicyDropOldest, lost: true, wantLost: 1, wantSpooled: []string{"c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := encodeSpoolRecord(testBatch("a", "b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := testSpoolConfig(t, tt.policy)
+			cfg.MaxBytes = len(line) + len(line)/2
+			s, err := openSpool(cfg, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lost {
+				// Stands in for the first batch, with the same size.
+				addLostSegment(t, s.dir, 0)
+				s.segments = append(s.segments, spoolSegment{path: filepath.Join(s.dir, fmt.Sprintf("%010d%s", 0, spoolSegmentSuffix)), size: int64(len(line))})
+				s.size, s.nextSeq = int64(len(line)), 1
+			} else {
+				appendBatch(t, s, testBatch("a", "b"))
+			}
+
+			sender := newSpoolingSender(&fakeSink{}, s)
+			sender.spoolBatch(context.Background(), testBatch("c", "d"))
+
+			report := sender.report.snapshot()
+			if !reflect.DeepEqual(report.FailedIDs, append(tt.wantDropped, tt.wantFailed...)) ||
+				report.Dropped != len(tt.wantDropped) || report.Failed != len(tt.wantFailed) || report.LostSegments != tt.wantLost {
+				t.Errorf("report = %+v", report)
+			}
+			var spooled []string
+			for s.pending() {
+				records, err := s.oldest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, record := range records {
+					spooled = append(spooled, record.resourceIDs()...)
+				}
+				if err := s.removeOldest(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(spooled, tt.wantSpooled) {
+				t.Errorf("spooled %v, want %v", spooled, tt.wantSpooled)
+			}
+		})
+	}
+}
+
+func TestResourceSenderLostSegment(t *testing.T) {
+	cfg := testSpoolConfig(t, SpoolPolicyFail)
+	dir := filepath.Join(cfg.Dir, "run-1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	addLostSegment(t, dir, 0)
+
+	sink := &fakeSink{}
+	sender, err := sendAll(t, sink, []string{"a", "b"}, WithSenderSpool(cfg))
+
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) || !errors.Is(err, errSegmentLost) {
+		t.Fatalf("Finish() error = %v, want a delivery error for the lost segment", err)
+	}
+	if report := sender.Report(); report.Sent != 2 || report.LostSegments != 1 {
+		t.Errorf("report = %+v, want 2 sent and 1 lost segment", report)
+	}
+	if !deliveryErr.Partial() {
+		t.Error("Partial() = false with results delivered")
+	}
+}
+
+func TestSpoolBatchBlocks(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	down := []error{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable}
+	tests := []struct {
+		name         string
+		errs         []error
+		blockTimeout time.Duration
+		cancelled    bool
+		wantSent     int
+		wantFailed   []string
+		wantSpooled  []string
+	}{
+		{name: "replay frees space", blockTimeout: time.Hour, wantSent: 2, wantSpooled: []string{"c", "d"}},
+		{name: "spool stays full", errs: down, blockTimeout: 20 * time.Millisecond, wantFailed: []string{"c", "d"}, wantSpooled: []string{"a", "b"}},
+		{name: "run cancelled", errs: down, blockTimeout: time.Hour, cancelled: true, wantFailed: []string{"c", "d"}, wantSpooled: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := encodeSpoolRecord(testBatch("a", "b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := testSpoolConfig(t, SpoolPolicyBlock)
+			cfg.MaxBytes, cfg.BlockTimeout = len(line)+len(line)/2, tt.blockTimeout
+			s, err := openSpool(cfg, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			appendBatch(t, s, testBatch("a", "b"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+			sender := newSpoolingSender(&fakeSink{errs: tt.errs}, s)
+			sender.backoff.Initial, sender.backoff.Max = 5*time.Millisecond, 5*time.Millisecond
+
+			start := time.Now()
+			sender.spoolBatch(ctx, testBatch("c", "d"))
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Fatalf("spoolBatch() blocked for %s", elapsed)
+			}
+
+			report := sender.report.snapshot()
+			if report.Sent != tt.wantSent || !reflect.DeepEqual(report.FailedIDs, tt.wantFailed) {
+				t.Errorf("report = %+v, want %d sent and failed %v", report, tt.wantSent, tt.wantFailed)
+			}
+			var spooled []string
+			for s.pending() {
+				ids, err := s.dropOldest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				spooled = append(spooled, ids...)
+			}
+			if !reflect.DeepEqual(spooled, tt.wantSpooled) {
+				t.Errorf("spooled %v, want %v", spooled, tt.wantSpooled)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package results
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"os"
+	"sync"
+	"time"
+)
+
+// TransportCredentials builds the credentials for outbound gRPC connections.
+// Plaintext is only used when cfg.Insecure is set. The CA bundle and client
+// certificate are re-read whenever their files change, so rotated
+// certificates are picked up on the next handshake without a restart.
+func TransportCredentials(cfg config.TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("TLS client certificate and key must be set together")
+	}
+
+	reloader := &certReloader{
+		caFile:   cfg.CAFile,
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if err := reloader.reload(); err != nil {
+				return nil, err
+			}
+			return reloader.clientCert(), nil
+		}
+	}
+	if cfg.CAFile != "" {
+		// RootCAs cannot change after the config is in use, so the chain is
+		// verified against the current CA bundle in VerifyConnection instead.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if err := reloader.reload(); err != nil {
+				return err
+			}
+			return verifyChain(state, reloader.rootCAs())
+		}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func verifyChain(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       state.ServerName,
+	})
+	return err
+}
+
+// certReloader caches the CA bundle and client certificate and reloads them
+// when a file's modification time changes.
+type certReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	modTime map[string]time.Time
+	pool    *x509.CertPool
+	cert    *tls.Certificate
+}
+
+func (r *certReloader) rootCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+func (r *certReloader) clientCert() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// reload re-reads any file that changed. If a changed file cannot be loaded,
+// for example while a rotation is only half written, the previous
+// certificate stays in use and the file is read again on the next call.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.modTime == nil {
+		r.modTime = make(map[string]time.Time)
+	}
+
+	if r.caFile != "" {
+		if changed, err := r.changed(r.caFile); err != nil || changed || r.pool == nil {
+			pool, loadErr := loadCertPool(r.caFile)
+			if loadErr = errors.Join(err, loadErr); loadErr != nil {
+				delete(r.modTime, r.caFile)
+				if r.pool == nil {
+					return loadErr
+				}
+			} else {
+				r.pool = pool
+			}
+		}
+	}
+
+	if r.certFile != "" {
+		certChanged, certErr := r.changed(r.certFile)
+		keyChanged, keyErr := r.changed(r.keyFile)
+		if certErr != nil || keyErr != nil || certChanged || keyChanged || r.cert == nil {
+			cert, loadErr := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+			if loadErr = errors.Join(certErr, keyErr, loadErr); loadErr != nil {
+				delete(r.modTime, r.certFile)
+				delete(r.modTime, r.keyFile)
+				if r.cert == nil {
+					return loadErr
+				}
+			} else {
+				r.cert = &cert
+			}
+		}
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// changed reports whether path was modified since it was last seen. It must
+// be called with r.mu held.
+func (r *certReloader) changed(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(r.modTime[path]) {
+		return false, nil
+	}
+	r.modTime[path] = info.ModTime()
+	return true, nil
+}
@@ -0,0 +1,307 @@
+package results
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/opengovern/og-task-template/config"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by parent or self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// writeAt writes content to path and sets its modification time, so reloads
+// see a change even within the file system's timestamp resolution.
+func writeAt(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportCredentialsConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", true, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeAt(t, caFile, ca.certPEM(), time.Now())
+	notPEM := filepath.Join(dir, "not.pem")
+	writeAt(t, notPEM, []byte("not a certificate"), time.Now())
+
+	tests := []struct {
+		name         string
+		cfg          config.TLSConfig
+		wantErr      bool
+		wantSecurity string
+	}{
+		{name: "insecure", cfg: config.TLSConfig{Insecure: true}, wantSecurity: "insecure"},
+		{name: "system roots", cfg: config.TLSConfig{}, wantSecurity: "tls"},
+		{name: "private CA", cfg: config.TLSConfig{CAFile: caFile}, wantSecurity: "tls"},
+		{name: "missing CA file", cfg: config.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "CA file without certificates", cfg: config.TLSConfig{CAFile: notPEM}, wantErr: true},
+		{name: "cert without key", cfg: config.TLSConfig{CertFile: caFile}, wantErr: true},
+		{name: "unloadable key pair", cfg: config.TLSConfig{CertFile: caFile, KeyFile: notPEM}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := TransportCredentials(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TransportCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && creds.Info().SecurityProtocol != tt.wantSecurity {
+				t.Errorf("security protocol = %q, want %q", creds.Info().SecurityProtocol, tt.wantSecurity)
+			}
+		})
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	ca := newTestCert(t, "ca", true, nil)
+	intermediate := newTestCert(t, "intermediate", true, ca)
+	server := newTestCert(t, "sink.local", false, intermediate)
+	otherCA := newTestCert(t, "other-ca", true, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA.cert)
+
+	tests := []struct {
+		name    string
+		chain   []*x509.Certificate
+		server  string
+		roots   *x509.CertPool
+		wantErr bool
+	}{
+		{name: "valid chain", chain: []*x509.Certificate{server.cert, intermediate.cert}, server: "sink.local", roots: roots},
+		{name: "missing intermediate", chain: []*x509.Certificate{server.cert}, server: "sink.local", roots: roots, wantErr: true},
+		{name: "other CA", chain: []*x509.Certificate{server.cert, intermediate.cert}, server: "sink.local", roots: otherRoots, wantErr: true},
+		{name: "wrong name", chain: []*x509.Certificate{server.cert, intermediate.cert}, server: "other.local", roots: roots, wantErr: true},
+		{name: "no certificate", server: "sink.local", roots: roots, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChain(tls.ConnectionState{PeerCertificates: tt.chain, ServerName: tt.server}, tt.roots)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", true, nil)
+	first := newTestCert(t, "client-1", false, ca)
+	second := newTestCert(t, "client-2", false, ca)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	start := time.Now().Add(-time.Hour)
+	writeAt(t, caFile, ca.certPEM(), start)
+	writeAt(t, certFile, first.certPEM(), start)
+	writeAt(t, keyFile, first.keyPEM(t), start)
+
+	r := &certReloader{caFile: caFile, certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		write    func(modTime time.Time)
+		wantLeaf string
+	}{
+		{name: "unchanged", write: func(time.Time) {}, wantLeaf: "client-1"},
+		{
+			name: "rotated",
+			write: func(modTime time.Time) {
+				writeAt(t, certFile, second.certPEM(), modTime)
+				writeAt(t, keyFile, second.keyPEM(t), modTime)
+			},
+			wantLeaf: "client-2",
+		},
+		{
+			name:     "half written rotation keeps the old certificate",
+			write:    func(modTime time.Time) { writeAt(t, certFile, first.certPEM(), modTime) },
+			wantLeaf: "client-2",
+		},
+		{
+			name:     "rotation completes",
+			write:    func(modTime time.Time) { writeAt(t, keyFile, first.keyPEM(t), modTime) },
+			wantLeaf: "client-1",
+		},
+		{
+			name:     "file removed keeps the old certificate",
+			write:    func(time.Time) { _ = os.Remove(certFile) },
+			wantLeaf: "client-1",
+		},
+	}
+	for i, step := range steps {
+		step.write(start.Add(time.Duration(i+1) * time.Minute))
+		if err := r.reload(); err != nil {
+			t.Fatalf("%s: reload() error = %v", step.name, err)
+		}
+		leaf, err := x509.ParseCertificate(r.clientCert().Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.CommonName != step.wantLeaf {
+			t.Errorf("%s: client certificate is %s, want %s", step.name, leaf.Subject.CommonName, step.wantLeaf)
+		}
+	}
+	if r.rootCAs() == nil {
+		t.Error("CA pool was not loaded")
+	}
+}
+
+func TestTransportCredentialsHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", true, nil)
+	server := newTestCert(t, "sink.local", false, ca)
+	client := newTestCert(t, "worker", false, ca)
+	otherCA := newTestCert(t, "other-ca", true, nil)
+
+	caFile, otherCAFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "other-ca.pem")
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeAt(t, caFile, ca.certPEM(), time.Now())
+	writeAt(t, otherCAFile, otherCA.certPEM(), time.Now())
+	writeAt(t, certFile, client.certPEM(), time.Now())
+	writeAt(t, keyFile, client.keyPEM(t), time.Now())
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		NextProtos:   []string{"h2"},
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.TLSConfig
+		wantErr bool
+	}{
+		{name: "mTLS", cfg: config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "sink.local"}},
+		{name: "untrusted server", cfg: config.TLSConfig{CAFile: otherCAFile, CertFile: certFile, KeyFile: keyFile, ServerName: "sink.local"}, wantErr: true},
+		{name: "no client certificate", cfg: config.TLSConfig{CAFile: caFile, ServerName: "sink.local"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := TransportCredentials(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			serverErr := make(chan error, 1)
+			go func() {
+				serverConn, err := listener.Accept()
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				defer serverConn.Close()
+				conn := tls.Server(serverConn, serverConfig)
+				err = conn.Handshake()
+				if err == nil {
+					// TLS 1.3 reports a rejected client certificate on the
+					// first read.
+					_, err = conn.Read(make([]byte, 1))
+				}
+				serverErr <- err
+			}()
+
+			clientConn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer clientConn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, err := creds.ClientHandshake(ctx, "sink.local:443", clientConn)
+			if err == nil {
+				_, err = conn.Write([]byte{0})
+			}
+			if err == nil {
+				err = <-serverErr
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package task
+
+import (
+	"fmt"
+	"github.com/opengovern/og-util/pkg/jq"
+	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"sort"
+	"sync"
+)
+
+// Handler runs one task type. It has the same signature as RunTask.
+type Handler func(ctx context.Context, jq *jq.JobQueue, coreServiceEndpoint string, esClient opengovernance.Client, logger *zap.Logger, request tasks.TaskRequest, response *scheduler.TaskResponse) error
+
+// UnknownTaskTypeError is returned when a request names a task type that has
+// no registered handler.
+type UnknownTaskTypeError struct {
+	TaskType   string
+	Registered []string
+}
+
+func (e *UnknownTaskTypeError) Error() string {
+	return fmt.Sprintf("unknown task type %q, this worker handles %v", e.TaskType, e.Registered)
+}
+
+// Registry maps task types to the handlers that run them, so several tasks
+// can ship in one image.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// DefaultRegistry is the registry the worker dispatches on. Tasks add
+// themselves to it with Register, usually from an init function.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register adds a handler for taskType. Registering the same type twice is an
+// error.
+func (r *Registry) Register(taskType string, handler Handler) error {
+	if taskType == "" {
+		return fmt.Errorf("task type must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler for task type %q must not be nil", taskType)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[taskType]; ok {
+		return fmt.Errorf("task type %q is already registered", taskType)
+	}
+	r.handlers[taskType] = handler
+	return nil
+}
+
+// MustRegister is like Register but panics on error.
+func (r *Registry) MustRegister(taskType string, handler Handler) {
+	if err := r.Register(taskType, handler); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the handler registered for taskType.
+func (r *Registry) Lookup(taskType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[taskType]
+	return handler, ok
+}
+
+// TaskTypes returns the registered task types in sorted order.
+func (r *Registry) TaskTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	taskTypes := make([]string, 0, len(r.handlers))
+	for taskType := range r.handlers {
+		taskTypes = append(taskTypes, taskType)
+	}
+	sort.Strings(taskTypes)
+	return taskTypes
+}
+
+// Run dispatches request to the handler registered for its task type.
+func (r *Registry) Run(ctx context.Context, jq *jq.JobQueue, coreServiceEndpoint string, esClient opengovernance.Client, logger *zap.Logger, request tasks.TaskRequest, response *scheduler.TaskResponse) error {
+	taskType := request.TaskDefinition.TaskType
+	handler, ok := r.Lookup(taskType)
+	if !ok {
+		return &UnknownTaskTypeError{TaskType: taskType, Registered: r.TaskTypes()}
+	}
+	logger.Info("Dispatching task", zap.String("taskType", taskType))
+	return handler(ctx, jq, coreServiceEndpoint, esClient, logger, request, response)
+}
+
+// Register adds a handler to DefaultRegistry, panicking on a duplicate type.
+func Register(taskType string, handler Handler) {
+	DefaultRegistry.MustRegister(taskType, handler)
+}
@@ -0,0 +1,106 @@
+package task
+
+import (
+	"errors"
+	"github.com/opengovern/og-util/pkg/jq"
+	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+)
+
+func noopHandler(context.Context, *jq.JobQueue, string, opengovernance.Client, *zap.Logger, tasks.TaskRequest, *scheduler.TaskResponse) error {
+	return nil
+}
+
+func TestRegistryRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		taskType string
+		handler  Handler
+		wantErr  bool
+	}{
+		{name: "new type", taskType: "b", handler: noopHandler},
+		{name: "duplicate type", taskType: "a", handler: noopHandler, wantErr: true},
+		{name: "empty type", taskType: "", handler: noopHandler, wantErr: true},
+		{name: "nil handler", taskType: "c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.MustRegister("a", noopHandler)
+
+			err := r.Register(tt.taskType, tt.handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Register(%q) error = %v, wantErr %v", tt.taskType, err, tt.wantErr)
+			}
+			_, ok := r.Lookup(tt.taskType)
+			if want := !tt.wantErr || tt.taskType == "a"; ok != want {
+				t.Errorf("Lookup(%q) found = %v, want %v", tt.taskType, ok, want)
+			}
+		})
+	}
+}
+
+func TestRegistryMustRegisterPanicsOnDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister("a", noopHandler)
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRegister did not panic on a duplicate task type")
+		}
+	}()
+	r.MustRegister("a", noopHandler)
+}
+
+func TestRegistryRun(t *testing.T) {
+	boom := errors.New("boom")
+	r := NewRegistry()
+	var ran []string
+	for _, taskType := range []string{"zeta", "alpha"} {
+		taskType := taskType
+		r.MustRegister(taskType, func(_ context.Context, _ *jq.JobQueue, _ string, _ opengovernance.Client, _ *zap.Logger, request tasks.TaskRequest, _ *scheduler.TaskResponse) error {
+			ran = append(ran, taskType)
+			if request.TaskDefinition.RunID == 2 {
+				return boom
+			}
+			return nil
+		})
+	}
+
+	tests := []struct {
+		name     string
+		taskType string
+		runID    uint
+		wantRan  []string
+		wantErr  error
+	}{
+		{name: "dispatches by type", taskType: "alpha", runID: 1, wantRan: []string{"alpha"}},
+		{name: "returns the handler error", taskType: "zeta", runID: 2, wantRan: []string{"zeta"}, wantErr: boom},
+		{name: "unknown type", taskType: "missing", runID: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			request := tasks.TaskRequest{TaskDefinition: tasks.TaskDefinition{RunID: tt.runID, TaskType: tt.taskType}}
+
+			err := r.Run(context.Background(), nil, "", nil, zap.NewNop(), request, &scheduler.TaskResponse{})
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", ran, tt.wantRan)
+			}
+			if tt.taskType == "missing" {
+				var unknown *UnknownTaskTypeError
+				if !errors.As(err, &unknown) || !reflect.DeepEqual(unknown.Registered, []string{"alpha", "zeta"}) {
+					t.Errorf("Run() error = %v, want an UnknownTaskTypeError listing [alpha zeta]", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
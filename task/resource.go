@@ -1,9 +1,68 @@
 package task
 
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opengovern/og-task-template/task/sbom"
+	"time"
+)
+
+// Result types the sbom task stores its resources under.
+const (
+	ArtifactSbomResourceType         = "ArtifactSbom"
+	ArtifactSbomDocumentResourceType = "ArtifactSbomDocument"
+)
+
+// ArtifactSbom lists the packages of an image. Its fields are the
+// image_url, artifact_id and packages columns of the cloudql table.
 type ArtifactSbom struct {
-	ID string
+	ImageURL   string
+	ArtifactID string
+	Packages   []sbom.Package
 }
 
 func (r ArtifactSbom) UniqueID() string {
-	return r.ID
+	return r.ArtifactID
+}
+
+func (r ArtifactSbom) ResourceType() string {
+	return ArtifactSbomResourceType
+}
+
+// ArtifactSbomDocument is the SBOM of an image in one of the sbom.Formats.
+// Documents are results of their own, so an ArtifactSbom stays small
+// whatever the size of its documents.
+type ArtifactSbomDocument struct {
+	ImageURL   string
+	ArtifactID string
+	// Format is the name of the document's sbom.Formats format.
+	Format   string
+	Document json.RawMessage
+}
+
+func (r ArtifactSbomDocument) UniqueID() string {
+	return r.ArtifactID + "/" + r.Format
+}
+
+func (r ArtifactSbomDocument) ResourceType() string {
+	return ArtifactSbomDocumentResourceType
+}
+
+// artifactSbomDocuments exports artifact, cataloged at scannedAt, in every
+// sbom.Formats format.
+func artifactSbomDocuments(artifact sbom.Artifact, scannedAt time.Time) ([]ArtifactSbomDocument, error) {
+	documents := make([]ArtifactSbomDocument, 0, len(sbom.Formats))
+	for _, format := range sbom.Formats {
+		document, err := format.Encode(artifact, scannedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s SBOM of %s: %w", format.Name, artifact.ArtifactID, err)
+		}
+		documents = append(documents, ArtifactSbomDocument{
+			ImageURL:   artifact.ImageURL,
+			ArtifactID: artifact.ArtifactID,
+			Format:     format.Name,
+			Document:   document,
+		})
+	}
+	return documents, nil
 }
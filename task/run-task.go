@@ -9,6 +9,13 @@ import (
 	"golang.org/x/net/context"
 )
 
+// TaskType is the task type RunTask is registered under.
+const TaskType = "og-task-template"
+
+func init() {
+	Register(TaskType, RunTask)
+}
+
 func RunTask(ctx context.Context, jq *jq.JobQueue, coreServiceEndpoint string, esClient opengovernance.Client, logger *zap.Logger, request tasks.TaskRequest, response *scheduler.TaskResponse) error {
 
 	return nil
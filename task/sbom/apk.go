@@ -0,0 +1,76 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+const apkDatabase = "lib/apk/db/installed"
+
+// catalogApk reads the Alpine package database, a list of blank line
+// separated records of one-letter fields.
+func catalogApk(t *tree, distro Distro) ([]Package, error) {
+	content, ok := t.files[apkDatabase]
+	if !ok {
+		return nil, nil
+	}
+	namespace := distro.ID
+	if namespace == "" {
+		namespace = "alpine"
+	}
+
+	var packages []Package
+	var current Package
+	flush := func() {
+		if current.Name != "" {
+			current.Type = TypeApk
+			current.Locations = []string{"/" + apkDatabase}
+			current.PURL = purl(TypeApk, namespace, current.Name, current.Version, map[string]string{
+				"arch":   current.Arch,
+				"distro": distro.qualifier(),
+			})
+			packages = append(packages, current)
+		}
+		current = Package{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			current.Name = value
+		case "V":
+			current.Version = value
+		case "A":
+			current.Arch = value
+		case "L":
+			current.Licenses = splitLicenses(value)
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
+
+// splitLicenses splits an apk license field, which lists several licenses
+// separated by spaces unless it is an SPDX expression.
+func splitLicenses(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if strings.Contains(value, " AND ") || strings.Contains(value, " OR ") || strings.Contains(value, " WITH ") {
+		return []string{value}
+	}
+	return strings.Fields(value)
+}
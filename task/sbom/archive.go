@@ -0,0 +1,250 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxLinkHops bounds how many symbolic links are followed to open a name.
+const maxLinkHops = 40
+
+// archiveFS is a tarball read in place as an fs.FS. Nothing is written out,
+// so entries cannot reach outside the archive whatever their names and
+// links: a link is resolved within the archive and a name that climbs above
+// its root does not exist.
+type archiveFS struct {
+	f       *os.File
+	temp    bool
+	entries map[string]archiveEntry
+}
+
+type archiveEntry struct {
+	offset  int64
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	// link is the target of a symbolic link, or the name a hard link
+	// shares its content with.
+	link     string
+	hardLink bool
+}
+
+// openArchive indexes the tarball at name. A compressed tarball is first
+// decompressed to a temporary file, since entries are read at their offset.
+func openArchive(ctx context.Context, name string) (*archiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveFS{f: f, entries: make(map[string]archiveEntry)}
+	if err := a.decompress(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.index(ctx); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archiveFS) decompress() error {
+	magic := make([]byte, 4)
+	n, err := a.f.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if !bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}) && !bytes.HasPrefix(magic[:n], []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return nil
+	}
+	r, err := decompress(a.f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	temp, err := os.CreateTemp("", "sbom-archive-")
+	if err != nil {
+		return err
+	}
+	source := a.f
+	defer source.Close()
+	a.f, a.temp = temp, true
+	if _, err := io.Copy(temp, r); err != nil {
+		return err
+	}
+	_, err = temp.Seek(0, io.SeekStart)
+	return err
+}
+
+// index records where the content of every entry starts. archive/tar leaves
+// the underlying reader at the start of an entry's content once Next returns.
+func (a *archiveFS) index(ctx context.Context) error {
+	counter := &countingReader{r: a.f}
+	tr := tar.NewReader(counter)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		entry := archiveEntry{mode: hdr.FileInfo().Mode(), modTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			entry.offset, entry.size = counter.n, hdr.Size
+		case tar.TypeSymlink:
+			entry.link = hdr.Linkname
+		case tar.TypeLink:
+			entry.link, entry.hardLink = cleanName(hdr.Linkname), true
+		case tar.TypeDir:
+		default:
+			continue
+		}
+		a.entries[name] = entry
+		// Archives need not list the directories their entries are in.
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := a.entries[dir]; ok {
+				break
+			}
+			a.entries[dir] = archiveEntry{mode: fs.ModeDir | 0o755}
+		}
+	}
+}
+
+// Close closes the archive and removes its decompressed copy.
+func (a *archiveFS) Close() error {
+	err := a.f.Close()
+	if a.temp {
+		err = errors.Join(err, os.Remove(a.f.Name()))
+	}
+	return err
+}
+
+// Open opens the regular file name, following symbolic links within the
+// archive.
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, entry, err := a.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	return &archiveFile{
+		SectionReader: io.NewSectionReader(a.f, entry.offset, entry.size),
+		name:          path.Base(resolved),
+		entry:         entry,
+	}, nil
+}
+
+// resolve follows the symbolic links in every element of name and returns
+// the entry it ends at.
+func (a *archiveFS) resolve(name string) (string, archiveEntry, error) {
+	resolved, rest := "", name
+	for hops := 0; ; {
+		var element string
+		element, rest, _ = strings.Cut(rest, "/")
+		current := path.Join(resolved, element)
+		entry, ok := a.entries[current]
+		for ok && entry.hardLink {
+			if hops++; hops > maxLinkHops {
+				return "", archiveEntry{}, errors.New("too many links")
+			}
+			current = entry.link
+			entry, ok = a.entries[current]
+		}
+		if !ok {
+			return "", archiveEntry{}, fs.ErrNotExist
+		}
+
+		if entry.link != "" {
+			if hops++; hops > maxLinkHops {
+				return "", archiveEntry{}, errors.New("too many links")
+			}
+			target := path.Join(path.Dir(current), entry.link)
+			if path.IsAbs(entry.link) {
+				target = path.Clean(entry.link)
+			}
+			target = strings.TrimPrefix(target, "/")
+			if target == ".." || strings.HasPrefix(target, "../") {
+				return "", archiveEntry{}, fs.ErrNotExist
+			}
+			if target == "." {
+				target = ""
+			}
+			// Resolve the target from the root, then what is left of name.
+			resolved, rest = "", path.Join(target, rest)
+			if rest == "" {
+				return "", archiveEntry{}, fs.ErrNotExist
+			}
+			continue
+		}
+
+		resolved = current
+		if rest == "" {
+			return resolved, entry, nil
+		}
+		if !entry.mode.IsDir() {
+			return "", archiveEntry{}, fs.ErrNotExist
+		}
+	}
+}
+
+type archiveFile struct {
+	*io.SectionReader
+	name  string
+	entry archiveEntry
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return archiveFileInfo{f}, nil }
+func (f *archiveFile) Close() error               { return nil }
+
+type archiveFileInfo struct{ f *archiveFile }
+
+func (i archiveFileInfo) Name() string       { return i.f.name }
+func (i archiveFileInfo) Size() int64        { return i.f.entry.size }
+func (i archiveFileInfo) Mode() fs.FileMode  { return i.f.entry.mode }
+func (i archiveFileInfo) ModTime() time.Time { return i.f.entry.modTime }
+func (i archiveFileInfo) IsDir() bool        { return false }
+func (i archiveFileInfo) Sys() any           { return nil }
+
+// countingReader tracks the offset of the file read through it.
+type countingReader struct {
+	r io.ReadSeeker
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Seek lets archive/tar skip over content instead of reading through it.
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := c.r.(io.Seeker).Seek(offset, whence)
+	if err == nil {
+		c.n = n
+	}
+	return n, err
+}
@@ -0,0 +1,121 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveFS(t *testing.T) {
+	entries := []tar.Header{
+		{Name: "manifest.json", Typeflag: tar.TypeReg, Size: 2},
+		// docker save links layers shared by several images.
+		{Name: "aaa/layer.tar", Typeflag: tar.TypeReg, Size: 5},
+		{Name: "bbb/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../aaa/layer.tar"},
+		{Name: "ccc", Typeflag: tar.TypeSymlink, Linkname: "aaa"},
+		{Name: "hard.tar", Typeflag: tar.TypeLink, Linkname: "aaa/layer.tar"},
+		// Each link stays inside on its own, but together they climb out.
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "a/b/c", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "a/b/c/x", Typeflag: tar.TypeReg, Size: 4},
+		{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../../../etc/passwd"},
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "../evil", Typeflag: tar.TypeReg, Size: 4},
+		{Name: "/rooted", Typeflag: tar.TypeReg, Size: 4},
+		{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"},
+	}
+	content := map[string]string{"manifest.json": "[]", "aaa/layer.tar": "layer", "a/b/c/x": "evil", "../evil": "evil", "/rooted": "root"}
+
+	var plain bytes.Buffer
+	tw := tar.NewWriter(&plain)
+	for _, hdr := range entries {
+		hdr.Mode = 0o644
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content[hdr.Name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(plain.Bytes())
+	zw.Close()
+
+	opens := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{name: "manifest.json", want: "[]"},
+		{name: "bbb/layer.tar", want: "layer"},
+		{name: "ccc/layer.tar", want: "layer"},
+		{name: "hard.tar", want: "layer"},
+		// a/b is the root, so a/b/c/x is x, which is not in the archive.
+		{name: "a/b/c/x", wantErr: fs.ErrNotExist},
+		{name: "up", wantErr: fs.ErrNotExist},
+		{name: "abs", wantErr: fs.ErrNotExist},
+		{name: "evil", want: "evil"},
+		{name: "rooted", want: "root"},
+		{name: "../evil", wantErr: fs.ErrInvalid},
+		{name: "aaa", wantErr: errors.New("is a directory")},
+		{name: "loop", wantErr: errors.New("too many links")},
+	}
+	archives := []struct {
+		name string
+		data []byte
+	}{
+		{name: "tar", data: plain.Bytes()},
+		{name: "tar.gz", data: compressed.Bytes()},
+	}
+	for _, archive := range archives {
+		t.Run(archive.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+			dir := t.TempDir()
+			name := filepath.Join(dir, "image.tar")
+			if err := os.WriteFile(name, archive.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys, err := openArchive(context.Background(), name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range opens {
+				got, err := fs.ReadFile(fsys, tt.name)
+				switch {
+				case tt.wantErr == nil && err != nil:
+					t.Errorf("ReadFile(%s) = %v", tt.name, err)
+				case tt.wantErr == nil && string(got) != tt.want:
+					t.Errorf("ReadFile(%s) = %q, want %q", tt.name, got, tt.want)
+				case tt.wantErr != nil && err == nil:
+					t.Errorf("ReadFile(%s) = %q, want error %v", tt.name, got, tt.wantErr)
+				case tt.wantErr != nil && !errors.Is(err, tt.wantErr) && err.(*fs.PathError).Err.Error() != tt.wantErr.Error():
+					t.Errorf("ReadFile(%s) error = %v, want %v", tt.name, err, tt.wantErr)
+				}
+			}
+			if err := fsys.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Nothing may be written next to the archive nor left behind.
+			if left, err := os.ReadDir(dir); err != nil || len(left) != 1 {
+				t.Errorf("files next to the archive: %v, %v", left, err)
+			}
+			if left, err := os.ReadDir(tmp); err != nil || len(left) > 0 {
+				t.Errorf("temporary files left: %v, %v", left, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package sbom
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// CycloneDX 1.5 JSON, from https://cyclonedx.org/docs/1.5/json/.
+const (
+	cycloneDXSchema      = "http://cyclonedx.org/schema/bom-1.5.schema.json"
+	cycloneDXSpecVersion = "1.5"
+
+	// cycloneDXPropertyPrefix namespaces the properties added to components.
+	cycloneDXPropertyPrefix = "opengovern:"
+)
+
+type cycloneDXBOM struct {
+	Schema       string                `json:"$schema"`
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDXMetadata     `json:"metadata"`
+	Components   []cycloneDXComponent  `json:"components"`
+	Dependencies []cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Hashes     []cycloneDXHash     `json:"hashes,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// cycloneDXLicense names a license. Package databases do not hold SPDX
+// license IDs, so licenses are never given as an ID.
+type cycloneDXLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// CycloneDX returns the CycloneDX 1.5 JSON BOM of a. The image is the
+// metadata component and every package depends on it directly, since
+// package databases do not record what depends on what.
+func CycloneDX(a Artifact, created time.Time) ([]byte, error) {
+	root := cycloneDXComponent{
+		BOMRef: "image",
+		Type:   "container",
+		Name:   documentName(a),
+	}
+	if digest, ok := sha256Digest(a.ArtifactID); ok {
+		root.Hashes = []cycloneDXHash{{Alg: "SHA-256", Content: digest}}
+	}
+	if a.ArtifactID != root.Name {
+		root.Version = a.ArtifactID
+	}
+
+	bom := cycloneDXBOM{
+		Schema:       cycloneDXSchema,
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cycloneDXSpecVersion,
+		SerialNumber: documentID("cyclonedx", a, created).URN(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: timestamp(created),
+			Tools: cycloneDXTools{
+				Components: []cycloneDXComponent{{Type: "application", Name: ToolName}},
+			},
+			Component: root,
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	var refs []string
+	if a.Distro.ID != "" {
+		system := cycloneDXComponent{
+			BOMRef:  "os",
+			Type:    "operating-system",
+			Name:    a.Distro.ID,
+			Version: a.Distro.VersionID,
+		}
+		if a.Distro.Name != "" {
+			system.Properties = []cycloneDXProperty{{Name: cycloneDXPropertyPrefix + "distro:name", Value: a.Distro.Name}}
+		}
+		bom.Components = append(bom.Components, system)
+		refs = append(refs, system.BOMRef)
+	}
+
+	seen := map[string]bool{}
+	for i, p := range a.Packages {
+		// A bom-ref must be unique; two packages only share a package URL
+		// when it leaves out what tells them apart.
+		ref := p.PURL
+		if ref == "" || seen[ref] {
+			ref = "package-" + strconv.Itoa(i)
+		}
+		seen[ref] = true
+
+		component := cycloneDXComponent{
+			BOMRef:  ref,
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL,
+			Properties: []cycloneDXProperty{
+				{Name: cycloneDXPropertyPrefix + "package:type", Value: p.Type},
+			},
+		}
+		for _, license := range p.Licenses {
+			var l cycloneDXLicense
+			l.License.Name = license
+			component.Licenses = append(component.Licenses, l)
+		}
+		for _, location := range p.Locations {
+			component.Properties = append(component.Properties, cycloneDXProperty{
+				Name:  cycloneDXPropertyPrefix + "package:location",
+				Value: location,
+			})
+		}
+		bom.Components = append(bom.Components, component)
+		refs = append(refs, ref)
+	}
+
+	bom.Dependencies = []cycloneDXDependency{{Ref: root.BOMRef, DependsOn: refs}}
+	for _, ref := range refs {
+		bom.Dependencies = append(bom.Dependencies, cycloneDXDependency{Ref: ref})
+	}
+	return json.Marshal(bom)
+}
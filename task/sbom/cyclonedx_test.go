@@ -0,0 +1,95 @@
+package sbom
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCycloneDX(t *testing.T) {
+	tests := []struct {
+		name         string
+		artifact     Artifact
+		wantRoot     cycloneDXComponent
+		wantRefs     []string
+		wantLicenses map[string][]string
+	}{
+		{
+			name:     "empty root filesystem",
+			artifact: testArtifacts[0].artifact,
+			wantRoot: cycloneDXComponent{BOMRef: "image", Type: "container", Name: "/srv/rootfs"},
+		},
+		{
+			name:     "unnamed image",
+			artifact: testArtifacts[1].artifact,
+			wantRoot: cycloneDXComponent{
+				BOMRef: "image", Type: "container", Name: testDigest,
+				Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: testDigest[len("sha256:"):]}},
+			},
+		},
+		{
+			name:     "image",
+			artifact: testArtifacts[2].artifact,
+			wantRoot: cycloneDXComponent{
+				BOMRef: "image", Type: "container", Name: "registry.local/team/app:1.0", Version: testDigest,
+				Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: testDigest[len("sha256:"):]}},
+			},
+			wantRefs: []string{
+				"os",
+				"pkg:deb/debian/libc6@2.36-9%2Bdeb12u4?arch=amd64&distro=debian-12",
+				"pkg:deb/debian/zlib1g@1:1.2.13.dfsg-1?distro=debian-12",
+				"pkg:golang/golang.org/x/net@v0.38.0",
+				"pkg:golang/stdlib@1.23.3",
+				"package-4",
+				"package-5",
+			},
+			wantLicenses: map[string][]string{
+				"libc6":  {"GPL-2.0-or-later", "LGPL-2.1"},
+				"zlib1g": {"Zlib and other (see copyright)"},
+				"local":  {"LGPL-2.1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := CycloneDX(tt.artifact, testCreated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var bom cycloneDXBOM
+			if err := json.Unmarshal(document, &bom); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bom.Metadata.Component, tt.wantRoot) {
+				t.Errorf("metadata component = %+v, want %+v", bom.Metadata.Component, tt.wantRoot)
+			}
+
+			var refs []string
+			licenses := map[string][]string{}
+			for _, component := range bom.Components {
+				refs = append(refs, component.BOMRef)
+				for _, license := range component.Licenses {
+					licenses[component.Name] = append(licenses[component.Name], license.License.Name)
+				}
+			}
+			if !reflect.DeepEqual(refs, tt.wantRefs) {
+				t.Errorf("bom-refs = %q, want %q", refs, tt.wantRefs)
+			}
+			if len(licenses) > 0 || tt.wantLicenses != nil {
+				if !reflect.DeepEqual(licenses, tt.wantLicenses) {
+					t.Errorf("licenses = %v, want %v", licenses, tt.wantLicenses)
+				}
+			}
+
+			// The image depends on every component, which have no
+			// dependencies of their own.
+			wantDependencies := []cycloneDXDependency{{Ref: "image", DependsOn: tt.wantRefs}}
+			for _, ref := range tt.wantRefs {
+				wantDependencies = append(wantDependencies, cycloneDXDependency{Ref: ref})
+			}
+			if !reflect.DeepEqual(bom.Dependencies, wantDependencies) {
+				t.Errorf("dependencies = %+v, want %+v", bom.Dependencies, wantDependencies)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Distro identifies the operating system of an image from its os-release.
+type Distro struct {
+	ID        string `json:"id,omitempty"`
+	VersionID string `json:"version_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// qualifier is the distro package URL qualifier, like debian-12.
+func (d Distro) qualifier() string {
+	if d.ID == "" || d.VersionID == "" {
+		return d.ID
+	}
+	return d.ID + "-" + d.VersionID
+}
+
+func readDistro(t *tree) Distro {
+	content, ok := t.files["etc/os-release"]
+	if !ok {
+		content = t.files["usr/lib/os-release"]
+	}
+
+	var distro Distro
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		switch key {
+		case "ID":
+			distro.ID = value
+		case "VERSION_ID":
+			distro.VersionID = value
+		case "PRETTY_NAME":
+			distro.Name = value
+		}
+	}
+	return distro
+}
@@ -0,0 +1,90 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strings"
+)
+
+const dpkgDatabase = "var/lib/dpkg/status"
+
+// catalogDpkg reads the dpkg status file and the per-package status files of
+// distroless images.
+func catalogDpkg(t *tree, distro Distro) ([]Package, error) {
+	var names []string
+	for name := range t.files {
+		if name == dpkgDatabase || strings.HasPrefix(name, dpkgStatusDir) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	namespace := distro.ID
+	if namespace == "" {
+		namespace = "debian"
+	}
+	var packages []Package
+	for _, name := range names {
+		found, err := parseDpkgStatus(t.files[name])
+		if err != nil {
+			return packages, err
+		}
+		for _, p := range found {
+			p.Type = TypeDeb
+			p.Locations = []string{"/" + name}
+			p.PURL = purl(TypeDeb, namespace, p.Name, p.Version, map[string]string{
+				"arch":   p.Arch,
+				"distro": distro.qualifier(),
+			})
+			packages = append(packages, p)
+		}
+	}
+	return packages, nil
+}
+
+// parseDpkgStatus returns the installed packages of a status file, whose
+// paragraphs of "Field: value" lines are separated by blank lines.
+func parseDpkgStatus(content []byte) ([]Package, error) {
+	var packages []Package
+	var current Package
+	installed := true
+	flush := func() {
+		if current.Name != "" && installed {
+			packages = append(packages, current)
+		}
+		current, installed = Package{}, true
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Arch = value
+		case "Status":
+			// Files of distroless images have no status.
+			fields := strings.Fields(value)
+			installed = len(fields) == 3 && fields[2] == "installed"
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
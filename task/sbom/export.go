@@ -0,0 +1,60 @@
+package sbom
+
+import (
+	"encoding/hex"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// ToolName is the tool SBOM documents are credited to.
+const ToolName = "og-task-template"
+
+// Format is a standard SBOM document format an Artifact can be exported as.
+type Format struct {
+	// Name identifies the format, like the Format of an ArtifactSbomDocument.
+	Name string
+	// Extension is the file name extension documents are written with.
+	Extension string
+	// Encode returns the JSON document of an artifact cataloged at created.
+	Encode func(a Artifact, created time.Time) ([]byte, error)
+}
+
+// Formats are the formats every ArtifactSbom is exported as.
+var Formats = []Format{
+	{Name: "cyclonedx", Extension: ".cdx.json", Encode: CycloneDX},
+	{Name: "spdx", Extension: ".spdx.json", Encode: SPDX},
+}
+
+// documentID derives the ID of a document from what it describes, so
+// exporting the same scan twice gives the same document.
+func documentID(format string, a Artifact, created time.Time) uuid.UUID {
+	name := strings.Join([]string{format, a.ImageURL, a.ArtifactID, created.UTC().Format(time.RFC3339)}, "\x00")
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name))
+}
+
+// sha256Digest returns the hex of an artifact ID that is a sha256 digest.
+func sha256Digest(artifactID string) (string, bool) {
+	digest, ok := strings.CutPrefix(artifactID, "sha256:")
+	if !ok || len(digest) != 64 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", false
+	}
+	return digest, true
+}
+
+// timestamp formats t as both formats require, in UTC without fractions.
+func timestamp(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// documentName is what a document calls the image: its URL, or its ID when
+// it was saved without a name.
+func documentName(a Artifact) string {
+	if a.ImageURL != "" {
+		return a.ImageURL
+	}
+	return a.ArtifactID
+}
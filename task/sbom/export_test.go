@@ -0,0 +1,168 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Schema IDs of the documents the exporters write, as in testdata/schema.
+const (
+	cycloneDXSchemaID = "http://cyclonedx.org/schema/bom-1.5.schema.json"
+	spdxSchemaID      = "http://spdx.org/rdf/terms/2.3"
+)
+
+// compileSchema compiles the schema with id from testdata/schema, where
+// every file is registered under its $id so schemas can reference each
+// other.
+func compileSchema(t *testing.T, id string) *jsonschema.Schema {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "schema", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema struct {
+			ID string `json:"$id"`
+		}
+		if err := json.Unmarshal(content, &schema); err != nil || schema.ID == "" {
+			t.Fatalf("%s has no $id: %v", file, err)
+		}
+		if err := compiler.AddResource(schema.ID, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schema, err := compiler.Compile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// validateDocument validates the JSON document against schema.
+func validateDocument(t *testing.T, schema *jsonschema.Schema, document []byte) error {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return schema.Validate(v)
+}
+
+var testCreated = time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("CET", 3600))
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// testArtifacts cover what the exporters treat differently.
+var testArtifacts = []struct {
+	name     string
+	artifact Artifact
+}{
+	{name: "empty root filesystem", artifact: Artifact{ImageURL: "/srv/rootfs", ArtifactID: "/srv/rootfs"}},
+	{name: "unnamed image", artifact: Artifact{ArtifactID: testDigest}},
+	{
+		name: "image",
+		artifact: Artifact{
+			ImageURL:   "registry.local/team/app:1.0",
+			ArtifactID: testDigest,
+			Distro:     Distro{ID: "debian", VersionID: "12", Name: "Debian GNU/Linux 12 (bookworm)"},
+			Packages: []Package{
+				{
+					Name: "libc6", Version: "2.36-9+deb12u4", Type: TypeDeb, Arch: "amd64",
+					PURL:      "pkg:deb/debian/libc6@2.36-9%2Bdeb12u4?arch=amd64&distro=debian-12",
+					Licenses:  []string{"GPL-2.0-or-later", "LGPL-2.1"},
+					Locations: []string{"/var/lib/dpkg/status"},
+				},
+				{
+					Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Type: TypeDeb,
+					PURL:      "pkg:deb/debian/zlib1g@1:1.2.13.dfsg-1?distro=debian-12",
+					Licenses:  []string{"Zlib and other (see copyright)"},
+					Locations: []string{"/var/lib/dpkg/status"},
+				},
+				{
+					Name: "golang.org/x/net", Version: "v0.38.0", Type: TypeGoModule,
+					PURL:      "pkg:golang/golang.org/x/net@v0.38.0",
+					Locations: []string{"/usr/bin/app", "/usr/bin/tool"},
+				},
+				// Two packages the package URL does not tell apart.
+				{Name: "stdlib", Version: "1.23.3", Type: TypeGoModule, PURL: "pkg:golang/stdlib@1.23.3", Locations: []string{"/usr/bin/app"}},
+				{Name: "stdlib", Version: "1.23.3", Type: TypeGoModule, PURL: "pkg:golang/stdlib@1.23.3", Locations: []string{"/usr/bin/tool"}},
+				{Name: "local", Type: "unknown", Licenses: []string{"LGPL-2.1"}},
+			},
+		},
+	},
+}
+
+func TestFormats(t *testing.T) {
+	schemas := map[string]string{"cyclonedx": cycloneDXSchemaID, "spdx": spdxSchemaID}
+	for _, format := range Formats {
+		schema := compileSchema(t, schemas[format.Name])
+		for _, tt := range testArtifacts {
+			t.Run(format.Name+"/"+tt.name, func(t *testing.T) {
+				document, err := format.Encode(tt.artifact, testCreated)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := validateDocument(t, schema, document); err != nil {
+					t.Errorf("document does not validate: %v\n%s", err, document)
+				}
+				if !strings.Contains(string(document), `"2024-03-01T11:30:45Z"`) {
+					t.Errorf("document is not dated with the scan time in UTC:\n%s", document)
+				}
+				again, err := format.Encode(tt.artifact, testCreated)
+				if err != nil || !bytes.Equal(again, document) {
+					t.Errorf("exporting the same scan again gave another document: %v", err)
+				}
+				later, err := format.Encode(tt.artifact, testCreated.Add(time.Hour))
+				if err != nil || bytes.Equal(later, document) {
+					t.Errorf("exporting another scan gave the same document: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestSchemasRejectInvalidDocuments(t *testing.T) {
+	artifact := testArtifacts[2].artifact
+	tests := []struct {
+		name     string
+		encode   func(Artifact, time.Time) ([]byte, error)
+		schemaID string
+		old, new string
+	}{
+		{name: "cyclonedx format", encode: CycloneDX, schemaID: cycloneDXSchemaID, old: `"bomFormat":"CycloneDX"`, new: `"bomFormat":"SPDX"`},
+		{name: "cyclonedx serial number", encode: CycloneDX, schemaID: cycloneDXSchemaID, old: `"serialNumber":"urn:uuid:`, new: `"serialNumber":"uuid:`},
+		{name: "cyclonedx component type", encode: CycloneDX, schemaID: cycloneDXSchemaID, old: `"type":"library"`, new: `"type":"package"`},
+		{name: "cyclonedx hash", encode: CycloneDX, schemaID: cycloneDXSchemaID, old: `"content":"0123`, new: `"content":"xyz`},
+		{name: "spdx data license", encode: SPDX, schemaID: spdxSchemaID, old: `"dataLicense":"CC0-1.0",`, new: ``},
+		{name: "spdx relationship", encode: SPDX, schemaID: spdxSchemaID, old: `"relationshipType":"CONTAINS"`, new: `"relationshipType":"HAS"`},
+		{name: "spdx unknown field", encode: SPDX, schemaID: spdxSchemaID, old: `"filesAnalyzed":false`, new: `"filesAnalysed":false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := tt.encode(artifact, testCreated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(document, []byte(tt.old)) {
+				t.Fatalf("document has no %s:\n%s", tt.old, document)
+			}
+			invalid := bytes.Replace(document, []byte(tt.old), []byte(tt.new), 1)
+			if err := validateDocument(t, compileSchema(t, tt.schemaID), invalid); err == nil {
+				t.Errorf("document with %s instead of %s validates", tt.new, tt.old)
+			}
+		})
+	}
+}
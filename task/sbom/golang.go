@@ -0,0 +1,55 @@
+package sbom
+
+import (
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// catalogGo lists the modules compiled into every Go binary, including the
+// standard library of the toolchain that built it.
+func catalogGo(t *tree, _ Distro) ([]Package, error) {
+	names := make([]string, 0, len(t.binaries))
+	for name := range t.binaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var packages []Package
+	for _, name := range names {
+		info := t.binaries[name]
+		location := "/" + name
+		// GoVersion may carry experiments, like "go1.22.1 X:boringcrypto".
+		if fields := strings.Fields(info.GoVersion); len(fields) > 0 {
+			packages = append(packages, goPackage("stdlib", strings.TrimPrefix(fields[0], "go"), location))
+		}
+		if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			packages = append(packages, goPackage(info.Main.Path, info.Main.Version, location))
+		}
+		for _, dep := range info.Deps {
+			packages = append(packages, goModule(dep, location))
+		}
+	}
+	return packages, nil
+}
+
+func goModule(module *debug.Module, location string) Package {
+	if module.Replace != nil {
+		module = module.Replace
+	}
+	return goPackage(module.Path, module.Version, location)
+}
+
+func goPackage(modulePath, version, location string) Package {
+	namespace, name := "", modulePath
+	if i := strings.LastIndex(modulePath, "/"); i >= 0 {
+		namespace, name = modulePath[:i], modulePath[i+1:]
+	}
+	return Package{
+		Name:      modulePath,
+		Version:   version,
+		Type:      TypeGoModule,
+		PURL:      purl("golang", namespace, name, version, nil),
+		Locations: []string{location},
+	}
+}
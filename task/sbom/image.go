@@ -0,0 +1,256 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	annotationRefName        = "org.opencontainers.image.ref.name"
+	annotationContainerdName = "io.containerd.image.name"
+	// annotationReferenceType marks the attestation manifests BuildKit adds
+	// to an index next to the images they describe.
+	annotationReferenceType  = "vnd.docker.reference.type"
+	referenceTypeAttestation = "attestation-manifest"
+
+	// maxManifestBytes caps the size of index, manifest and config blobs.
+	maxManifestBytes = 8 << 20
+)
+
+// layerMediaTypes are the media types of layers that are tar streams.
+var layerMediaTypes = map[string]bool{
+	"application/vnd.oci.image.layer.v1.tar":                       true,
+	"application/vnd.oci.image.layer.v1.tar+gzip":                  true,
+	"application/vnd.oci.image.layer.v1.tar+zstd":                  true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar":      true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+zstd": true,
+	"application/vnd.docker.image.rootfs.diff.tar":                 true,
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":            true,
+	"application/vnd.docker.image.rootfs.diff.tar.zstd":            true,
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":    true,
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	MediaType   string            `json:"mediaType"`
+	Manifests   []descriptor      `json:"manifests"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// dockerManifest is one image of the manifest.json of `docker save`.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// image is one image to catalog: where its layers are and what to call it.
+type image struct {
+	imageURL   string
+	artifactID string
+	layers     []string
+}
+
+// readLayout lists the images of the OCI image layout in fsys, following
+// nested indexes.
+func readLayout(fsys fs.FS) ([]image, error) {
+	var index ociIndex
+	if err := readJSON(fsys, "index.json", &index); err != nil {
+		return nil, err
+	}
+	return layoutImages(fsys, index.Manifests, "")
+}
+
+func layoutImages(fsys fs.FS, manifests []descriptor, name string) ([]image, error) {
+	var images []image
+	for _, desc := range manifests {
+		if desc.Annotations[annotationReferenceType] == referenceTypeAttestation {
+			continue
+		}
+		descName := name
+		if ref := desc.Annotations[annotationContainerdName]; ref != "" {
+			descName = ref
+		} else if ref := desc.Annotations[annotationRefName]; ref != "" && descName == "" {
+			descName = ref
+		}
+
+		blob, err := blobPath(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		switch desc.MediaType {
+		case mediaTypeOCIIndex, mediaTypeDockerList:
+			var index ociIndex
+			if err := readJSON(fsys, blob, &index); err != nil {
+				return nil, err
+			}
+			nested, err := layoutImages(fsys, index.Manifests, descName)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, nested...)
+		default:
+			var manifest ociManifest
+			if err := readJSON(fsys, blob, &manifest); err != nil {
+				return nil, err
+			}
+			img := image{imageURL: descName, artifactID: desc.Digest}
+			for _, layer := range manifest.Layers {
+				if !layerMediaTypes[layer.MediaType] {
+					continue
+				}
+				layerPath, err := blobPath(layer.Digest)
+				if err != nil {
+					return nil, err
+				}
+				img.layers = append(img.layers, layerPath)
+			}
+			// A manifest whose layers are all something else, like the
+			// in-toto statements of an attestation, is not an image.
+			if len(manifest.Layers) > 0 && len(img.layers) == 0 {
+				continue
+			}
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// readDockerArchive lists the images of an unpacked `docker save` archive.
+func readDockerArchive(fsys fs.FS) ([]image, error) {
+	var manifests []dockerManifest
+	if err := readJSON(fsys, "manifest.json", &manifests); err != nil {
+		return nil, err
+	}
+
+	images := make([]image, 0, len(manifests))
+	for _, manifest := range manifests {
+		config, err := readFile(fsys, manifest.Config, maxManifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(config)
+		img := image{artifactID: "sha256:" + hex.EncodeToString(sum[:])}
+		if len(manifest.RepoTags) > 0 {
+			img.imageURL = manifest.RepoTags[0]
+		}
+		for _, layer := range manifest.Layers {
+			img.layers = append(img.layers, cleanName(layer))
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// blobPath is where the blob with digest is kept in an OCI layout.
+func blobPath(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || encoded == "" || strings.ContainsAny(digest, "/\\.") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return path.Join("blobs", algorithm, encoded), nil
+}
+
+func readFile(fsys fs.FS, name string, limit int64) ([]byte, error) {
+	f, err := fsys.Open(cleanName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+	}
+	return content, nil
+}
+
+func readJSON(fsys fs.FS, name string, v any) error {
+	content, err := readFile(fsys, name, maxManifestBytes)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// buildTree applies the layers of img in order.
+func buildTree(ctx context.Context, fsys fs.FS, img image) (*tree, error) {
+	t := newTree()
+	for _, layer := range img.layers {
+		if err := applyLayerFile(ctx, t, fsys, layer); err != nil {
+			return nil, fmt.Errorf("failed to unpack layer %s: %w", layer, err)
+		}
+	}
+	return t, nil
+}
+
+func applyLayerFile(ctx context.Context, t *tree, fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return t.applyLayer(ctx, r)
+}
+
+// decompress detects gzip and zstd streams from their magic bytes, so layers
+// are read whatever their media type claims.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
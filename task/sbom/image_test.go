@@ -0,0 +1,73 @@
+package sbom
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLayoutImages(t *testing.T) {
+	const (
+		tarGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+		inToto  = "application/vnd.in-toto+json"
+	)
+	fsys := fstest.MapFS{}
+	blob := func(digest string, v any) descriptor {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys["blobs/sha256/"+digest] = &fstest.MapFile{Data: content}
+		return descriptor{MediaType: mediaTypeOCIManifest, Digest: "sha256:" + digest}
+	}
+	layer := func(mediaType, digest string) descriptor {
+		return descriptor{MediaType: mediaType, Digest: "sha256:" + digest}
+	}
+
+	amd64 := blob("amd64", ociManifest{Layers: []descriptor{layer(tarGzip, "base"), layer("application/vnd.docker.image.rootfs.diff.tar.gzip", "app")}})
+	arm64 := blob("arm64", ociManifest{Layers: []descriptor{
+		layer("application/vnd.oci.image.layer.v1.tar+zstd", "base-arm64"),
+		layer("application/vnd.oci.empty.v1+json", "empty"),
+	}})
+	attestation := blob("attestation", ociManifest{Layers: []descriptor{layer(inToto, "provenance")}})
+	attestation.Annotations = map[string]string{annotationReferenceType: referenceTypeAttestation}
+	artifact := blob("artifact", ociManifest{Layers: []descriptor{layer(inToto, "signature")}})
+	scratch := blob("scratch", ociManifest{})
+
+	nested := blob("index", ociIndex{Manifests: []descriptor{amd64, arm64, attestation}})
+	nested.MediaType = mediaTypeOCIIndex
+	nested.Annotations = map[string]string{annotationRefName: "registry.local/app:1.0"}
+
+	tests := []struct {
+		name      string
+		manifests []descriptor
+		want      []image
+		wantErr   bool
+	}{
+		{
+			name:      "index with an attestation",
+			manifests: []descriptor{nested},
+			want: []image{
+				{imageURL: "registry.local/app:1.0", artifactID: "sha256:amd64", layers: []string{"blobs/sha256/base", "blobs/sha256/app"}},
+				{imageURL: "registry.local/app:1.0", artifactID: "sha256:arm64", layers: []string{"blobs/sha256/base-arm64"}},
+			},
+		},
+		{name: "attestation only", manifests: []descriptor{attestation}},
+		{name: "artifact without tar layers", manifests: []descriptor{artifact}},
+		{name: "image without layers", manifests: []descriptor{scratch}, want: []image{{artifactID: "sha256:scratch"}}},
+		{name: "invalid digest", manifests: []descriptor{{MediaType: mediaTypeOCIManifest, Digest: "sha256:../index.json"}}, wantErr: true},
+		{name: "missing blob", manifests: []descriptor{{MediaType: mediaTypeOCIManifest, Digest: "sha256:missing"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := layoutImages(fsys, tt.manifests, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("layoutImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("layoutImages() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
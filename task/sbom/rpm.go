@@ -0,0 +1,157 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// rpmDatabases are the rpm databases in the order they are looked for: the
+// SQLite database of rpm 4.16 and later, the NDB database of SUSE and the
+// Berkeley DB of older releases.
+var rpmDatabases = []struct {
+	name   string
+	values func([]byte) ([][]byte, error)
+}{
+	{name: "rpmdb.sqlite", values: sqliteRpmValues},
+	{name: "Packages.db", values: ndbValues},
+	{name: "Packages", values: bdbValues},
+}
+
+var rpmDirs = []string{"usr/lib/sysimage/rpm/", "var/lib/rpm/"}
+
+// Header tags and types, from rpmtag.h.
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagLicense = 1014
+	rpmTagArch    = 1022
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+
+	rpmEntrySize = 16
+)
+
+// catalogRpm reads the first rpm database found.
+func catalogRpm(t *tree, distro Distro) ([]Package, error) {
+	for _, dir := range rpmDirs {
+		for _, db := range rpmDatabases {
+			name := dir + db.name
+			content, ok := t.files[name]
+			if !ok {
+				continue
+			}
+			blobs, err := db.values(content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			return rpmPackages(blobs, "/"+name, distro), nil
+		}
+	}
+	return nil, nil
+}
+
+func rpmPackages(blobs [][]byte, location string, distro Distro) []Package {
+	var packages []Package
+	for _, blob := range blobs {
+		header, err := parseRpmHeader(blob)
+		if err != nil || header.name == "" || header.name == "gpg-pubkey" {
+			continue
+		}
+
+		version := header.version
+		if header.release != "" {
+			version += "-" + header.release
+		}
+		epoch := ""
+		if header.epoch != nil {
+			epoch = strconv.Itoa(int(*header.epoch))
+		}
+		// The package version is epoch:version-release, as rpm prints
+		// %{EVR}; the package URL carries the epoch as a qualifier.
+		p := Package{
+			Name:      header.name,
+			Version:   version,
+			Type:      TypeRpm,
+			Arch:      header.arch,
+			Locations: []string{location},
+			PURL: purl(TypeRpm, distro.ID, header.name, version, map[string]string{
+				"arch":   header.arch,
+				"epoch":  epoch,
+				"distro": distro.qualifier(),
+			}),
+		}
+		if epoch != "" {
+			p.Version = epoch + ":" + version
+		}
+		if header.license != "" {
+			p.Licenses = []string{header.license}
+		}
+		packages = append(packages, p)
+	}
+	return packages
+}
+
+type rpmHeader struct {
+	name, version, release, arch, license string
+	epoch                                 *int32
+}
+
+// parseRpmHeader decodes the header blob rpm keeps in its database: the
+// entry count and data size, the entries and then their data.
+func parseRpmHeader(blob []byte) (rpmHeader, error) {
+	var header rpmHeader
+	if len(blob) < 8 {
+		return header, errors.New("rpm header is too short")
+	}
+	count := binary.BigEndian.Uint32(blob[0:4])
+	size := binary.BigEndian.Uint32(blob[4:8])
+	dataStart := 8 + uint64(count)*rpmEntrySize
+	if dataStart+uint64(size) > uint64(len(blob)) {
+		return header, errors.New("rpm header is truncated")
+	}
+	data := blob[dataStart : dataStart+uint64(size)]
+
+	for i := uint64(0); i < uint64(count); i++ {
+		entry := blob[8+i*rpmEntrySize : 8+(i+1)*rpmEntrySize]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		typ := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+		if uint64(offset) >= uint64(len(data)) {
+			continue
+		}
+
+		switch typ {
+		case rpmTypeString, rpmTypeStringArray, rpmTypeI18NString:
+			value := data[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			switch tag {
+			case rpmTagName:
+				header.name = string(value)
+			case rpmTagVersion:
+				header.version = string(value)
+			case rpmTagRelease:
+				header.release = string(value)
+			case rpmTagArch:
+				header.arch = string(value)
+			case rpmTagLicense:
+				header.license = string(value)
+			}
+		case rpmTypeInt32:
+			if tag == rpmTagEpoch && uint64(offset)+4 <= uint64(len(data)) {
+				epoch := int32(binary.BigEndian.Uint32(data[offset:]))
+				header.epoch = &epoch
+			}
+		}
+	}
+	return header, nil
+}
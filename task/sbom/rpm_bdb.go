@@ -0,0 +1,119 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Berkeley DB hash database layout, from dbinc/db_page.h.
+const (
+	bdbHashMagic = 0x061561
+
+	bdbPageHeaderSize = 26
+
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHashMeta     = 8
+	bdbPageHash         = 13
+
+	bdbItemKeyData = 1
+	bdbItemOffPage = 3
+)
+
+// bdbValues returns the values of a Berkeley DB hash database, the
+// Packages file of rpm before 4.16. Large values are kept on chains of
+// overflow pages.
+func bdbValues(db []byte) ([][]byte, error) {
+	if len(db) < 72 {
+		return nil, errors.New("berkeley db is too short")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(db[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(db[12:16]) != bdbHashMagic {
+			return nil, errors.New("not a berkeley db hash database")
+		}
+	}
+	if db[25] != bdbPageHashMeta {
+		return nil, fmt.Errorf("unexpected berkeley db metadata page type %d", db[25])
+	}
+	pageSize := int(order.Uint32(db[20:24]))
+	if pageSize < 512 || pageSize > 64<<10 {
+		return nil, fmt.Errorf("invalid berkeley db page size %d", pageSize)
+	}
+	pages := len(db) / pageSize
+	page := func(pgno uint32) []byte {
+		if pgno == 0 || int(pgno) >= pages {
+			return nil
+		}
+		return db[int(pgno)*pageSize : int(pgno+1)*pageSize]
+	}
+
+	var values [][]byte
+	for pgno := uint32(1); int(pgno) < pages; pgno++ {
+		p := page(pgno)
+		if p[25] != bdbPageHash && p[25] != bdbPageHashUnsorted {
+			continue
+		}
+		entries := int(order.Uint16(p[20:22]))
+		if bdbPageHeaderSize+2*entries > pageSize {
+			continue
+		}
+		offsets := make([]int, entries)
+		for i := range offsets {
+			offsets[i] = int(order.Uint16(p[bdbPageHeaderSize+2*i:]))
+		}
+
+		// Entries are key and value pairs; a value is stored right below
+		// its key.
+		for i := 1; i < entries; i += 2 {
+			offset := offsets[i]
+			if offset >= pageSize || offsets[i-1] > pageSize || offset >= offsets[i-1] {
+				continue
+			}
+			switch p[offset] {
+			case bdbItemKeyData:
+				values = append(values, p[offset+1:offsets[i-1]])
+			case bdbItemOffPage:
+				if offset+12 > pageSize {
+					continue
+				}
+				size := order.Uint32(p[offset+8:])
+				if uint64(size) > uint64(len(db)) {
+					return values, fmt.Errorf("berkeley db value on page %d is larger than the database", pgno)
+				}
+				value, err := bdbOverflow(page, order, order.Uint32(p[offset+4:]), size, pages)
+				if err != nil {
+					return values, err
+				}
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// bdbOverflow reads a value of length size from the overflow pages starting
+// at pgno.
+func bdbOverflow(page func(uint32) []byte, order binary.ByteOrder, pgno, size uint32, pages int) ([]byte, error) {
+	value := make([]byte, 0, size)
+	for visited := 0; pgno != 0; visited++ {
+		p := page(pgno)
+		if p == nil || visited > pages || p[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("broken berkeley db overflow chain at page %d", pgno)
+		}
+		// An overflow page keeps its data length where other pages keep
+		// the free space offset.
+		length := int(order.Uint16(p[22:24]))
+		if bdbPageHeaderSize+length > len(p) {
+			return nil, fmt.Errorf("invalid berkeley db overflow page %d", pgno)
+		}
+		value = append(value, p[bdbPageHeaderSize:bdbPageHeaderSize+length]...)
+		pgno = order.Uint32(p[16:20])
+	}
+	if uint32(len(value)) < size {
+		return nil, errors.New("truncated berkeley db overflow value")
+	}
+	return value[:size], nil
+}
@@ -0,0 +1,12 @@
+package sbom
+
+import (
+	"testing"
+)
+
+func FuzzBDBValues(f *testing.F) {
+	f.Add(readFixture(f, "Packages"))
+	f.Fuzz(func(t *testing.T, db []byte) {
+		_, _ = bdbValues(db)
+	})
+}
@@ -0,0 +1,65 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// NDB layout, from lib/backend/ndb/rpmpkg.c. Every number is little endian.
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+
+	ndbPageSize = 4096
+	ndbSlotSize = 16
+	// ndbHeaderSlots is how many slots the file header takes.
+	ndbHeaderSlots = 2
+	ndbBlockSize   = 16
+	ndbBlobHead    = 16
+)
+
+// ndbValues returns the package headers of an NDB database, the Packages.db
+// file of SUSE. The file starts with pages of slots, each pointing at a blob
+// of blocks holding one header.
+func ndbValues(db []byte) ([][]byte, error) {
+	if len(db) < ndbSlotSize*ndbHeaderSlots {
+		return nil, errors.New("ndb database is too short")
+	}
+	le := binary.LittleEndian
+	if le.Uint32(db[0:4]) != ndbHeaderMagic {
+		return nil, errors.New("not an ndb database")
+	}
+	if version := le.Uint32(db[4:8]); version != 0 {
+		return nil, fmt.Errorf("unsupported ndb version %d", version)
+	}
+	slotPages := int(le.Uint32(db[12:16]))
+	if slotPages <= 0 || slotPages*ndbPageSize > len(db) {
+		return nil, fmt.Errorf("invalid ndb slot page count %d", slotPages)
+	}
+
+	var values [][]byte
+	for offset := ndbSlotSize * ndbHeaderSlots; offset < slotPages*ndbPageSize; offset += ndbSlotSize {
+		slot := db[offset : offset+ndbSlotSize]
+		pkgIndex := le.Uint32(slot[4:8])
+		if le.Uint32(slot[0:4]) != ndbSlotMagic || pkgIndex == 0 {
+			continue
+		}
+
+		start := uint64(le.Uint32(slot[8:12])) * ndbBlockSize
+		if start+ndbBlobHead > uint64(len(db)) {
+			return values, fmt.Errorf("ndb package %d is out of range", pkgIndex)
+		}
+		head := db[start : start+ndbBlobHead]
+		if le.Uint32(head[0:4]) != ndbBlobMagic || le.Uint32(head[4:8]) != pkgIndex {
+			return values, fmt.Errorf("invalid ndb blob for package %d", pkgIndex)
+		}
+		length := uint64(le.Uint32(head[12:16]))
+		if start+ndbBlobHead+length > uint64(len(db)) {
+			return values, fmt.Errorf("ndb package %d is truncated", pkgIndex)
+		}
+		values = append(values, db[start+ndbBlobHead:start+ndbBlobHead+length])
+	}
+	return values, nil
+}
@@ -0,0 +1,12 @@
+package sbom
+
+import (
+	"testing"
+)
+
+func FuzzNDBValues(f *testing.F) {
+	f.Add(readFixture(f, "Packages.db"))
+	f.Fuzz(func(t *testing.T, db []byte) {
+		_, _ = ndbValues(db)
+	})
+}
@@ -0,0 +1,153 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// readFixture reads a file of testdata/rpmdb, written by its generate.py.
+func readFixture(tb testing.TB, name string) []byte {
+	tb.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "rpmdb", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return content
+}
+
+// fixturePackages are the packages of every rpmdb fixture, by name.
+func fixturePackages(location string) []Package {
+	return []Package{
+		{
+			Name: "bash", Version: "5.1.8-6.el9", Type: TypeRpm, Arch: "x86_64", Licenses: []string{"GPLv3+"},
+			PURL:      "pkg:rpm/rhel/bash@5.1.8-6.el9?arch=x86_64&distro=rhel-9.3",
+			Locations: []string{location},
+		},
+		{
+			Name: "openssl-libs", Version: "1:3.0.7-27.el9", Type: TypeRpm, Arch: "x86_64", Licenses: []string{"ASL 2.0"},
+			PURL:      "pkg:rpm/rhel/openssl-libs@3.0.7-27.el9?arch=x86_64&distro=rhel-9.3&epoch=1",
+			Locations: []string{location},
+		},
+		{
+			Name: "tzdata", Version: "2024a-1.el9", Type: TypeRpm, Arch: "noarch", Licenses: []string{"Public Domain"},
+			PURL:      "pkg:rpm/rhel/tzdata@2024a-1.el9?arch=noarch&distro=rhel-9.3",
+			Locations: []string{location},
+		},
+	}
+}
+
+func TestCatalogRpm(t *testing.T) {
+	sqlite, ndb, bdb := readFixture(t, "rpmdb.sqlite"), readFixture(t, "Packages.db"), readFixture(t, "Packages")
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		want    []Package
+		wantErr bool
+	}{
+		{name: "no database"},
+		{
+			name:  "sqlite",
+			files: map[string][]byte{"var/lib/rpm/rpmdb.sqlite": sqlite},
+			want:  fixturePackages("/var/lib/rpm/rpmdb.sqlite"),
+		},
+		{
+			name:  "ndb",
+			files: map[string][]byte{"usr/lib/sysimage/rpm/Packages.db": ndb},
+			want:  fixturePackages("/usr/lib/sysimage/rpm/Packages.db"),
+		},
+		{
+			name:  "berkeley db",
+			files: map[string][]byte{"var/lib/rpm/Packages": bdb},
+			want:  fixturePackages("/var/lib/rpm/Packages"),
+		},
+		{
+			name:  "sqlite is read before berkeley db",
+			files: map[string][]byte{"var/lib/rpm/rpmdb.sqlite": sqlite, "var/lib/rpm/Packages": []byte("stale")},
+			want:  fixturePackages("/var/lib/rpm/rpmdb.sqlite"),
+		},
+		{
+			name:  "sysimage is read before var",
+			files: map[string][]byte{"usr/lib/sysimage/rpm/rpmdb.sqlite": sqlite, "var/lib/rpm/Packages": []byte("stale")},
+			want:  fixturePackages("/usr/lib/sysimage/rpm/rpmdb.sqlite"),
+		},
+		{name: "truncated sqlite", files: map[string][]byte{"var/lib/rpm/rpmdb.sqlite": sqlite[:4096]}, wantErr: true},
+		{name: "truncated ndb", files: map[string][]byte{"var/lib/rpm/Packages.db": ndb[:5000]}, wantErr: true},
+		{name: "not a berkeley db", files: map[string][]byte{"var/lib/rpm/Packages": make([]byte, 4096)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newTree()
+			for name, content := range tt.files {
+				tree.files[name] = content
+			}
+			got, err := catalogRpm(tree, Distro{ID: "rhel", VersionID: "9.3"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("catalogRpm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// Berkeley DB keeps packages in hash order.
+			sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("catalogRpm() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rpmHeaderBlob encodes string entries as a header blob.
+func rpmHeaderBlob(entries map[uint32]string) []byte {
+	tags := make([]int, 0, len(entries))
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+	var index, data []byte
+	for _, tag := range tags {
+		index = binary.BigEndian.AppendUint32(index, uint32(tag))
+		index = binary.BigEndian.AppendUint32(index, rpmTypeString)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(data)))
+		index = binary.BigEndian.AppendUint32(index, 1)
+		data = append(append(data, entries[uint32(tag)]...), 0)
+	}
+	blob := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	blob = binary.BigEndian.AppendUint32(blob, uint32(len(data)))
+	return append(append(blob, index...), data...)
+}
+
+func TestParseRpmHeader(t *testing.T) {
+	valid := rpmHeaderBlob(map[uint32]string{rpmTagName: "bash", rpmTagVersion: "5.1.8", rpmTagArch: "x86_64"})
+	tests := []struct {
+		name    string
+		blob    []byte
+		want    rpmHeader
+		wantErr bool
+	}{
+		{name: "valid", blob: valid, want: rpmHeader{name: "bash", version: "5.1.8", arch: "x86_64"}},
+		{name: "too short", blob: valid[:7], wantErr: true},
+		{name: "truncated data", blob: valid[:len(valid)-1], wantErr: true},
+		{name: "entry count past the end", blob: []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, wantErr: true},
+		{name: "no entries", blob: make([]byte, 8)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRpmHeader(tt.blob)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRpmHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRpmHeader() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseRpmHeader(f *testing.F) {
+	f.Add(rpmHeaderBlob(map[uint32]string{rpmTagName: "bash", rpmTagVersion: "5.1.8"}))
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 4, 0, 0, 0x03, 0xeb, 0, 0, 0, 4, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		_, _ = parseRpmHeader(blob)
+	})
+}
@@ -0,0 +1,116 @@
+// Package sbom catalogs the packages installed in container images and root
+// filesystems, without network access.
+package sbom
+
+import (
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Package types.
+const (
+	TypeApk      = "apk"
+	TypeDeb      = "deb"
+	TypeRpm      = "rpm"
+	TypeGoModule = "go-module"
+)
+
+// Package is one installed package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Type is apk, deb, rpm or go-module.
+	Type     string   `json:"type"`
+	PURL     string   `json:"purl,omitempty"`
+	Arch     string   `json:"arch,omitempty"`
+	Licenses []string `json:"licenses,omitempty"`
+	// Locations are the package databases or binaries the package was
+	// found in.
+	Locations []string `json:"locations"`
+}
+
+// Artifact is a cataloged image or root filesystem.
+type Artifact struct {
+	ImageURL string
+	// ArtifactID is the manifest digest of an OCI image, the image ID of a
+	// docker archive, or the path of a root filesystem.
+	ArtifactID string
+	Distro     Distro
+	Packages   []Package
+}
+
+// purl builds a package URL. Qualifiers with empty values are left out.
+func purl(typ, namespace, name, version string, qualifiers map[string]string) string {
+	var b strings.Builder
+	b.WriteString("pkg:")
+	b.WriteString(typ)
+	b.WriteByte('/')
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(url.PathEscape(segment))
+			b.WriteByte('/')
+		}
+	}
+	b.WriteString(url.PathEscape(name))
+	if version != "" {
+		b.WriteByte('@')
+		b.WriteString(url.PathEscape(version))
+	}
+
+	keys := make([]string, 0, len(qualifiers))
+	for key, value := range qualifiers {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(qualifiers[key]))
+	}
+	return b.String()
+}
+
+// mergePackages combines packages with the same type, name, version and
+// architecture found in several locations, and sorts them.
+func mergePackages(packages []Package) []Package {
+	type key struct{ typ, name, version, arch string }
+	positions := make(map[key]int, len(packages))
+	merged := make([]Package, 0, len(packages))
+	for _, p := range packages {
+		k := key{p.Type, p.Name, p.Version, p.Arch}
+		if i, ok := positions[k]; ok {
+			merged[i].Locations = append(merged[i].Locations, p.Locations...)
+			continue
+		}
+		positions[k] = len(merged)
+		merged = append(merged, p)
+	}
+
+	for i := range merged {
+		slices.Sort(merged[i].Locations)
+		merged[i].Locations = slices.Compact(merged[i].Locations)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Arch < b.Arch
+	})
+	return merged
+}
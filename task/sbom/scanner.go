@@ -0,0 +1,120 @@
+package sbom
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Scanner catalogs the packages of container images and root filesystems.
+type Scanner struct {
+	logger *zap.Logger
+}
+
+func NewScanner(logger *zap.Logger) *Scanner {
+	return &Scanner{logger: logger}
+}
+
+// Scan catalogs what is at path: an OCI image layout directory, an unpacked
+// `docker save` archive, a tarball of either, or else an unpacked root
+// filesystem. There is one artifact per image, so an image index may yield
+// several.
+func (s *Scanner) Scan(ctx context.Context, path string) ([]Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := os.DirFS(path)
+	if !info.IsDir() {
+		archive, err := openArchive(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", path, err)
+		}
+		defer archive.Close()
+		fsys = archive
+	}
+
+	var images []image
+	switch {
+	case exists(fsys, "oci-layout") && exists(fsys, "index.json"):
+		images, err = readLayout(fsys)
+	case exists(fsys, "manifest.json"):
+		images, err = readDockerArchive(fsys)
+	case info.IsDir():
+		return s.scanRoot(ctx, path)
+	default:
+		return nil, fmt.Errorf("%s is neither an OCI image layout nor a docker archive", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", path, err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%s holds no image", path)
+	}
+
+	artifacts := make([]Artifact, 0, len(images))
+	for _, img := range images {
+		s.logger.Info("cataloging image", zap.String("image", img.imageURL), zap.String("artifactID", img.artifactID),
+			zap.Int("layers", len(img.layers)))
+		t, err := buildTree(ctx, fsys, img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack image %s: %w", img.artifactID, err)
+		}
+		artifact := s.catalog(t)
+		artifact.ImageURL = img.imageURL
+		artifact.ArtifactID = img.artifactID
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+func (s *Scanner) scanRoot(ctx context.Context, root string) ([]Artifact, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("cataloging root filesystem", zap.String("root", abs))
+	t, err := walkRoot(ctx, abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", abs, err)
+	}
+	artifact := s.catalog(t)
+	artifact.ImageURL = abs
+	artifact.ArtifactID = abs
+	return []Artifact{artifact}, nil
+}
+
+// catalog runs every cataloger over t. A database that cannot be parsed is
+// logged and skipped.
+func (s *Scanner) catalog(t *tree) Artifact {
+	distro := readDistro(t)
+	var packages []Package
+	for _, cataloger := range []struct {
+		name string
+		run  func(*tree, Distro) ([]Package, error)
+	}{
+		{name: TypeApk, run: catalogApk},
+		{name: TypeDeb, run: catalogDpkg},
+		{name: TypeRpm, run: catalogRpm},
+		{name: TypeGoModule, run: catalogGo},
+	} {
+		found, err := cataloger.run(t, distro)
+		if err != nil {
+			s.logger.Warn("failed to catalog packages", zap.String("cataloger", cataloger.name), zap.Error(err))
+		}
+		packages = append(packages, found...)
+	}
+	return Artifact{
+		Distro:   distro,
+		Packages: mergePackages(packages),
+	}
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
@@ -0,0 +1,179 @@
+package sbom
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// SPDX 2.3 JSON, from https://spdx.github.io/spdx-spec/v2.3/.
+const (
+	spdxVersion     = "SPDX-2.3"
+	spdxDataLicense = "CC0-1.0"
+	spdxDocumentID  = "SPDXRef-DOCUMENT"
+	spdxImageID     = "SPDXRef-Image"
+	spdxNoAssertion = "NOASSERTION"
+
+	// spdxNamespaceBase prefixes document namespaces, which only need to
+	// be unique.
+	spdxNamespaceBase = "https://github.com/opengovern/og-task-template/spdx/"
+)
+
+// spdxInvalidIDChars are the characters an SPDX ID cannot hold.
+var spdxInvalidIDChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+	// HasExtractedLicensingInfos defines the LicenseRefs packages use.
+	HasExtractedLicensingInfos []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	CopyrightText         string            `json:"copyrightText"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	ExtractedText string `json:"extractedText"`
+	Name          string `json:"name"`
+}
+
+// SPDX returns the SPDX 2.3 JSON document of a. The document describes the
+// image, which contains every package.
+func SPDX(a Artifact, created time.Time) ([]byte, error) {
+	image := spdxPackage{
+		SPDXID:                spdxImageID,
+		Name:                  documentName(a),
+		DownloadLocation:      spdxNoAssertion,
+		LicenseConcluded:      spdxNoAssertion,
+		LicenseDeclared:       spdxNoAssertion,
+		CopyrightText:         spdxNoAssertion,
+		PrimaryPackagePurpose: "CONTAINER",
+	}
+	if digest, ok := sha256Digest(a.ArtifactID); ok {
+		image.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: digest}}
+	}
+	if a.ArtifactID != image.Name {
+		image.VersionInfo = a.ArtifactID
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       spdxDataLicense,
+		SPDXID:            spdxDocumentID,
+		Name:              image.Name,
+		DocumentNamespace: spdxNamespaceBase + url.PathEscape(image.Name) + "-" + documentID("spdx", a, created).String(),
+		CreationInfo: spdxCreationInfo{
+			Created:  timestamp(created),
+			Creators: []string{"Tool: " + ToolName},
+		},
+		Packages: []spdxPackage{image},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: spdxDocumentID, RelationshipType: "DESCRIBES", RelatedSPDXElement: spdxImageID},
+		},
+	}
+
+	// Package databases do not hold SPDX license expressions, so every
+	// license is declared as a LicenseRef carrying the text as found.
+	licenseRefs := map[string]string{}
+	for i, p := range a.Packages {
+		id := "SPDXRef-Package-" + spdxInvalidIDChars.ReplaceAllString(p.Type+"-"+p.Name, "-") + "-" + strconv.Itoa(i)
+		pkg := spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			SourceInfo:       spdxSourceInfo(p),
+		}
+		if len(p.Licenses) > 0 {
+			var declared string
+			for j, license := range p.Licenses {
+				ref, ok := licenseRefs[license]
+				if !ok {
+					ref = "LicenseRef-" + strconv.Itoa(len(licenseRefs))
+					licenseRefs[license] = ref
+					doc.HasExtractedLicensingInfos = append(doc.HasExtractedLicensingInfos, spdxExtractedLicense{
+						LicenseID:     ref,
+						ExtractedText: license,
+						Name:          license,
+					})
+				}
+				if j > 0 {
+					declared += " AND "
+				}
+				declared += ref
+			}
+			pkg.LicenseDeclared = declared
+		}
+		if p.PURL != "" {
+			pkg.ExternalRefs = []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL,
+			}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      spdxImageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return json.Marshal(doc)
+}
+
+func spdxSourceInfo(p Package) string {
+	if len(p.Locations) == 0 {
+		return ""
+	}
+	info := "acquired package info from " + p.Type + " at"
+	for _, location := range p.Locations {
+		info += " " + location
+	}
+	return info
+}
@@ -0,0 +1,82 @@
+package sbom
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSPDX(t *testing.T) {
+	tests := []struct {
+		name              string
+		artifact          Artifact
+		wantImage         spdxPackage
+		wantDeclared      map[string]string
+		wantExtracted     []spdxExtractedLicense
+		wantRelationships int
+	}{
+		{
+			name:     "empty root filesystem",
+			artifact: testArtifacts[0].artifact,
+			wantImage: spdxPackage{
+				SPDXID: spdxImageID, Name: "/srv/rootfs", DownloadLocation: spdxNoAssertion, LicenseConcluded: spdxNoAssertion,
+				LicenseDeclared: spdxNoAssertion, CopyrightText: spdxNoAssertion, PrimaryPackagePurpose: "CONTAINER",
+			},
+			wantDeclared:      map[string]string{},
+			wantRelationships: 1,
+		},
+		{
+			name:     "image",
+			artifact: testArtifacts[2].artifact,
+			wantImage: spdxPackage{
+				SPDXID: spdxImageID, Name: "registry.local/team/app:1.0", VersionInfo: testDigest, DownloadLocation: spdxNoAssertion,
+				Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: testDigest[len("sha256:"):]}},
+				LicenseConcluded: spdxNoAssertion, LicenseDeclared: spdxNoAssertion, CopyrightText: spdxNoAssertion,
+				PrimaryPackagePurpose: "CONTAINER",
+			},
+			// Licenses found twice share one LicenseRef.
+			wantDeclared: map[string]string{
+				"SPDXRef-Package-deb-libc6-0":                  "LicenseRef-0 AND LicenseRef-1",
+				"SPDXRef-Package-deb-zlib1g-1":                 "LicenseRef-2",
+				"SPDXRef-Package-go-module-golang.org-x-net-2": spdxNoAssertion,
+				"SPDXRef-Package-go-module-stdlib-3":           spdxNoAssertion,
+				"SPDXRef-Package-go-module-stdlib-4":           spdxNoAssertion,
+				"SPDXRef-Package-unknown-local-5":              "LicenseRef-1",
+			},
+			wantExtracted: []spdxExtractedLicense{
+				{LicenseID: "LicenseRef-0", ExtractedText: "GPL-2.0-or-later", Name: "GPL-2.0-or-later"},
+				{LicenseID: "LicenseRef-1", ExtractedText: "LGPL-2.1", Name: "LGPL-2.1"},
+				{LicenseID: "LicenseRef-2", ExtractedText: "Zlib and other (see copyright)", Name: "Zlib and other (see copyright)"},
+			},
+			wantRelationships: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := SPDX(tt.artifact, testCreated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc spdxDocument
+			if err := json.Unmarshal(document, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Packages[0], tt.wantImage) {
+				t.Errorf("image package = %+v, want %+v", doc.Packages[0], tt.wantImage)
+			}
+			declared := map[string]string{}
+			for _, pkg := range doc.Packages[1:] {
+				declared[pkg.SPDXID] = pkg.LicenseDeclared
+			}
+			if !reflect.DeepEqual(declared, tt.wantDeclared) {
+				t.Errorf("declared licenses = %v, want %v", declared, tt.wantDeclared)
+			}
+			if !reflect.DeepEqual(doc.HasExtractedLicensingInfos, tt.wantExtracted) {
+				t.Errorf("extracted licenses = %+v, want %+v", doc.HasExtractedLicensingInfos, tt.wantExtracted)
+			}
+			if len(doc.Relationships) != tt.wantRelationships {
+				t.Errorf("%d relationships, want %d", len(doc.Relationships), tt.wantRelationships)
+			}
+		})
+	}
+}
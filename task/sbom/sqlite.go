@@ -0,0 +1,316 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SQLite file format constants, from https://www.sqlite.org/fileformat.html.
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+
+	sqlitePageInteriorTable = 0x05
+	sqlitePageLeafTable     = 0x0d
+
+	// sqliteMaxDepth bounds the b-tree walk of a corrupt file.
+	sqliteMaxDepth = 64
+)
+
+// sqliteDB reads the rows of tables in an SQLite 3 database file. Changes
+// still in a write-ahead log are not seen.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < sqliteHeaderSize || !bytes.HasPrefix(data, []byte(sqliteMagic)) {
+		return nil, errors.New("not an SQLite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	// The file format requires at least 480 usable bytes per page, which
+	// the payload arithmetic relies on.
+	usable := pageSize - int(data[20])
+	if usable < 480 {
+		return nil, fmt.Errorf("invalid SQLite usable page size %d", usable)
+	}
+	return &sqliteDB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   usable,
+	}, nil
+}
+
+// page returns page n, numbered from 1, and where its b-tree header starts.
+func (db *sqliteDB) page(n uint32) ([]byte, int, error) {
+	start := (int(n) - 1) * db.pageSize
+	if n == 0 || start+db.pageSize > len(db.data) {
+		return nil, 0, fmt.Errorf("SQLite page %d is out of range", n)
+	}
+	headerStart := 0
+	if n == 1 {
+		headerStart = sqliteHeaderSize
+	}
+	return db.data[start : start+db.pageSize], headerStart, nil
+}
+
+// tableRoot finds the root page of table name in the schema.
+func (db *sqliteDB) tableRoot(name string) (uint32, error) {
+	var root uint32
+	err := db.scanTable(1, func(values []any) error {
+		if len(values) < 4 || values[0] != "table" || values[1] != name {
+			return nil
+		}
+		if page, ok := values[3].(int64); ok && page > 0 {
+			root = uint32(page)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if root == 0 {
+		return 0, fmt.Errorf("no table %s", name)
+	}
+	return root, nil
+}
+
+// scanTable calls fn with the values of every row of the table b-tree at
+// root, in rowid order.
+func (db *sqliteDB) scanTable(root uint32, fn func(values []any) error) error {
+	return db.scanPage(root, 0, make(map[uint32]bool), fn)
+}
+
+// scanPage walks the b-tree below page n. Pages already visited are
+// rejected, so a corrupt file cannot make the walk loop or fan out.
+func (db *sqliteDB) scanPage(n uint32, depth int, visited map[uint32]bool, fn func(values []any) error) error {
+	if depth > sqliteMaxDepth {
+		return errors.New("SQLite b-tree is too deep")
+	}
+	if visited[n] {
+		return fmt.Errorf("SQLite page %d is linked twice", n)
+	}
+	visited[n] = true
+	page, header, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	if header+12 > len(page) {
+		return fmt.Errorf("SQLite page %d is truncated", n)
+	}
+	kind := page[header]
+	cells := int(binary.BigEndian.Uint16(page[header+3 : header+5]))
+	pointers := header + 8
+	if kind == sqlitePageInteriorTable {
+		pointers = header + 12
+	}
+	if pointers+2*cells > len(page) {
+		return fmt.Errorf("SQLite page %d has too many cells", n)
+	}
+
+	for i := 0; i < cells; i++ {
+		cell := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+		if cell >= db.usable {
+			return fmt.Errorf("SQLite page %d has an invalid cell", n)
+		}
+		switch kind {
+		case sqlitePageInteriorTable:
+			if cell+4 > len(page) {
+				return fmt.Errorf("SQLite page %d has an invalid cell", n)
+			}
+			if err := db.scanPage(binary.BigEndian.Uint32(page[cell:]), depth+1, visited, fn); err != nil {
+				return err
+			}
+		case sqlitePageLeafTable:
+			payload, err := db.leafPayload(page, cell)
+			if err != nil {
+				return fmt.Errorf("SQLite page %d: %w", n, err)
+			}
+			values, err := parseSQLiteRecord(payload)
+			if err != nil {
+				return fmt.Errorf("SQLite page %d: %w", n, err)
+			}
+			if err := fn(values); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("SQLite page %d is not a table page", n)
+		}
+	}
+	if kind == sqlitePageInteriorTable {
+		return db.scanPage(binary.BigEndian.Uint32(page[header+8:]), depth+1, visited, fn)
+	}
+	return nil
+}
+
+// leafPayload returns the record of the leaf cell at offset, following its
+// overflow pages.
+func (db *sqliteDB) leafPayload(page []byte, offset int) ([]byte, error) {
+	size, n := sqliteVarint(page[offset:])
+	if n == 0 {
+		return nil, errors.New("invalid cell")
+	}
+	offset += n
+	// The rowid is skipped.
+	if _, n = sqliteVarint(page[offset:]); n == 0 {
+		return nil, errors.New("invalid cell")
+	}
+	offset += n
+	if size > uint64(len(db.data)) {
+		return nil, errors.New("cell is larger than the database")
+	}
+
+	// How much of the payload is kept on the page is set by the file format.
+	total := int(size)
+	local := total
+	maxLocal := db.usable - 35
+	if total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if offset+local > len(page) {
+		return nil, errors.New("cell is truncated")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[offset:offset+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	if offset+local+4 > len(page) {
+		return nil, errors.New("cell is truncated")
+	}
+	next := binary.BigEndian.Uint32(page[offset+local:])
+	for visited := 0; len(payload) < total; visited++ {
+		if next == 0 || visited > len(db.data)/db.pageSize {
+			return nil, errors.New("broken overflow chain")
+		}
+		overflow, _, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = binary.BigEndian.Uint32(overflow[0:4])
+	}
+	return payload, nil
+}
+
+// parseSQLiteRecord decodes a record into nil, int64, float64, string or
+// []byte values.
+func parseSQLiteRecord(record []byte) ([]any, error) {
+	headerSize, n := sqliteVarint(record)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(record)) {
+		return nil, errors.New("invalid record header")
+	}
+	header := record[n:headerSize]
+	body := record[headerSize:]
+
+	var values []any
+	for len(header) > 0 {
+		serialType, n := sqliteVarint(header)
+		if n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		header = header[n:]
+
+		var size uint64
+		switch {
+		case serialType == 0 || serialType == 8 || serialType == 9:
+		case serialType <= 4:
+			size = serialType
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = (serialType - 12) / 2
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", serialType)
+		}
+		if size > uint64(len(body)) {
+			return nil, errors.New("record is truncated")
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serialType <= 6:
+			// Big endian two's complement of 1 to 8 bytes.
+			v := int64(int8(field[0]))
+			for _, b := range field[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType%2 == 0:
+			values = append(values, field)
+		default:
+			values = append(values, string(field))
+		}
+	}
+	return values, nil
+}
+
+// sqliteVarint decodes a big endian varint of up to nine bytes. It returns
+// a length of 0 when b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sqliteRpmValues returns the package headers of the rpmdb.sqlite of rpm
+// 4.16 and later, the blob column of its Packages table.
+func sqliteRpmValues(data []byte) ([][]byte, error) {
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := db.tableRoot("Packages")
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	err = db.scanTable(root, func(row []any) error {
+		if len(row) >= 2 {
+			if blob, ok := row[1].([]byte); ok {
+				values = append(values, blob)
+			}
+		}
+		return nil
+	})
+	return values, err
+}
@@ -0,0 +1,108 @@
+package sbom
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseSQLiteRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  []byte
+		want    []any
+		wantErr bool
+	}{
+		{name: "integers", record: []byte{0x03, 0x01, 0x08, 0x2a}, want: []any{int64(42), int64(0)}},
+		{name: "null and negative integer", record: []byte{0x03, 0x00, 0x02, 0xff, 0xfe}, want: []any{nil, int64(-2)}},
+		{name: "text", record: []byte{0x02, 0x0f, 'a'}, want: []any{"a"}},
+		{name: "blob", record: []byte{0x02, 0x0e, 0xff}, want: []any{[]byte{0xff}}},
+		{
+			name:   "float",
+			record: []byte{0x02, 0x07, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+			want:   []any{1.5},
+		},
+		{name: "empty", record: nil, wantErr: true},
+		{name: "header size of zero", record: []byte{0x00, 0x01}, wantErr: true},
+		{name: "header size smaller than its varint", record: []byte{0x80, 0x01, 0x01}, wantErr: true},
+		{name: "header past the end", record: []byte{0x05, 0x01}, wantErr: true},
+		{name: "truncated body", record: []byte{0x02, 0x01}, wantErr: true},
+		{name: "reserved serial type", record: []byte{0x02, 0x0a}, wantErr: true},
+		{
+			name:    "huge serial type",
+			record:  []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSQLiteRecord(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSQLiteRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSQLiteRecord() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteVarint(t *testing.T) {
+	tests := []struct {
+		b     []byte
+		want  uint64
+		wantN int
+	}{
+		{b: []byte{0x7f}, want: 0x7f, wantN: 1},
+		{b: []byte{0x81, 0x00}, want: 0x80, wantN: 2},
+		{b: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, want: math.MaxUint64, wantN: 9},
+		{b: []byte{0x81}, wantN: 0},
+		{b: nil, wantN: 0},
+	}
+	for _, tt := range tests {
+		if got, n := sqliteVarint(tt.b); got != tt.want || n != tt.wantN {
+			t.Errorf("sqliteVarint(%x) = %d, %d, want %d, %d", tt.b, got, n, tt.want, tt.wantN)
+		}
+	}
+}
+
+func TestOpenSQLite(t *testing.T) {
+	valid := readFixture(t, "rpmdb.sqlite")
+	// 512 byte pages with 255 of them reserved leave too little room.
+	reserved := append([]byte(nil), valid[:sqliteHeaderSize]...)
+	reserved[16], reserved[17], reserved[20] = 0x02, 0x00, 255
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "valid", data: valid},
+		{name: "not sqlite", data: make([]byte, 4096), wantErr: true},
+		{name: "too short", data: valid[:99], wantErr: true},
+		{name: "too much reserved space", data: reserved, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openSQLite(tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("openSQLite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzParseSQLiteRecord(f *testing.F) {
+	f.Add([]byte{0x03, 0x01, 0x08, 0x2a})
+	f.Add([]byte{0x02, 0x0f, 'a'})
+	f.Add([]byte{0x80, 0x01, 0x01})
+	f.Fuzz(func(t *testing.T, record []byte) {
+		_, _ = parseSQLiteRecord(record)
+	})
+}
+
+func FuzzSQLiteRpmValues(f *testing.F) {
+	f.Add(readFixture(f, "rpmdb.sqlite"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = sqliteRpmValues(data)
+	})
+}
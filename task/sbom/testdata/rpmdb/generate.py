@@ -0,0 +1,109 @@
+#!/usr/bin/env python3
+"""Writes the rpm databases the rpm cataloger tests read.
+
+The SQLite database is written by SQLite and the Berkeley DB one by
+Berkeley DB, through perl's DB_File, with the schema and keys rpm uses.
+NDB has no library outside rpm, so it is laid out as rpmpkg.c does.
+
+Run from this directory: python3 generate.py
+"""
+
+import sqlite3
+import os
+import struct
+import subprocess
+import zlib
+
+# Header tags and types, from rpmtag.h.
+NAME, VERSION, RELEASE, EPOCH, DESCRIPTION, LICENSE, ARCH = 1000, 1001, 1002, 1003, 1005, 1014, 1022
+INT32, STRING, I18NSTRING = 4, 6, 9
+
+PACKAGES = [
+    {NAME: "bash", VERSION: "5.1.8", RELEASE: "6.el9", LICENSE: "GPLv3+", ARCH: "x86_64"},
+    {NAME: "openssl-libs", EPOCH: 1, VERSION: "3.0.7", RELEASE: "27.el9", LICENSE: "ASL 2.0", ARCH: "x86_64"},
+    {NAME: "gpg-pubkey", VERSION: "fd431d51", RELEASE: "4ae0493b"},
+    # The description makes the header larger than a page, so it is kept
+    # in overflow pages.
+    {NAME: "tzdata", VERSION: "2024a", RELEASE: "1.el9", LICENSE: "Public Domain", ARCH: "noarch",
+     DESCRIPTION: "time zone rules " * 800},
+]
+
+
+def header_blob(tags):
+    """Encodes tags as the header blob rpm stores: the entry count, the data
+    size, the entries and their data."""
+    entries, data = b"", b""
+    for tag in sorted(tags):
+        value = tags[tag]
+        if isinstance(value, int):
+            data += b"\0" * (-len(data) % 4)
+            entries += struct.pack(">IIII", tag, INT32, len(data), 1)
+            data += struct.pack(">i", value)
+        else:
+            typ = I18NSTRING if tag == DESCRIPTION else STRING
+            entries += struct.pack(">IIII", tag, typ, len(data), 1)
+            data += value.encode() + b"\0"
+    return struct.pack(">II", len(tags), len(data)) + entries + data
+
+
+def write_sqlite(blobs):
+    if os.path.exists("rpmdb.sqlite"):
+        os.remove("rpmdb.sqlite")
+    db = sqlite3.connect("rpmdb.sqlite")
+    db.execute("CREATE TABLE 'Packages' (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)")
+    db.execute("CREATE TABLE 'Name' (key '' NOT NULL, hnum INTEGER NOT NULL, idx INTEGER NOT NULL, "
+               "FOREIGN KEY (hnum) REFERENCES 'Packages'(hnum))")
+    for hnum, blob in enumerate(blobs, 1):
+        db.execute("INSERT INTO Packages (hnum, blob) VALUES (?, ?)", (hnum, blob))
+        name = blob[struct.unpack(">I", blob[16:20])[0] + 8 + 16 * struct.unpack(">I", blob[0:4])[0]:].split(b"\0")[0]
+        db.execute("INSERT INTO Name VALUES (?, ?, 0)", (name, hnum))
+    db.commit()
+    db.close()
+
+
+def write_bdb(blobs):
+    # Key 0 holds the next package number, as rpm keeps it.
+    records = [(struct.pack("<I", 0), struct.pack("<I", len(blobs) + 1))]
+    records += [(struct.pack("<I", hnum), blob) for hnum, blob in enumerate(blobs, 1)]
+    script = r'''
+use DB_File;
+use Fcntl;
+unlink "Packages";
+$DB_HASH->{bsize} = 4096;
+$DB_HASH->{lorder} = 1234;
+tie my %db, "DB_File", "Packages", O_RDWR|O_CREAT, 0644, $DB_HASH or die $!;
+binmode STDIN;
+local $/;
+my $in = <STDIN>;
+while (length $in) {
+    my ($klen, $vlen) = unpack("NN", substr($in, 0, 8, ""));
+    my $key = substr($in, 0, $klen, "");
+    $db{$key} = substr($in, 0, $vlen, "");
+}
+untie %db;
+'''
+    stream = b"".join(struct.pack(">II", len(k), len(v)) + k + v for k, v in records)
+    subprocess.run(["perl", "-e", script], input=stream, check=True)
+
+
+def write_ndb(blobs):
+    page, slot, block = 4096, 16, 16
+    slot_pages = 1
+    slots = struct.pack("<IIIII", 0x506d7052, 0, 1, slot_pages, len(blobs) + 1).ljust(2 * slot, b"\0")
+    data = b""
+    offset = slot_pages * page
+    for pkgidx, blob in enumerate(blobs, 1):
+        head = struct.pack("<IIII", 0x53626c42, pkgidx, 1, len(blob))
+        tail = struct.pack("<III", zlib.adler32(head + blob), len(blob), 0x54626c42)
+        stored = head + blob + b"\0" * (-(len(head) + len(blob) + len(tail)) % block) + tail
+        slots += struct.pack("<IIII", 0x746f6c53, pkgidx, (offset + len(data)) // block, len(stored) // block)
+        data += stored
+    with open("Packages.db", "wb") as f:
+        f.write(slots.ljust(slot_pages * page, b"\0") + data)
+
+
+if __name__ == "__main__":
+    blobs = [header_blob(tags) for tags in PACKAGES]
+    write_sqlite(blobs)
+    write_bdb(blobs)
+    write_ndb(blobs)
@@ -0,0 +1,18 @@
+#!/bin/sh
+# Replaces the schemas the export tests validate against with the published
+# ones. The copies in this directory were transcribed from the CycloneDX 1.5
+# and SPDX 2.3 schemas and keep only the definitions the exporters emit:
+# services, vulnerabilities, files, snippets and the like are left out, and
+# CycloneDX license IDs are plain strings instead of the SPDX license list.
+# The tests load every schema here by its $id, so the published files, with
+# the CycloneDX schemas they reference, drop in as they are.
+set -eu
+cd "$(dirname "$0")"
+
+cyclonedx=https://raw.githubusercontent.com/CycloneDX/specification/1.5/schema
+spdx=https://raw.githubusercontent.com/spdx/spdx-spec/v2.3/schemas
+
+curl -fsSL -o bom-1.5.schema.json "$cyclonedx/bom-1.5.schema.json"
+curl -fsSL -o spdx.schema.json "$cyclonedx/spdx.schema.json"
+curl -fsSL -o jsf-0.82.schema.json "$cyclonedx/jsf-0.82.schema.json"
+curl -fsSL -o spdx-schema.json "$spdx/spdx-schema.json"
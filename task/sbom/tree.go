@@ -0,0 +1,307 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxDatabaseBytes caps the size of a package database read into memory.
+	maxDatabaseBytes = 512 << 20
+	// maxBinaryBytes caps the size of an executable copied out of a layer to
+	// look for Go build info.
+	maxBinaryBytes = 512 << 20
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// databasePaths are the files catalogers read, relative to the root.
+var databasePaths = map[string]bool{
+	"etc/os-release":                    true,
+	"usr/lib/os-release":                true,
+	"lib/apk/db/installed":              true,
+	"var/lib/dpkg/status":               true,
+	"var/lib/rpm/Packages":              true,
+	"var/lib/rpm/Packages.db":           true,
+	"var/lib/rpm/rpmdb.sqlite":          true,
+	"usr/lib/sysimage/rpm/Packages":     true,
+	"usr/lib/sysimage/rpm/Packages.db":  true,
+	"usr/lib/sysimage/rpm/rpmdb.sqlite": true,
+}
+
+// dpkgStatusDir holds one status file per package in distroless images.
+const dpkgStatusDir = "var/lib/dpkg/status.d/"
+
+func isDatabase(name string) bool {
+	if databasePaths[name] {
+		return true
+	}
+	if strings.HasPrefix(name, dpkgStatusDir) {
+		return !strings.HasSuffix(name, ".md5sums")
+	}
+	return false
+}
+
+// tree is what the catalogers need from a root filesystem: the content of
+// package databases and the Go modules of every executable, by path
+// relative to the root.
+type tree struct {
+	files    map[string][]byte
+	binaries map[string]*buildinfo.BuildInfo
+}
+
+func newTree() *tree {
+	return &tree{
+		files:    make(map[string][]byte),
+		binaries: make(map[string]*buildinfo.BuildInfo),
+	}
+}
+
+// remove deletes name and everything under it.
+func (t *tree) remove(name string) {
+	prefix := name + "/"
+	for file := range t.files {
+		if file == name || strings.HasPrefix(file, prefix) {
+			delete(t.files, file)
+		}
+	}
+	for file := range t.binaries {
+		if file == name || strings.HasPrefix(file, prefix) {
+			delete(t.binaries, file)
+		}
+	}
+}
+
+// removeChildren deletes everything under dir, but not dir itself.
+func (t *tree) removeChildren(dir string) {
+	if dir == "" {
+		clear(t.files)
+		clear(t.binaries)
+		return
+	}
+	prefix := dir + "/"
+	for file := range t.files {
+		if strings.HasPrefix(file, prefix) {
+			delete(t.files, file)
+		}
+	}
+	for file := range t.binaries {
+		if strings.HasPrefix(file, prefix) {
+			delete(t.binaries, file)
+		}
+	}
+}
+
+// add records the file name if it is a package database or a Go binary.
+// r is read from its start; an io.ReaderAt is used in place when possible.
+func (t *tree) add(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	if isDatabase(name) {
+		if size > maxDatabaseBytes {
+			return fmt.Errorf("%s is larger than %d bytes", name, maxDatabaseBytes)
+		}
+		content, err := io.ReadAll(io.LimitReader(r, maxDatabaseBytes))
+		if err != nil {
+			return err
+		}
+		t.files[name] = content
+		return nil
+	}
+	if mode&0o111 == 0 || size < 4 {
+		return nil
+	}
+
+	if ra, ok := r.(io.ReaderAt); ok {
+		var magic [4]byte
+		if _, err := ra.ReadAt(magic[:], 0); err != nil || !isExecutable(magic[:]) {
+			return nil
+		}
+		if info, err := buildinfo.Read(ra); err == nil {
+			t.binaries[name] = info
+		}
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil || !isExecutable(magic) || size > maxBinaryBytes {
+		return nil
+	}
+	// Build info is read at offsets found in the file headers, so the
+	// executable is copied to a temporary file rather than into memory.
+	f, err := os.CreateTemp("", "sbom-binary-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, io.LimitReader(br, maxBinaryBytes)); err != nil {
+		return err
+	}
+	if info, err := buildinfo.Read(f); err == nil {
+		t.binaries[name] = info
+	}
+	return nil
+}
+
+// copyFile makes name a copy of target in from, for hard links. It reports
+// whether target was known.
+func (t *tree) copyFile(from *tree, name, target string) bool {
+	content, isFile := from.files[target]
+	if isFile && isDatabase(name) {
+		t.files[name] = content
+	}
+	info, isBinary := from.binaries[target]
+	if isBinary {
+		t.binaries[name] = info
+	}
+	return isFile || isBinary
+}
+
+// merge applies upper on top of t.
+func (t *tree) merge(upper *tree) {
+	for name, content := range upper.files {
+		t.files[name] = content
+	}
+	for name, info := range upper.binaries {
+		t.binaries[name] = info
+	}
+}
+
+// isExecutable reports whether magic starts an ELF, Mach-O or PE file.
+func isExecutable(magic []byte) bool {
+	switch {
+	case bytes.HasPrefix(magic, []byte("\x7fELF")), bytes.HasPrefix(magic, []byte("MZ")):
+		return true
+	case bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xce}), bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}), bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return true
+	}
+	return false
+}
+
+// cleanName turns a tar entry or walked path into a path relative to the
+// root, or "" for the root itself.
+func cleanName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// applyLayer applies one layer tar stream on top of t, honouring whiteouts.
+func (t *tree) applyLayer(ctx context.Context, r io.Reader) error {
+	upper := newTree()
+	var removed, opaque, replaced []string
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == whiteoutOpaque:
+			opaque = append(opaque, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed = append(removed, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+			continue
+		}
+
+		replaced = append(replaced, name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if err := upper.add(name, hdr.FileInfo().Mode(), hdr.Size, tr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// The target is in this layer or a lower one.
+			target := cleanName(hdr.Linkname)
+			if !upper.copyFile(upper, name, target) {
+				upper.copyFile(t, name, target)
+			}
+		}
+	}
+
+	for _, dir := range opaque {
+		t.removeChildren(dir)
+	}
+	for _, name := range removed {
+		t.remove(name)
+	}
+	for _, name := range replaced {
+		delete(t.files, name)
+		delete(t.binaries, name)
+	}
+	t.merge(upper)
+	return nil
+}
+
+// walkRoot builds a tree from an unpacked root filesystem. Symbolic links
+// are not followed.
+func walkRoot(ctx context.Context, root string) (*tree, error) {
+	t := newTree()
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := cleanName(rel)
+		if !isDatabase(name) && (info.Mode()&0o111 == 0 || info.Size() < 4) {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+		return t.add(name, info.Mode(), info.Size(), f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,68 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestApplyLayerBinaries(t *testing.T) {
+	// The test binary is a Go binary with build info.
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goBinary, err := os.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		mode       int64
+		content    []byte
+		wantBinary bool
+	}{
+		{name: "go binary", mode: 0o755, content: goBinary, wantBinary: true},
+		{name: "not executable", mode: 0o644, content: goBinary},
+		{name: "script", mode: 0o755, content: []byte("#!/bin/sh\nexit 0\n")},
+		{name: "not a go binary", mode: 0o755, content: append([]byte("\x7fELF"), make([]byte, 64)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			var layer bytes.Buffer
+			tw := tar.NewWriter(&layer)
+			if err := tw.WriteHeader(&tar.Header{Name: "usr/bin/app", Mode: tt.mode, Size: int64(len(tt.content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(tt.content); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			tree := newTree()
+			if err := tree.applyLayer(context.Background(), &layer); err != nil {
+				t.Fatal(err)
+			}
+			info, ok := tree.binaries["usr/bin/app"]
+			if ok != tt.wantBinary {
+				t.Fatalf("found a Go binary = %v, want %v", ok, tt.wantBinary)
+			}
+			if ok && info.GoVersion != runtime.Version() {
+				t.Errorf("GoVersion = %s, want %s", info.GoVersion, runtime.Version())
+			}
+			// Binaries are copied to temporary files, which must be gone.
+			if left, err := os.ReadDir(tmp); err != nil || len(left) > 0 {
+				t.Errorf("temporary files left: %v, %v", left, err)
+			}
+		})
+	}
+}
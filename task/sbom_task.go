@@ -0,0 +1,100 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/results"
+	"github.com/opengovern/og-task-template/task/sbom"
+	"github.com/opengovern/og-util/pkg/jq"
+	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// SbomTaskType is the task type RunSbomTask is registered under.
+const SbomTaskType = "sbom"
+
+// Parameters of an SBOM task.
+const (
+	// SbomParamImagePath is an OCI image layout directory, a tarball of one
+	// or of `docker save` output, or an unpacked root filesystem.
+	SbomParamImagePath = "image_path"
+	// SbomParamImageURL overrides the image URL reported for every image.
+	SbomParamImageURL = "image_url"
+	// SbomParamArtifactID overrides the artifact ID of a root filesystem.
+	SbomParamArtifactID = "artifact_id"
+)
+
+func init() {
+	Register(SbomTaskType, RunSbomTask)
+}
+
+// RunSbomTask catalogs the packages of a local image or root filesystem and
+// emits an ArtifactSbom for each image, followed by an ArtifactSbomDocument
+// with each of its CycloneDX and SPDX documents. It needs no network access.
+func RunSbomTask(ctx context.Context, jq *jq.JobQueue, coreServiceEndpoint string, esClient opengovernance.Client, logger *zap.Logger, request tasks.TaskRequest, response *scheduler.TaskResponse) error {
+	imagePath := stringParam(request, SbomParamImagePath)
+	if imagePath == "" {
+		return fmt.Errorf("the %s parameter is required", SbomParamImagePath)
+	}
+
+	scannedAt := time.Now()
+	artifacts, err := sbom.NewScanner(logger).Scan(ctx, imagePath)
+	if err != nil {
+		return err
+	}
+
+	sender, err := results.NewRunSender(ctx, request, logger)
+	if err != nil {
+		return err
+	}
+	sboms := results.NewEmitter[ArtifactSbom](sender, request)
+	documents := results.NewEmitter[ArtifactSbomDocument](sender, request)
+	var emitErr error
+	for _, artifact := range artifacts {
+		if imageURL := stringParam(request, SbomParamImageURL); imageURL != "" {
+			artifact.ImageURL = imageURL
+		}
+		if artifactID := stringParam(request, SbomParamArtifactID); artifactID != "" && len(artifacts) == 1 {
+			artifact.ArtifactID = artifactID
+		}
+
+		logger.Info("cataloged image", zap.String("image", artifact.ImageURL), zap.String("artifactID", artifact.ArtifactID),
+			zap.String("distro", artifact.Distro.Name), zap.Int("packages", len(artifact.Packages)))
+		if emitErr = emitArtifact(ctx, sboms, documents, artifact, scannedAt); emitErr != nil {
+			break
+		}
+	}
+	return errors.Join(emitErr, sender.Finish())
+}
+
+// emitArtifact emits the ArtifactSbom of artifact and then its documents,
+// dated scannedAt.
+func emitArtifact(ctx context.Context, sboms *results.Emitter[ArtifactSbom], documents *results.Emitter[ArtifactSbomDocument], artifact sbom.Artifact, scannedAt time.Time) error {
+	err := sboms.Emit(ctx, ArtifactSbom{
+		ImageURL:   artifact.ImageURL,
+		ArtifactID: artifact.ArtifactID,
+		Packages:   artifact.Packages,
+	})
+	if err != nil {
+		return err
+	}
+	exported, err := artifactSbomDocuments(artifact, scannedAt)
+	if err != nil {
+		return err
+	}
+	for _, document := range exported {
+		if err := documents.Emit(ctx, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stringParam(request tasks.TaskRequest, name string) string {
+	value, _ := request.TaskDefinition.Params[name].(string)
+	return value
+}
@@ -0,0 +1,169 @@
+package task
+
+import (
+	"encoding/json"
+	"github.com/opengovern/og-task-template/results"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// capturingSender keeps every result sent.
+type capturingSender struct {
+	mu       sync.Mutex
+	results  []*es.TaskResult
+	finished bool
+}
+
+func (s *capturingSender) Send(_ context.Context, resource *es.TaskResult) error {
+	return s.TrySend(resource)
+}
+
+func (s *capturingSender) TrySend(resource *es.TaskResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, resource)
+	return nil
+}
+
+func (s *capturingSender) GetResourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, resource := range s.results {
+		ids = append(ids, resource.ResourceID)
+	}
+	return ids
+}
+
+func (s *capturingSender) Finish() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
+	return nil
+}
+
+// rpmRoot writes a root filesystem holding an rpm database.
+func rpmRoot(t *testing.T) string {
+	t.Helper()
+	db, err := os.ReadFile(filepath.Join("sbom", "testdata", "rpmdb", "rpmdb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	for name, content := range map[string][]byte{
+		"etc/os-release":           []byte("ID=rhel\nVERSION_ID=\"9.3\"\n"),
+		"var/lib/rpm/rpmdb.sqlite": db,
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestRunSbomTask(t *testing.T) {
+	root := rpmRoot(t)
+	tests := []struct {
+		name           string
+		params         map[string]any
+		wantErr        bool
+		wantImageURL   string
+		wantArtifactID string
+	}{
+		{name: "no image path", params: map[string]any{}, wantErr: true},
+		{name: "root filesystem", params: map[string]any{SbomParamImagePath: root}, wantImageURL: root, wantArtifactID: root},
+		{
+			name: "overrides",
+			params: map[string]any{
+				SbomParamImagePath:  root,
+				SbomParamImageURL:   "registry.local/app:1.0",
+				SbomParamArtifactID: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			wantImageURL:   "registry.local/app:1.0",
+			wantArtifactID: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &capturingSender{}
+			ctx := results.WithSenderFactory(context.Background(), func(context.Context, tasks.TaskRequest, *zap.Logger, ...results.ResourceSenderOption) (results.Sender, error) {
+				return sender, nil
+			})
+			request := tasks.TaskRequest{TaskDefinition: tasks.TaskDefinition{RunID: 7, TaskType: SbomTaskType, Params: tt.params}}
+
+			before := time.Now().Truncate(time.Second)
+			err := RunSbomTask(ctx, nil, "", nil, zap.NewNop(), request, &scheduler.TaskResponse{})
+			after := time.Now()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunSbomTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !sender.finished {
+				t.Error("sender was not finished")
+			}
+
+			// The ArtifactSbom comes first, then one small result per
+			// document format; none carries metadata.
+			var types []string
+			for _, result := range sender.results {
+				types = append(types, result.ResultType)
+				if len(result.Metadata) > 0 {
+					t.Errorf("%s result has metadata %v", result.ResultType, result.Metadata)
+				}
+			}
+			wantTypes := []string{ArtifactSbomResourceType, ArtifactSbomDocumentResourceType, ArtifactSbomDocumentResourceType}
+			wantIDs := []string{tt.wantArtifactID, tt.wantArtifactID + "/cyclonedx", tt.wantArtifactID + "/spdx"}
+			if ids := sender.GetResourceIDs(); !reflect.DeepEqual(types, wantTypes) || !reflect.DeepEqual(ids, wantIDs) {
+				t.Fatalf("sent %v %v, want %v %v", types, ids, wantTypes, wantIDs)
+			}
+
+			var sbom ArtifactSbom
+			if err := json.Unmarshal(sender.results[0].Description.(json.RawMessage), &sbom); err != nil {
+				t.Fatal(err)
+			}
+			if sbom.ImageURL != tt.wantImageURL || len(sbom.Packages) != 3 {
+				t.Errorf("ArtifactSbom = %s with %d packages, want %s with 3", sbom.ImageURL, len(sbom.Packages), tt.wantImageURL)
+			}
+			for _, result := range sender.results[1:] {
+				var document ArtifactSbomDocument
+				if err := json.Unmarshal(result.Description.(json.RawMessage), &document); err != nil {
+					t.Fatal(err)
+				}
+				if document.ImageURL != tt.wantImageURL || !strings.Contains(string(document.Document), "openssl-libs") {
+					t.Errorf("%s document of %s does not list the image's packages", document.Format, document.ImageURL)
+				}
+				// Documents are dated when the scan started.
+				var dated struct {
+					Metadata     struct{ Timestamp time.Time }
+					CreationInfo struct{ Created time.Time }
+				}
+				if err := json.Unmarshal(document.Document, &dated); err != nil {
+					t.Fatal(err)
+				}
+				created := dated.Metadata.Timestamp
+				if created.IsZero() {
+					created = dated.CreationInfo.Created
+				}
+				if created.Before(before) || created.After(after) {
+					t.Errorf("%s document is dated %s, want within the run, %s to %s", document.Format, created, before, after)
+				}
+			}
+		})
+	}
+}
@@ -1,13 +1,7 @@
 package task
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"github.com/opengovern/og-util/pkg/es"
-	"github.com/opensearch-project/opensearch-go/v2"
-	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
-	"golang.org/x/net/context"
 	"io/ioutil"
 )
 
@@ -29,31 +23,3 @@ func showFiles(dir string) error {
 	}
 	return nil
 }
-
-func sendDataToOpensearch(client *opensearch.Client, doc es.Doc) error {
-	docJSON, err := json.Marshal(doc)
-	if err != nil {
-		return err
-	}
-
-	keys, index := doc.KeysAndIndex()
-
-	// Use the opensearchapi.IndexRequest to index the document
-	req := opensearchapi.IndexRequest{
-		Index:      index,
-		DocumentID: es.HashOf(keys...),
-		Body:       bytes.NewReader(docJSON),
-		Refresh:    "true", // Makes the document immediately available for search
-	}
-	res, err := req.Do(context.Background(), client)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	// Check the response
-	if res.IsError() {
-		return fmt.Errorf("error indexing document: %s", res.String())
-	}
-	return nil
-}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"github.com/nats-io/nats.go"
+	"github.com/opengovern/og-task-template/config"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+func TestExtractNATS(t *testing.T) {
+	if _, err := Init(context.Background(), config.TracingConfig{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		header      nats.Header
+		wantTraceID string
+	}{
+		{name: "no headers"},
+		{name: "no traceparent", header: nats.Header{"Other": {"x"}}},
+		{name: "canonical key", header: nats.Header{"traceparent": {traceparent}}, wantTraceID: traceID},
+		{name: "other casing", header: nats.Header{"Traceparent": {traceparent}}, wantTraceID: traceID},
+		{name: "invalid traceparent", header: nats.Header{"traceparent": {"00-nope"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ExtractNATS(context.Background(), tt.header)
+			got := ""
+			if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+				got = sc.TraceID().String()
+			}
+			if got != tt.wantTraceID {
+				t.Errorf("extracted trace %q, want %q", got, tt.wantTraceID)
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	if _, err := Init(context.Background(), config.TracingConfig{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ExtractNATS(context.Background(), nats.Header{"traceparent": {traceparent}}))
+	cancel()
+
+	detached := Detach(ctx)
+	if detached.Err() != nil {
+		t.Errorf("detached context is done: %v", detached.Err())
+	}
+	if got := trace.SpanContextFromContext(detached).TraceID().String(); got != traceID {
+		t.Errorf("detached trace = %q, want %q", got, traceID)
+	}
+}
+
+func TestInit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.json")
+	var stdout bytes.Buffer
+
+	tests := []struct {
+		name    string
+		cfg     config.TracingConfig
+		opts    []Option
+		output  func() string
+		wantErr bool
+	}{
+		{name: "none", cfg: config.TracingConfig{Exporter: ExporterNone}},
+		{name: "empty", cfg: config.TracingConfig{}},
+		{
+			name:   "stdout with writer",
+			cfg:    config.TracingConfig{Exporter: ExporterStdout, ServiceName: "test"},
+			opts:   []Option{WithStdout(&stdout)},
+			output: stdout.String,
+		},
+		{
+			name: "file",
+			cfg:  config.TracingConfig{Exporter: ExporterFile, OutputFile: file, ServiceName: "test"},
+			output: func() string {
+				content, _ := os.ReadFile(file)
+				return string(content)
+			},
+		},
+		{name: "file without path", cfg: config.TracingConfig{Exporter: ExporterFile}, wantErr: true},
+		{name: "unknown", cfg: config.TracingConfig{Exporter: "zipkin"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Init(context.Background(), tt.cfg, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			_, span := Tracer().Start(context.Background(), "test.span")
+			span.End()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				t.Fatalf("shutdown() error = %v", err)
+			}
+			if tt.output != nil && !strings.Contains(tt.output(), `"Name":"test.span"`) {
+				t.Errorf("exported spans %q do not contain test.span", tt.output())
+			}
+		})
+	}
+}
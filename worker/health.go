@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// consumerErrorWindow is how long a consume error keeps the worker
+	// unready. The consumer recovers on its own once NATS is reachable again.
+	consumerErrorWindow = time.Minute
+
+	healthCheckTimeout = 5 * time.Second
+)
+
+type healthCheck func(ctx context.Context) error
+
+// jobHeartbeat tracks the last successful InProgress ack of one running job.
+type jobHeartbeat struct {
+	runID    uint
+	lastBeat atomic.Int64
+}
+
+func (hb *jobHeartbeat) beat() {
+	hb.lastBeat.Store(time.Now().UnixNano())
+}
+
+// health holds the state behind /healthz, /readyz and /livez.
+type health struct {
+	logger *zap.Logger
+
+	consumer         atomic.Pointer[jetstream.ConsumeContext]
+	lastConsumeError atomic.Int64
+
+	mu   sync.Mutex
+	jobs map[*jobHeartbeat]struct{}
+}
+
+func newHealth(logger *zap.Logger) *health {
+	return &health{
+		logger: logger,
+		jobs:   make(map[*jobHeartbeat]struct{}),
+	}
+}
+
+func (h *health) setConsumer(consumeCtx jetstream.ConsumeContext) {
+	if consumeCtx == nil {
+		h.consumer.Store(nil)
+		return
+	}
+	h.consumer.Store(&consumeCtx)
+}
+
+func (h *health) consumeError(_ jetstream.ConsumeContext, err error) {
+	h.logger.Warn("consumer reported an error", zap.Error(err))
+	h.lastConsumeError.Store(time.Now().UnixNano())
+}
+
+func (h *health) trackJob(runID uint) *jobHeartbeat {
+	hb := &jobHeartbeat{runID: runID}
+	hb.beat()
+	h.mu.Lock()
+	h.jobs[hb] = struct{}{}
+	h.mu.Unlock()
+	return hb
+}
+
+func (h *health) untrackJob(hb *jobHeartbeat) {
+	h.mu.Lock()
+	delete(h.jobs, hb)
+	h.mu.Unlock()
+}
+
+// checkConsumer fails until the JetStream consumer is running, once it has
+// stopped, and for a while after it reported an error.
+func (h *health) checkConsumer(context.Context) error {
+	consumeCtx := h.consumer.Load()
+	if consumeCtx == nil {
+		return errors.New("consumer is not running")
+	}
+	select {
+	case <-(*consumeCtx).Closed():
+		return errors.New("consumer is stopped")
+	default:
+	}
+	if last := h.lastConsumeError.Load(); last != 0 {
+		if since := time.Since(time.Unix(0, last)); since < consumerErrorWindow {
+			return fmt.Errorf("consumer reported an error %s ago", since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// checkHeartbeats fails if any running job has not managed to extend its ack
+// deadline within timeout.
+func (h *health) checkHeartbeats(timeout time.Duration) healthCheck {
+	return func(context.Context) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		var stalled []uint
+		for hb := range h.jobs {
+			if time.Since(time.Unix(0, hb.lastBeat.Load())) > timeout {
+				stalled = append(stalled, hb.runID)
+			}
+		}
+		if len(stalled) > 0 {
+			sort.Slice(stalled, func(i, j int) bool { return stalled[i] < stalled[j] })
+			return fmt.Errorf("heartbeat stalled for more than %s on runs %v", timeout, stalled)
+		}
+		return nil
+	}
+}
+
+// handler runs every check and answers 200 if all pass, 503 otherwise.
+func (h *health) handler(checks map[string]healthCheck) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		status := http.StatusOK
+		result := make(map[string]string, len(checks))
+		for name, check := range checks {
+			if err := check(ctx); err != nil {
+				status = http.StatusServiceUnavailable
+				result[name] = err.Error()
+			} else {
+				result[name] = "ok"
+			}
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_ = json.NewEncoder(rw).Encode(map[string]any{
+			"status": http.StatusText(status),
+			"checks": result,
+		})
+	}
+}
+
+// checkES pings the ES cluster the tasks read from.
+func (w *Worker) checkES(ctx context.Context) error {
+	client := w.esClient.ES()
+	res, err := client.Ping(client.Ping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("ping failed: %s", res.Status())
+	}
+	return nil
+}
+
+// startHealthServer serves the health and metrics endpoints on the configured
+// address until the returned stop function is called. It does nothing when no
+// address is configured.
+func (w *Worker) startHealthServer() (stop func()) {
+	if w.cfg.Health.Address == "" {
+		return func() {}
+	}
+
+	heartbeats := w.health.checkHeartbeats(w.cfg.Health.HeartbeatTimeout)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", w.health.handler(map[string]healthCheck{
+		"consumer":   w.health.checkConsumer,
+		"es":         w.checkES,
+		"heartbeats": heartbeats,
+	}))
+	mux.Handle("/readyz", w.health.handler(map[string]healthCheck{
+		"consumer": w.health.checkConsumer,
+	}))
+	mux.Handle("/livez", w.health.handler(map[string]healthCheck{
+		"heartbeats": heartbeats,
+	}))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              w.cfg.Health.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: healthCheckTimeout,
+	}
+	go func() {
+		w.logger.Info("serving health and metrics endpoints", zap.String("address", server.Addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.logger.Error("health server stopped", zap.Error(err))
+		}
+	}()
+	return func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		defer shutdownCancel()
+		_ = server.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConsumeContext is a ConsumeContext that is closed when closed is.
+type fakeConsumeContext struct {
+	jetstream.ConsumeContext
+	closed chan struct{}
+}
+
+func (c *fakeConsumeContext) Closed() <-chan struct{} { return c.closed }
+
+func TestCheckConsumer(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+
+	tests := []struct {
+		name           string
+		consumer       jetstream.ConsumeContext
+		lastError      time.Duration
+		wantErrContain string
+	}{
+		{name: "not started", wantErrContain: "not running"},
+		{name: "running", consumer: &fakeConsumeContext{closed: make(chan struct{})}},
+		{name: "stopped", consumer: &fakeConsumeContext{closed: stopped}, wantErrContain: "stopped"},
+		{name: "recent error", consumer: &fakeConsumeContext{closed: make(chan struct{})}, lastError: time.Second, wantErrContain: "reported an error"},
+		{name: "old error", consumer: &fakeConsumeContext{closed: make(chan struct{})}, lastError: 2 * consumerErrorWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealth(zap.NewNop())
+			h.setConsumer(tt.consumer)
+			if tt.lastError != 0 {
+				h.lastConsumeError.Store(time.Now().Add(-tt.lastError).UnixNano())
+			}
+
+			err := h.checkConsumer(context.Background())
+			if tt.wantErrContain == "" && err != nil || tt.wantErrContain != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErrContain)) {
+				t.Errorf("checkConsumer() = %v, want an error containing %q", err, tt.wantErrContain)
+			}
+		})
+	}
+}
+
+func TestCheckHeartbeats(t *testing.T) {
+	tests := []struct {
+		name     string
+		beatAges map[uint]time.Duration
+		wantErr  string
+	}{
+		{name: "no jobs"},
+		{name: "fresh jobs", beatAges: map[uint]time.Duration{1: 0, 2: time.Second}},
+		{name: "stalled jobs are listed in order", beatAges: map[uint]time.Duration{3: time.Hour, 1: time.Hour, 2: 0}, wantErr: "on runs [1 3]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealth(zap.NewNop())
+			for runID, age := range tt.beatAges {
+				hb := h.trackJob(runID)
+				hb.lastBeat.Store(time.Now().Add(-age).UnixNano())
+			}
+
+			err := h.checkHeartbeats(time.Minute)(context.Background())
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkHeartbeats() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	h := newHealth(zap.NewNop())
+	hb := h.trackJob(1)
+	hb.lastBeat.Store(time.Now().Add(-time.Hour).UnixNano())
+	h.untrackJob(hb)
+	if err := h.checkHeartbeats(time.Minute)(context.Background()); err != nil {
+		t.Errorf("checkHeartbeats() after untrackJob = %v", err)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("down") }
+
+	tests := []struct {
+		name       string
+		checks     map[string]healthCheck
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{name: "no checks", checks: map[string]healthCheck{}, wantStatus: http.StatusOK, wantChecks: map[string]string{}},
+		{name: "all pass", checks: map[string]healthCheck{"a": pass, "b": pass}, wantStatus: http.StatusOK, wantChecks: map[string]string{"a": "ok", "b": "ok"}},
+		{name: "one fails", checks: map[string]healthCheck{"a": pass, "b": fail}, wantStatus: http.StatusServiceUnavailable, wantChecks: map[string]string{"a": "ok", "b": "down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			newHealth(zap.NewNop()).handler(tt.checks)(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var body struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Code != tt.wantStatus || body.Status != http.StatusText(tt.wantStatus) {
+				t.Errorf("status = %d %q, want %d", recorder.Code, body.Status, tt.wantStatus)
+			}
+			for name, want := range tt.wantChecks {
+				if body.Checks[name] != want {
+					t.Errorf("check %s = %q, want %q", name, body.Checks[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/results"
+	"github.com/opengovern/og-task-template/task"
+	"github.com/opengovern/og-task-template/task/sbom"
+	"github.com/opengovern/og-task-template/tracing"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/jq"
+	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/db/models"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RunLocalCommand runs a single task request from a file without NATS, for
+// developing tasks. Every emitted es.TaskResult and then the final
+// TaskResponse are written as NDJSON; logs and stdout traces go to stderr.
+func RunLocalCommand() *cobra.Command {
+	var (
+		requestFile string
+		outputFile  string
+		esDir       string
+		sbomDir     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "run-local",
+		Short: "Run a task from a TaskRequest JSON file without NATS",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			logger, err := zap.NewDevelopment()
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Load(cmd.Flags())
+			if err != nil {
+				return err
+			}
+
+			out := io.Writer(os.Stdout)
+			if outputFile != "" {
+				f, err := os.Create(outputFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+
+			shutdownTracing, err := tracing.Init(cmd.Context(), cfg.Tracing, tracing.WithStdout(os.Stderr))
+			if err != nil {
+				return err
+			}
+			defer flushTracing(shutdownTracing, logger)
+
+			return RunLocal(cmd.Context(), logger, cfg, requestFile, esDir, sbomDir, out)
+		},
+	}
+
+	cmd.Flags().StringVar(&requestFile, "request", "", "Path to a tasks.TaskRequest JSON file")
+	cmd.Flags().StringVar(&outputFile, "output", "", "Write NDJSON output to this file instead of stdout")
+	cmd.Flags().StringVar(&esDir, "es-dir", "", "Serve ES searches from <es-dir>/<index>.json; without it every search has no hits")
+	cmd.Flags().StringVar(&sbomDir, "sbom-dir", "", "Also write the SBOM documents attached to results to this directory")
+	_ = cmd.MarkFlagRequired("request")
+
+	return cmd
+}
+
+// RunLocal runs the request in requestFile through the task registry and
+// writes its results and final response to out. The task's ES client talks to
+// a local server answering searches from esDir, or with no hits when esDir is
+// empty, and discarding writes. The task gets a job queue only when
+// cfg.Nats.URL is set; cfg is otherwise not validated. When sbomDir is set,
+// the SBOM documents of results are written there as files.
+func RunLocal(ctx context.Context, logger *zap.Logger, cfg config.Config, requestFile string, esDir string, sbomDir string, out io.Writer) error {
+	content, err := os.ReadFile(requestFile)
+	if err != nil {
+		return err
+	}
+	var request tasks.TaskRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return fmt.Errorf("failed to parse task request %s: %w", requestFile, err)
+	}
+
+	runID := request.TaskDefinition.RunID
+	runLogger := logger.With(zap.Uint("runID", runID), zap.String("taskType", request.TaskDefinition.TaskType))
+
+	server, url, err := startLocalESServer(esDir, runLogger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer closeCancel()
+		_ = server.Close(closeCtx)
+	}()
+
+	empty, isOnAks, isOpenSearch := "", false, true
+	esClient, err := opengovernance.NewClient(opengovernance.ClientConfig{
+		Addresses:     []string{url},
+		Username:      &empty,
+		Password:      &empty,
+		IsOnAks:       &isOnAks,
+		IsOpenSearch:  &isOpenSearch,
+		AwsRegion:     &empty,
+		AssumeRoleArn: &empty,
+	})
+	if err != nil {
+		return err
+	}
+	runLogger.Info("Using local ES client", zap.String("dir", esDir), zap.String("url", url))
+
+	var queue *jq.JobQueue
+	if cfg.Nats.URL != "" {
+		if queue, err = jq.New(cfg.Nats.URL, runLogger); err != nil {
+			return fmt.Errorf("failed to connect to NATS at %s: %w", cfg.Nats.URL, err)
+		}
+		defer queue.Close()
+		runLogger.Info("Using NATS job queue", zap.String("url", cfg.Nats.URL))
+	} else {
+		runLogger.Warn("No --nats-url set, the task gets no job queue")
+	}
+
+	var sender results.Sender = results.NewNDJSONSender(out, runLogger)
+	if sbomDir != "" {
+		if err := os.MkdirAll(sbomDir, 0o755); err != nil {
+			return err
+		}
+		sender = &sbomFileSender{Sender: sender, dir: sbomDir}
+	}
+	// Interrupting a local run is a cancellation request, not a shutdown.
+	runCtx, cancel := context.WithCancelCause(results.WithSenderFactory(context.WithoutCancel(ctx), func(context.Context, tasks.TaskRequest, *zap.Logger, ...results.ResourceSenderOption) (results.Sender, error) {
+		return sender, nil
+	}))
+	defer cancel(nil)
+	stop := context.AfterFunc(ctx, func() { cancel(errCancelRequested) })
+	defer stop()
+
+	response := &scheduler.TaskResponse{
+		RunID:  runID,
+		Status: models.TaskRunStatusInProgress,
+	}
+
+	runLogger.Info("Starting task execution")
+	execCtx, execSpan := tracing.Tracer().Start(runCtx, "task.execute")
+	runErr := task.DefaultRegistry.Run(execCtx, queue, cfg.InventoryServiceEndpoint, esClient, runLogger, request, response)
+	execSpan.End()
+	response.Status, response.FailureMessage = taskOutcome(runCtx, runErr, runLogger)
+
+	if err := json.NewEncoder(out).Encode(response); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return fmt.Errorf("task run %d ended with status %s: %w", runID, response.Status, runErr)
+	}
+	return nil
+}
+
+// unsafeFileNameChars are replaced in the resource IDs SBOM files are named
+// after.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sbomFileSender writes the document of every ArtifactSbomDocument result to
+// dir, named after its artifact, before sending the result.
+type sbomFileSender struct {
+	results.Sender
+	dir string
+}
+
+func (s *sbomFileSender) Send(ctx context.Context, resource *es.TaskResult) error {
+	if err := s.write(resource); err != nil {
+		return err
+	}
+	return s.Sender.Send(ctx, resource)
+}
+
+func (s *sbomFileSender) TrySend(resource *es.TaskResult) error {
+	if err := s.write(resource); err != nil {
+		return err
+	}
+	return s.Sender.TrySend(resource)
+}
+
+func (s *sbomFileSender) write(resource *es.TaskResult) error {
+	if resource == nil || resource.ResultType != task.ArtifactSbomDocumentResourceType {
+		return nil
+	}
+	// The description is whatever the task set, usually the emitter's
+	// json.RawMessage.
+	description, err := json.Marshal(resource.Description)
+	if err != nil {
+		return fmt.Errorf("failed to encode SBOM document %s: %w", resource.ResourceID, err)
+	}
+	var document task.ArtifactSbomDocument
+	if err := json.Unmarshal(description, &document); err != nil {
+		return fmt.Errorf("failed to decode SBOM document %s: %w", resource.ResourceID, err)
+	}
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(document.ArtifactID, "_"), "_")
+	if name == "" {
+		name = "sbom"
+	}
+	for _, format := range sbom.Formats {
+		if format.Name != document.Format {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, name+format.Extension), document.Document, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s SBOM of %s: %w", format.Name, document.ArtifactID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localESServer answers the small part of the OpenSearch API tasks use, backed
+// by a directory instead of a cluster. A search on an index returns the
+// contents of <dir>/<index>.json, which must hold a raw search response, or
+// no hits when that file or dir does not exist. Writes are accepted and
+// discarded.
+type localESServer struct {
+	dir    string
+	logger *zap.Logger
+	server *http.Server
+}
+
+// startLocalESServer serves dir on a loopback port and returns the server
+// and its URL.
+func startLocalESServer(dir string, logger *zap.Logger) (*localESServer, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	s := &localESServer{
+		dir:    dir,
+		logger: logger,
+	}
+	s.server = &http.Server{Handler: s}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("local ES server stopped", zap.Error(err))
+		}
+	}()
+	return s, fmt.Sprintf("http://%s", listener.Addr().String()), nil
+}
+
+// noHits is the search response for an index with no file.
+const noHits = `{"took":0,"timed_out":false,"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+
+func (s *localESServer) Close(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *localESServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	w.Header().Set("Content-Type", "application/json")
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		_, _ = io.WriteString(w, `{"version":{"distribution":"opensearch","number":"2.11.0"}}`)
+	case len(segments) == 2 && segments[1] == "_search":
+		s.search(w, segments[0])
+	case len(segments) == 2 && segments[1] == "_count":
+		_, _ = io.WriteString(w, `{"count":0}`)
+	default:
+		s.logger.Debug("local ES server ignoring request", zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		_, _ = io.WriteString(w, `{"acknowledged":true,"errors":false,"items":[]}`)
+	}
+}
+
+func (s *localESServer) search(w http.ResponseWriter, index string) {
+	if s.dir == "" {
+		_, _ = io.WriteString(w, noHits)
+		return
+	}
+	name := filepath.Base(filepath.Clean("/" + index))
+	content, err := os.ReadFile(filepath.Join(s.dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		_, _ = io.WriteString(w, noHits)
+		return
+	}
+	if err != nil || !json.Valid(content) {
+		s.logger.Error("failed to read local search response", zap.String("index", index), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, `{"error":{"type":"local_es_error","reason":"invalid search response file"},"status":500}`)
+		return
+	}
+	_, _ = w.Write(content)
+}
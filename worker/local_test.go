@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/results"
+	"github.com/opengovern/og-task-template/task"
+	"github.com/opengovern/og-util/pkg/es"
+	"github.com/opengovern/og-util/pkg/jq"
+	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
+	"github.com/opengovern/og-util/pkg/tasks"
+	"github.com/opengovern/opensecurity/services/tasks/db/models"
+	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalESServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "assets.json"), []byte(`{"hits":{"hits":[{"_id":"1"}]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"hits":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		dir        string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "info", dir: dir, method: http.MethodGet, path: "/", wantStatus: http.StatusOK, wantBody: `"distribution":"opensearch"`},
+		{name: "search from file", dir: dir, method: http.MethodPost, path: "/assets/_search", wantStatus: http.StatusOK, wantBody: `"_id":"1"`},
+		{name: "search without file", dir: dir, method: http.MethodPost, path: "/missing/_search", wantStatus: http.StatusOK, wantBody: noHits},
+		{name: "search without dir", dir: "", method: http.MethodPost, path: "/assets/_search", wantStatus: http.StatusOK, wantBody: noHits},
+		{name: "invalid file", dir: dir, method: http.MethodPost, path: "/broken/_search", wantStatus: http.StatusInternalServerError, wantBody: "local_es_error"},
+		{name: "count", dir: dir, method: http.MethodGet, path: "/assets/_count", wantStatus: http.StatusOK, wantBody: `{"count":0}`},
+		{name: "writes are acknowledged", dir: dir, method: http.MethodPost, path: "/_bulk", wantStatus: http.StatusOK, wantBody: `"errors":false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &localESServer{dir: tt.dir, logger: zap.NewNop()}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+			if recorder.Code != tt.wantStatus || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("%s %s = %d %s, want %d containing %s", tt.method, tt.path, recorder.Code, recorder.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRunLocal(t *testing.T) {
+	boom := errors.New("boom")
+	handlers := map[string]task.Handler{
+		"run-local-test-ok": func(ctx context.Context, _ *jq.JobQueue, _ string, _ opengovernance.Client, logger *zap.Logger, request tasks.TaskRequest, _ *scheduler.TaskResponse) error {
+			sender, err := results.NewRunSender(ctx, request, logger)
+			if err != nil {
+				return err
+			}
+			for _, id := range []string{"a", "b"} {
+				if err := sender.Send(ctx, &es.TaskResult{ResourceID: id, ResultType: "test"}); err != nil {
+					return err
+				}
+			}
+			return sender.Finish()
+		},
+		"run-local-test-fail": func(context.Context, *jq.JobQueue, string, opengovernance.Client, *zap.Logger, tasks.TaskRequest, *scheduler.TaskResponse) error {
+			return boom
+		},
+		"run-local-test-wait": func(ctx context.Context, _ *jq.JobQueue, _ string, _ opengovernance.Client, _ *zap.Logger, _ tasks.TaskRequest, _ *scheduler.TaskResponse) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	for taskType, handler := range handlers {
+		// Ignore the error of a second registration under -count.
+		_ = task.DefaultRegistry.Register(taskType, handler)
+	}
+
+	tests := []struct {
+		taskType    string
+		cancel      bool
+		wantErr     bool
+		wantResults []string
+		wantStatus  models.TaskRunStatus
+	}{
+		{taskType: "run-local-test-ok", wantResults: []string{"a", "b"}, wantStatus: models.TaskRunStatusFinished},
+		{taskType: "run-local-test-fail", wantErr: true, wantStatus: models.TaskRunStatusFailed},
+		{taskType: "run-local-test-wait", cancel: true, wantErr: true, wantStatus: models.TaskRunStatusCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskType, func(t *testing.T) {
+			requestFile := filepath.Join(t.TempDir(), "request.json")
+			request, err := json.Marshal(tasks.TaskRequest{TaskDefinition: tasks.TaskDefinition{RunID: 7, TaskType: tt.taskType}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(requestFile, request, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			var out bytes.Buffer
+			err = RunLocal(ctx, zap.NewNop(), config.Default(), requestFile, "", "", &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunLocal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var lines []string
+			scanner := bufio.NewScanner(&out)
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			if len(lines) != len(tt.wantResults)+1 {
+				t.Fatalf("got %d output lines, want %d results and the response:\n%s", len(lines), len(tt.wantResults), out.String())
+			}
+			for i, id := range tt.wantResults {
+				var result es.TaskResult
+				if err := json.Unmarshal([]byte(lines[i]), &result); err != nil || result.ResourceID != id {
+					t.Errorf("line %d = %s, want result %s", i, lines[i], id)
+				}
+			}
+			var response scheduler.TaskResponse
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.RunID != 7 || response.Status != tt.wantStatus {
+				t.Errorf("response = %+v, want run 7 with status %s", response, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSbomFileSender(t *testing.T) {
+	document := func(artifactID, format, content string) *es.TaskResult {
+		description, err := json.Marshal(task.ArtifactSbomDocument{ArtifactID: artifactID, Format: format, Document: json.RawMessage(content)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &es.TaskResult{ResourceID: artifactID + "/" + format, ResultType: task.ArtifactSbomDocumentResourceType, Description: json.RawMessage(description)}
+	}
+
+	tests := []struct {
+		name      string
+		result    *es.TaskResult
+		wantFiles map[string]string
+		wantErr   bool
+	}{
+		{name: "cyclonedx", result: document("sha256:abc", "cyclonedx", `{"bomFormat":"CycloneDX"}`), wantFiles: map[string]string{"sha256_abc.cdx.json": `{"bomFormat":"CycloneDX"}`}},
+		{name: "spdx", result: document("sha256:abc", "spdx", `{"spdxVersion":"SPDX-2.3"}`), wantFiles: map[string]string{"sha256_abc.spdx.json": `{"spdxVersion":"SPDX-2.3"}`}},
+		{name: "root filesystem", result: document("/srv/rootfs", "spdx", `{}`), wantFiles: map[string]string{"srv_rootfs.spdx.json": `{}`}},
+		{name: "unknown format", result: document("sha256:abc", "swid", `{}`), wantFiles: map[string]string{}},
+		{name: "other result type", result: &es.TaskResult{ResourceID: "sha256:abc", ResultType: task.ArtifactSbomResourceType, Description: json.RawMessage(`{}`)}, wantFiles: map[string]string{}},
+		{name: "undecodable document", result: &es.TaskResult{ResourceID: "x", ResultType: task.ArtifactSbomDocumentResourceType, Description: "not a document"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sender := &sbomFileSender{Sender: results.NewNDJSONSender(&bytes.Buffer{}, zap.NewNop()), dir: dir}
+			if err := sender.Send(context.Background(), tt.result); (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{}
+			for _, entry := range entries {
+				content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[entry.Name()] = string(content)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("wrote %v, want %v", files, tt.wantFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/opengovern/og-task-template/metrics"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outboxRetryBase = 5 * time.Second
+	outboxRetryMax  = 5 * time.Minute
+
+	outboxPublishTimeout = 30 * time.Second
+)
+
+// outboxEntry is one message waiting to be published, stored as a JSON file.
+type outboxEntry struct {
+	MsgID    string    `json:"msg_id"`
+	Subject  string    `json:"subject"`
+	Payload  []byte    `json:"payload"`
+	StoredAt time.Time `json:"stored_at"`
+}
+
+// producer publishes a message to JetStream. *jq.JobQueue implements it.
+type producer interface {
+	Produce(ctx context.Context, topic string, data []byte, id string) (*jetstream.PubAck, error)
+}
+
+// Outbox persists messages to a directory before publishing them, so a
+// final TaskResponse survives NATS outages and worker restarts. Entries that
+// fail to publish are retried in the background with the same message ID,
+// which lets JetStream drop duplicates.
+type Outbox struct {
+	dir    string
+	jq     producer
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+func NewOutbox(dir string, jq producer, logger *zap.Logger) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Outbox{
+		dir:    dir,
+		jq:     jq,
+		logger: logger.With(zap.String("outbox", dir)),
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Publish stores the message and then tries to publish it once. On error the
+// message stays in the outbox and is retried by Run.
+func (o *Outbox) Publish(ctx context.Context, subject string, payload []byte, msgID string) error {
+	entry := outboxEntry{
+		MsgID:    msgID,
+		Subject:  subject,
+		Payload:  payload,
+		StoredAt: time.Now().UTC(),
+	}
+	if err := o.store(entry); err != nil {
+		o.logger.Error("failed to store message in the outbox", zap.String("msgId", msgID), zap.Error(err))
+		// Still try to publish, there is just no durable copy.
+		_, pubErr := o.jq.Produce(ctx, subject, payload, msgID)
+		return errors.Join(err, pubErr)
+	}
+
+	if err := o.publish(ctx, entry); err != nil {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+		return err
+	}
+	return nil
+}
+
+// Run retries stored messages until ctx is cancelled, starting with the ones
+// left over from a previous run.
+func (o *Outbox) Run(ctx context.Context) {
+	delay := outboxRetryBase
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+
+		pending := o.flush(ctx)
+		if pending == 0 {
+			delay = outboxRetryBase
+			// Nothing to retry until the next failed Publish.
+			timer.Stop()
+			continue
+		}
+
+		o.logger.Warn("messages still pending in the outbox", zap.Int("pending", pending), zap.Duration("retryIn", delay))
+		timer.Reset(delay)
+		delay = min(delay*2, outboxRetryMax)
+	}
+}
+
+// flush publishes every stored message and returns how many are left.
+func (o *Outbox) flush(ctx context.Context) int {
+	entries, err := o.load()
+	if err != nil {
+		o.logger.Error("failed to read the outbox", zap.Error(err))
+		return 1
+	}
+
+	pending := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return len(entries)
+		}
+		if err := o.publish(ctx, entry); err != nil {
+			o.logger.Warn("failed to publish message from the outbox", zap.String("msgId", entry.MsgID), zap.Error(err))
+			pending++
+			continue
+		}
+		o.logger.Info("published message from the outbox", zap.String("msgId", entry.MsgID),
+			zap.Duration("delay", time.Since(entry.StoredAt)))
+	}
+	metrics.OutboxPending.Set(float64(pending))
+	return pending
+}
+
+func (o *Outbox) publish(ctx context.Context, entry outboxEntry) error {
+	produceCtx, produceCancel := context.WithTimeout(ctx, outboxPublishTimeout)
+	defer produceCancel()
+
+	if _, err := o.jq.Produce(produceCtx, entry.Subject, entry.Payload, entry.MsgID); err != nil {
+		return err
+	}
+	if err := os.Remove(o.path(entry.MsgID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		o.logger.Error("failed to remove published message from the outbox", zap.String("msgId", entry.MsgID), zap.Error(err))
+	}
+	return nil
+}
+
+func (o *Outbox) path(msgID string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msgID)
+	return filepath.Join(o.dir, name+".json")
+}
+
+// store writes entry to a temporary file and renames it into place, so a
+// crash never leaves a partial entry behind.
+func (o *Outbox) store(entry outboxEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	f, err := os.CreateTemp(o.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), o.path(entry.MsgID))
+}
+
+// load returns the stored entries, oldest first.
+func (o *Outbox) load() ([]outboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []outboxEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(o.dir, file.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var entry outboxEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			o.logger.Error("dropping unreadable outbox entry", zap.String("file", file.Name()), zap.Error(err))
+			_ = os.Remove(filepath.Join(o.dir, file.Name()))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StoredAt.Before(entries[j].StoredAt)
+	})
+	return entries, nil
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProducer fails the first failures calls and records the message IDs
+// of the successful ones.
+type fakeProducer struct {
+	mu        sync.Mutex
+	failures  int
+	published []string
+}
+
+func (p *fakeProducer) Produce(_ context.Context, _ string, _ []byte, id string) (*jetstream.PubAck, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("nats unavailable")
+	}
+	p.published = append(p.published, id)
+	return &jetstream.PubAck{}, nil
+}
+
+func (p *fakeProducer) ids() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.published...)
+}
+
+func pendingFiles(t *testing.T, dir string) int {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func TestOutboxPublish(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		wantErr       bool
+		wantPending   int
+		wantPublished []string
+	}{
+		{name: "published right away", wantPending: 0, wantPublished: []string{"task-run-result-1"}},
+		{name: "kept when publishing fails", failures: 1, wantErr: true, wantPending: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			producer := &fakeProducer{failures: tt.failures}
+			outbox, err := NewOutbox(dir, producer, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = outbox.Publish(context.Background(), "results", []byte(`{"run_id":1}`), "task-run-result-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := pendingFiles(t, dir); got != tt.wantPending {
+				t.Errorf("%d messages pending, want %d", got, tt.wantPending)
+			}
+			if got := producer.ids(); !reflect.DeepEqual(got, tt.wantPublished) {
+				t.Errorf("published %v, want %v", got, tt.wantPublished)
+			}
+		})
+	}
+}
+
+func TestOutboxFlush(t *testing.T) {
+	dir := t.TempDir()
+	producer := &fakeProducer{failures: 100}
+	outbox, err := NewOutbox(dir, producer, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored out of order, and with a message ID that is not a safe file name.
+	base := time.Now()
+	for i, id := range []string{"b", "a/../c", "a"} {
+		entry := outboxEntry{MsgID: id, Subject: "results", Payload: []byte("{}"), StoredAt: base.Add(time.Duration(2-i) * time.Second)}
+		if err := outbox.store(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := outbox.flush(context.Background()); pending != 3 {
+		t.Fatalf("flush() with NATS down left %d pending, want 3", pending)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unreadable entry was not dropped: %v", err)
+	}
+
+	producer.failures = 0
+	if pending := outbox.flush(context.Background()); pending != 0 {
+		t.Fatalf("flush() left %d pending, want 0", pending)
+	}
+	if got, want := producer.ids(), []string{"a", "a/../c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want oldest first %v", got, want)
+	}
+	if got := pendingFiles(t, dir); got != 0 {
+		t.Errorf("%d files left in the outbox", got)
+	}
+}
+
+func TestOutboxRunRetries(t *testing.T) {
+	dir := t.TempDir()
+	producer := &fakeProducer{failures: 1}
+	outbox, err := NewOutbox(dir, producer, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := outbox.Publish(ctx, "results", []byte("{}"), "task-run-result-2"); err == nil {
+		t.Fatal("Publish() succeeded with NATS down")
+	}
+	go outbox.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pendingFiles(t, dir) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not publish the pending message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := producer.ids(); !reflect.DeepEqual(got, []string{"task-run-result-2"}) {
+		t.Errorf("published %v", got)
+	}
+}
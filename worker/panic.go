@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// maxPanicStackLines bounds the stack kept in a PanicError, two lines per
+// frame, so failure messages stay readable.
+const maxPanicStackLines = 30
+
+// PanicError is the error a job fails with when its task panics.
+type PanicError struct {
+	Value any
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// newPanicError captures the stack of the panicking goroutine. It must be
+// called from the deferred function that recovered value.
+func newPanicError(value any) *PanicError {
+	return &PanicError{
+		Value: value,
+		Stack: trimStack(string(debug.Stack())),
+	}
+}
+
+// trimStack drops the goroutine header and the frames of the recovery itself,
+// so the stack starts at the code that panicked, and caps its length.
+func trimStack(stack string) string {
+	lines := strings.Split(strings.TrimSpace(stack), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			// Skip the panic frame and its file:line.
+			lines = lines[min(i+2, len(lines)):]
+			break
+		}
+	}
+	if len(lines) > maxPanicStackLines {
+		lines = append(lines[:maxPanicStackLines], "\t...")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTrimStack(t *testing.T) {
+	frames := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "main.f%d()\n\t/src/main.go:%d +0x1\n", i, i)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name      string
+		stack     string
+		wantFirst string
+		wantLines int
+	}{
+		{
+			name:      "skips the recovery frames",
+			stack:     "goroutine 1 [running]:\nruntime/debug.Stack()\n\t/go/debug.go:1\npanic({0x1, 0x2})\n\t/go/panic.go:2\n" + frames(2),
+			wantFirst: "main.f0()",
+			wantLines: 4,
+		},
+		{
+			name:      "keeps stacks without a panic frame",
+			stack:     "goroutine 1 [running]:\n" + frames(1),
+			wantFirst: "goroutine 1 [running]:",
+			wantLines: 3,
+		},
+		{
+			name:      "caps long stacks",
+			stack:     "panic({0x1, 0x2})\n\t/go/panic.go:2\n" + frames(100),
+			wantFirst: "main.f0()",
+			wantLines: maxPanicStackLines + 1,
+		},
+		{
+			name:      "panic frame last",
+			stack:     "goroutine 1 [running]:\npanic({0x1, 0x2})",
+			wantLines: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(trimStack(tt.stack), "\n")
+			if lines[0] != tt.wantFirst || len(lines) != tt.wantLines {
+				t.Errorf("trimStack() starts with %q and has %d lines, want %q and %d", lines[0], len(lines), tt.wantFirst, tt.wantLines)
+			}
+		})
+	}
+}
+
+func panickingTask() {
+	panic("boom")
+}
+
+func TestNewPanicError(t *testing.T) {
+	var panicErr *PanicError
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicErr = newPanicError(r)
+			}
+		}()
+		panickingTask()
+	}()
+
+	if panicErr == nil {
+		t.Fatal("nothing was recovered")
+	}
+	if panicErr.Value != "boom" {
+		t.Errorf("Value = %v, want boom", panicErr.Value)
+	}
+	if !strings.HasPrefix(panicErr.Stack, "github.com/opengovern/og-task-template/worker.panickingTask") {
+		t.Errorf("Stack does not start at the panicking function:\n%s", panicErr.Stack)
+	}
+	if !strings.HasPrefix(panicErr.Error(), "task panicked: boom\n") {
+		t.Errorf("Error() = %q", panicErr.Error())
+	}
+}
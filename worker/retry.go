@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	RetryBackoffBase = 5 * time.Second
+	RetryBackoffMax  = 5 * time.Minute
+)
+
+// terminalError marks an error that will fail the same way on every
+// redelivery, such as a payload that cannot be decoded.
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string { return e.err.Error() }
+func (e *terminalError) Unwrap() error { return e.err }
+
+// Terminal marks err as not worth retrying.
+func Terminal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &terminalError{err: err}
+}
+
+// IsTerminal reports whether err, or any error it wraps, was marked Terminal.
+func IsTerminal(err error) bool {
+	var t *terminalError
+	return errors.As(err, &t)
+}
+
+// taskRunError wraps an error returned by the task itself. The task outcome
+// has already been reported through the final TaskResponse, so the message
+// is acked instead of retried.
+type taskRunError struct {
+	err error
+}
+
+func (e *taskRunError) Error() string { return e.err.Error() }
+func (e *taskRunError) Unwrap() error { return e.err }
+
+// DeadLetter is published to the dead-letter subject for jobs that failed
+// terminally or ran out of delivery attempts.
+type DeadLetter struct {
+	Subject      string    `json:"subject"`
+	Payload      []byte    `json:"payload"`
+	Error        string    `json:"error"`
+	Terminal     bool      `json:"terminal"`
+	NumDelivered uint64    `json:"num_delivered"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+// retryBackoff returns the redelivery delay for the given delivery attempt,
+// doubling from RetryBackoffBase up to RetryBackoffMax.
+func retryBackoff(numDelivered uint64) time.Duration {
+	delay := RetryBackoffBase
+	for i := uint64(1); i < numDelivered; i++ {
+		delay *= 2
+		if delay >= RetryBackoffMax {
+			return RetryBackoffMax
+		}
+	}
+	return delay
+}
+
+// settle acks, naks or dead-letters msg depending on the outcome of
+// ProcessMessage.
+func (w *Worker) settle(msg jetstream.Msg, err error) {
+	var taskErr *taskRunError
+	if err == nil || errors.As(err, &taskErr) {
+		if ackErr := msg.Ack(); ackErr != nil {
+			w.logger.Error("failed to send the ack message", zap.Error(ackErr))
+		}
+		return
+	}
+
+	var numDelivered uint64 = 1
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		numDelivered = meta.NumDelivered
+	} else {
+		w.logger.Error("failed to read message metadata", zap.Error(metaErr))
+	}
+
+	terminal := IsTerminal(err)
+	if !terminal && numDelivered < uint64(w.cfg.Worker.MaxDeliver) {
+		delay := retryBackoff(numDelivered)
+		w.logger.Warn("job failed with a retryable error, scheduling redelivery", zap.Error(err),
+			zap.Uint64("numDelivered", numDelivered), zap.Duration("delay", delay))
+		if nakErr := msg.NakWithDelay(delay); nakErr != nil {
+			w.logger.Error("failed to send the nak message", zap.Error(nakErr))
+		}
+		return
+	}
+
+	if dlErr := w.deadLetter(msg, err, terminal, numDelivered); dlErr != nil {
+		// Keep the job in the queue rather than losing it.
+		w.logger.Error("failed to publish to the dead-letter subject", zap.Error(dlErr))
+		if nakErr := msg.NakWithDelay(RetryBackoffMax); nakErr != nil {
+			w.logger.Error("failed to send the nak message", zap.Error(nakErr))
+		}
+		return
+	}
+
+	if termErr := msg.Term(); termErr != nil {
+		w.logger.Error("failed to send the term message", zap.Error(termErr))
+	}
+}
+
+func (w *Worker) deadLetter(msg jetstream.Msg, cause error, terminal bool, numDelivered uint64) error {
+	dl := DeadLetter{
+		Subject:      msg.Subject(),
+		Payload:      msg.Data(),
+		Error:        cause.Error(),
+		Terminal:     terminal,
+		NumDelivered: numDelivered,
+		FailedAt:     time.Now().UTC(),
+	}
+	dlJson, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	produceCtx, produceCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer produceCancel()
+
+	msgId := fmt.Sprintf("task-dead-letter-%s-%d", msg.Subject(), numDelivered)
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		msgId = fmt.Sprintf("task-dead-letter-%d", meta.Sequence.Stream)
+	}
+	if _, err := w.jq.Produce(produceCtx, w.cfg.Nats.DeadLetterTopicName, dlJson, msgId); err != nil {
+		return err
+	}
+	w.logger.Warn("moved job to the dead-letter subject", zap.String("subject", w.cfg.Nats.DeadLetterTopicName),
+		zap.Bool("terminal", terminal), zap.Uint64("numDelivered", numDelivered), zap.Error(cause))
+	return nil
+}
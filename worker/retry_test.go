@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/opengovern/og-task-template/config"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// fakeMsg records how a message was settled.
+type fakeMsg struct {
+	jetstream.Msg
+	numDelivered uint64
+	settled      string
+	delay        time.Duration
+}
+
+func (m *fakeMsg) Ack() error {
+	m.settled = "ack"
+	return nil
+}
+
+func (m *fakeMsg) Nak() error {
+	m.settled = "nak"
+	return nil
+}
+
+func (m *fakeMsg) Term() error {
+	m.settled = "term"
+	return nil
+}
+
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.settled, m.delay = "nak", delay
+	return nil
+}
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.numDelivered}, nil
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		numDelivered uint64
+		want         time.Duration
+	}{
+		{numDelivered: 0, want: RetryBackoffBase},
+		{numDelivered: 1, want: RetryBackoffBase},
+		{numDelivered: 2, want: 2 * RetryBackoffBase},
+		{numDelivered: 3, want: 4 * RetryBackoffBase},
+		{numDelivered: 6, want: 32 * RetryBackoffBase},
+		{numDelivered: 7, want: RetryBackoffMax},
+		{numDelivered: 1000, want: RetryBackoffMax},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.numDelivered); got != tt.want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", tt.numDelivered, got, tt.want)
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errors.New("boom"), want: false},
+		{name: "terminal", err: Terminal(errors.New("bad payload")), want: true},
+		{name: "wrapped terminal", err: fmt.Errorf("decoding: %w", Terminal(errors.New("bad payload"))), want: true},
+		{name: "task run error", err: &taskRunError{err: errors.New("boom")}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTerminal(tt.err); got != tt.want {
+				t.Errorf("IsTerminal(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if Terminal(nil) != nil {
+		t.Error("Terminal(nil) is not nil")
+	}
+}
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		numDelivered uint64
+		wantSettled  string
+		wantDelay    time.Duration
+	}{
+		{name: "success", numDelivered: 1, wantSettled: "ack"},
+		{name: "task failure is acked", err: &taskRunError{err: errors.New("boom")}, numDelivered: 1, wantSettled: "ack"},
+		{name: "first retry", err: errors.New("nats down"), numDelivered: 1, wantSettled: "nak", wantDelay: RetryBackoffBase},
+		{name: "later retry", err: errors.New("nats down"), numDelivered: 3, wantSettled: "nak", wantDelay: 4 * RetryBackoffBase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Worker.MaxDeliver = 5
+			w := &Worker{logger: zap.NewNop(), cfg: cfg}
+			msg := &fakeMsg{numDelivered: tt.numDelivered}
+
+			w.settle(msg, tt.err)
+			if msg.settled != tt.wantSettled || msg.delay != tt.wantDelay {
+				t.Errorf("settled with %s after %s, want %s after %s", msg.settled, msg.delay, tt.wantSettled, tt.wantDelay)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package worker
 
 import (
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/tracing"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"time"
 )
 
 func WorkerCommand() *cobra.Command {
@@ -15,9 +19,25 @@ func WorkerCommand() *cobra.Command {
 				return err
 			}
 
+			cfg, err := config.Load(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			logger.Info("Loaded configuration", zap.Any("config", cfg.Redacted()))
+
+			shutdownTracing, err := tracing.Init(ctx, cfg.Tracing)
+			if err != nil {
+				return err
+			}
+			defer flushTracing(shutdownTracing, logger)
+
 			w, err := NewWorker(
 				logger,
 				cmd.Context(),
+				cfg,
 			)
 			if err != nil {
 				return err
@@ -26,6 +46,16 @@ func WorkerCommand() *cobra.Command {
 			return w.Run(ctx)
 		},
 	}
+	config.AddFlags(cmd.PersistentFlags())
+	cmd.AddCommand(RunLocalCommand())
 
 	return cmd
 }
+
+func flushTracing(shutdown func(context.Context) error, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		logger.Error("failed to flush traces", zap.Error(err))
+	}
+}
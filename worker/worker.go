@@ -3,73 +3,132 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"errors" // Added import
+	"errors"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
-	"github.com/opengovern/og-task-template/envs"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/metrics"
+	"github.com/opengovern/og-task-template/results"
 	"github.com/opengovern/og-task-template/task"
+	"github.com/opengovern/og-task-template/tracing"
 	"github.com/opengovern/og-util/pkg/jq"
 	"github.com/opengovern/og-util/pkg/opengovernance-es-sdk"
 	"github.com/opengovern/og-util/pkg/tasks"
 	"github.com/opengovern/opensecurity/services/tasks/db/models"
 	"github.com/opengovern/opensecurity/services/tasks/scheduler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Worker struct {
 	logger   *zap.Logger
+	cfg      config.Config
 	jq       *jq.JobQueue
 	esClient opengovernance.Client
+	registry *task.Registry
+	health   *health
+	outbox   *Outbox
+	// resultsClient is shared by all runs; nil without GRPC_SERVER_URL.
+	resultsClient *results.ResultsClient
+
+	slots chan struct{}
+	jobs  sync.WaitGroup
 }
 
+var (
+	// errCancelRequested is the cause of a run cancelled through its NATS
+	// cancellation subject.
+	errCancelRequested = errors.New("task run cancellation requested")
+	// errWorkerShutdown is the cause of runs cancelled because they did not
+	// finish within the drain timeout of a shutdown.
+	errWorkerShutdown = errors.New("worker shut down before the task run finished")
+)
+
 func NewWorker(
 	logger *zap.Logger,
 	ctx context.Context,
+	cfg config.Config,
 ) (*Worker, error) {
-	jq, err := jq.New(envs.NatsURL, logger)
+	jq, err := jq.New(cfg.Nats.URL, logger)
 	if err != nil {
-		logger.Error("failed to create job queue", zap.Error(err), zap.String("url", envs.NatsURL))
+		logger.Error("failed to create job queue", zap.Error(err), zap.String("url", cfg.Nats.URL))
 		return nil, err
 	}
-	logger.Info("Ensuring stream exists", zap.String("stream", envs.StreamName),
-		zap.Strings("topics", []string{envs.TopicName, envs.ResultTopicName}))
-	if err := jq.Stream(ctx, envs.StreamName, "task job queue", []string{envs.TopicName, envs.ResultTopicName}, 100); err != nil {
+	logger.Info("Registered task types", zap.Strings("taskTypes", task.DefaultRegistry.TaskTypes()))
+	topics := []string{cfg.Nats.TopicName, cfg.Nats.ResultTopicName, cfg.Nats.DeadLetterTopicName}
+	logger.Info("Ensuring stream exists", zap.String("stream", cfg.Nats.StreamName), zap.Strings("topics", topics))
+	if err := jq.Stream(ctx, cfg.Nats.StreamName, "task job queue", topics, 100); err != nil {
 		logger.Error("failed to create stream", zap.Error(err))
 		return nil, err
 	}
-	isOnAks := false
-	isOnAks, _ = strconv.ParseBool(envs.ESIsOnAks)
-	isOpenSearch := false
-	isOpenSearch, _ = strconv.ParseBool(envs.ESIsOpenSearch)
 	esClient, err := opengovernance.NewClient(opengovernance.ClientConfig{
-		Addresses:     []string{envs.ESAddress},
-		Username:      &envs.ESUsername,
-		Password:      &envs.ESPassword,
-		IsOnAks:       &isOnAks,
-		IsOpenSearch:  &isOpenSearch,
-		AwsRegion:     &envs.ESAwsRegion,
-		AssumeRoleArn: &envs.ESAssumeRoleArn,
+		Addresses:     []string{cfg.ElasticSearch.Address},
+		Username:      &cfg.ElasticSearch.Username,
+		Password:      &cfg.ElasticSearch.Password,
+		IsOnAks:       &cfg.ElasticSearch.IsOnAks,
+		IsOpenSearch:  &cfg.ElasticSearch.IsOpenSearch,
+		AwsRegion:     &cfg.ElasticSearch.AwsRegion,
+		AssumeRoleArn: &cfg.ElasticSearch.AssumeRoleArn,
 	})
 	if err != nil {
 		logger.Error("failed to create ES client", zap.Error(err))
 		return nil, err
 	}
+	var outbox *Outbox
+	if cfg.Worker.OutboxDir != "" {
+		outbox, err = NewOutbox(cfg.Worker.OutboxDir, jq, logger)
+		if err != nil {
+			logger.Error("failed to create outbox", zap.Error(err), zap.String("dir", cfg.Worker.OutboxDir))
+			return nil, err
+		}
+	}
+	var resultsClient *results.ResultsClient
+	if cfg.Results.GRPCServerURL != "" {
+		resultsClient, err = results.NewResultsClient(cfg.Results.GRPCServerURL, logger,
+			results.WithResultsTLS(cfg.Results.TLS), results.WithResultsAuth(cfg.Results.Auth))
+		if err != nil {
+			logger.Error("failed to create results client", zap.Error(err), zap.String("url", cfg.Results.GRPCServerURL))
+			return nil, err
+		}
+	}
 	w := &Worker{
-		logger:   logger,
-		jq:       jq,
-		esClient: esClient,
+		logger:        logger,
+		cfg:           cfg,
+		jq:            jq,
+		esClient:      esClient,
+		registry:      task.DefaultRegistry,
+		health:        newHealth(logger),
+		outbox:        outbox,
+		resultsClient: resultsClient,
+
+		slots: make(chan struct{}, cfg.Worker.Concurrency),
 	}
 	return w, nil
 }
 
 func (w *Worker) Run(ctx context.Context) error {
-	w.logger.Info("starting to consume", zap.String("url", envs.NatsURL), zap.String("consumer", envs.NatsConsumer),
-		zap.String("stream", envs.StreamName), zap.String("topic", envs.TopicName))
+	w.logger.Info("starting to consume", zap.String("url", w.cfg.Nats.URL), zap.String("consumer", w.cfg.Nats.Consumer),
+		zap.String("stream", w.cfg.Nats.StreamName), zap.String("topic", w.cfg.Nats.TopicName), zap.Int("concurrency", w.cfg.Worker.Concurrency))
+
+	stopHealthServer := w.startHealthServer()
+	defer stopHealthServer()
+
+	if w.outbox != nil {
+		go w.outbox.Run(ctx)
+	}
 
-	consumeCtx, err := w.jq.ConsumeWithConfig(ctx, envs.NatsConsumer, envs.StreamName, []string{envs.TopicName}, jetstream.ConsumerConfig{
+	// Jobs do not inherit the cancellation of ctx, so a shutdown stops taking
+	// new messages but lets running jobs finish. See drain.
+	jobCtx, cancelJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelJobs(nil)
+
+	consumeCtx, err := w.jq.ConsumeWithConfig(ctx, w.cfg.Nats.Consumer, w.cfg.Nats.StreamName, []string{w.cfg.Nats.TopicName}, jetstream.ConsumerConfig{
 		Replicas:          1,
 		AckPolicy:         jetstream.AckExplicitPolicy,
 		DeliverPolicy:     jetstream.DeliverAllPolicy,
@@ -77,62 +136,148 @@ func (w *Worker) Run(ctx context.Context) error {
 		AckWait:           time.Minute * 30,
 		InactiveThreshold: time.Hour,
 	}, []jetstream.PullConsumeOpt{
-		jetstream.PullMaxMessages(1),
+		jetstream.PullMaxMessages(w.cfg.Worker.Concurrency),
+		jetstream.ConsumeErrHandler(w.health.consumeError),
 	}, func(msg jetstream.Msg) {
-		w.logger.Info("received a new job")
-
-		err := w.ProcessMessage(ctx, msg)
-		if err != nil {
-			// Log error from ProcessMessage itself (e.g., initial setup failure)
-			// Note: Errors during task.RunTask are handled within ProcessMessage's defer
-			w.logger.Error("failed during message processing setup", zap.Error(err))
-		}
-
-		// Ack is always sent by Run after ProcessMessage finishes or fails setup
-		if ackErr := msg.Ack(); ackErr != nil {
-			w.logger.Error("failed to send the ack message", zap.Error(ackErr))
+		// Block the consume callback until a slot is free, so no more than
+		// cfg.Worker.Concurrency jobs are ever running at the same time.
+		select {
+		case w.slots <- struct{}{}:
+		case <-ctx.Done():
+			w.logger.Info("worker is shutting down, returning the job to the queue")
+			if nakErr := msg.Nak(); nakErr != nil {
+				w.logger.Error("failed to send the nak message", zap.Error(nakErr))
+			}
+			return
 		}
 
-		w.logger.Info("processing a job completed")
+		w.jobs.Add(1)
+		go func() {
+			defer func() {
+				<-w.slots
+				w.jobs.Done()
+			}()
+			w.handleMessage(jobCtx, msg)
+		}()
 	})
 	if err != nil {
 		w.logger.Error("failed to start consuming messages", zap.Error(err))
 		return err
 	}
 
+	w.health.setConsumer(consumeCtx)
 	w.logger.Info("consuming messages...")
 
 	<-ctx.Done()
 	w.logger.Info("Main context cancelled, draining consumer...")
 	consumeCtx.Drain()
+	w.health.setConsumer(nil)
+	w.drain(cancelJobs)
 	w.logger.Info("Consumer stopped.")
+	if w.resultsClient != nil {
+		if err := w.resultsClient.Close(); err != nil {
+			w.logger.Error("failed to close results client", zap.Error(err))
+		}
+	}
 
 	return nil
 }
 
+// drain waits up to cfg.Worker.DrainTimeout for running jobs to finish, then
+// cancels the rest with errWorkerShutdown and waits for them to report it.
+func (w *Worker) drain(cancelJobs context.CancelCauseFunc) {
+	done := make(chan struct{})
+	go func() {
+		w.jobs.Wait()
+		close(done)
+	}()
+
+	w.logger.Info("Waiting for running jobs to finish...", zap.Duration("drainTimeout", w.cfg.Worker.DrainTimeout))
+	timer := time.NewTimer(w.cfg.Worker.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	w.logger.Warn("running jobs did not finish within the drain timeout, cancelling them")
+	cancelJobs(errWorkerShutdown)
+	<-done
+}
+
+func (w *Worker) handleMessage(ctx context.Context, msg jetstream.Msg) {
+	w.logger.Info("received a new job")
+	metrics.JobsReceived.Inc()
+
+	// Last resort for panics ProcessMessage could not turn into a
+	// TaskResponse, so one bad job never takes the worker down.
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := newPanicError(r)
+			w.logger.Error("message processing panicked", zap.Any("panic", r), zap.String("stack", panicErr.Stack))
+			metrics.JobPanics.Inc()
+			w.settle(msg, Terminal(panicErr))
+		}
+	}()
+
+	err := w.ProcessMessage(ctx, msg)
+	var taskErr *taskRunError
+	if err != nil && !errors.As(err, &taskErr) {
+		// Log error from ProcessMessage itself (e.g., initial setup failure)
+		// Note: Errors during task.RunTask are handled within ProcessMessage's defer
+		w.logger.Error("failed during message processing setup", zap.Error(err))
+	}
+
+	// Ack, Nak with backoff or dead-letter depending on the failure
+	w.settle(msg, err)
+
+	w.logger.Info("processing a job completed")
+}
+
 func (w *Worker) ProcessMessage(ctx context.Context, msg jetstream.Msg) (err error) {
+	startTime := time.Now()
 	var request tasks.TaskRequest
 	if err = json.Unmarshal(msg.Data(), &request); err != nil {
 		w.logger.Error("Failed to unmarshal TaskRequest", zap.Error(err))
-		return err
+		return Terminal(err)
 	}
 
 	runID := request.TaskDefinition.RunID
-	msgLogger := w.logger.With(zap.Uint("runID", runID))
+
+	ctx, span := tracing.Tracer().Start(tracing.ExtractNATS(ctx, msg.Headers()), "task.process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("task.run_id", int64(runID)),
+			attribute.String("task.type", request.TaskDefinition.TaskType),
+		))
+	defer span.End()
+
+	msgLogger := w.logger.With(zap.Uint("runID", runID), zap.String("taskType", request.TaskDefinition.TaskType),
+		zap.String("traceID", span.SpanContext().TraceID().String()))
 
 	response := &scheduler.TaskResponse{
 		RunID:  runID,
 		Status: models.TaskRunStatusInProgress,
 	}
 
-	ctxWithCancel, cancel := context.WithCancel(ctx)
-	defer cancel()
+	runCtx := results.WithRunInfo(ctx, results.RunInfo{
+		RunID:    runID,
+		TaskType: request.TaskDefinition.TaskType,
+	})
+	runCtx = results.WithSenderFactory(runCtx, w.newSender)
+	if w.resultsClient != nil {
+		runCtx = results.WithResultsClient(runCtx, w.resultsClient)
+	}
+	ctxWithCancel, cancel := context.WithCancelCause(runCtx)
+	defer cancel(nil)
 
-	cancelSubject := tasks.GetTaskRunCancelSubject(envs.TopicName, runID)
+	cancelSubject := tasks.GetTaskRunCancelSubject(w.cfg.Nats.TopicName, runID)
 	var subscription *nats.Subscription
 	subscription, err = w.jq.Subscribe(cancelSubject, func(m *nats.Msg) {
 		msgLogger.Info("Received cancellation request via NATS subject", zap.String("subject", cancelSubject))
-		cancel()
+		metrics.CancellationRequests.Inc()
+		cancel(errCancelRequested)
 	})
 	if err != nil {
 		msgLogger.Error("failed to subscribe to cancellation subject", zap.Error(err), zap.String("subject", cancelSubject))
@@ -148,28 +293,27 @@ func (w *Worker) ProcessMessage(ctx context.Context, msg jetstream.Msg) (err err
 		}()
 	}
 
-	defer func() {
-		finalStatus := models.TaskRunStatusFinished
-		failureMsg := ""
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		msgLogger.Error("failed to create initial InProgress response json", zap.Error(err))
+		return err
+	}
+	msgId := fmt.Sprintf("task-run-inprogress-%d", runID)
+	if _, err = w.jq.Produce(ctx, w.cfg.Nats.ResultTopicName, responseJson, msgId); err != nil { // Use original ctx
+		msgLogger.Error("failed to publish initial InProgress job status", zap.String("response", string(responseJson)), zap.Error(err))
+		return err
+	}
+	msgLogger.Info("Published initial InProgress job status via Produce")
 
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				if ctxWithCancel.Err() == context.Canceled {
-					finalStatus = models.TaskRunStatusCancelled
-					msgLogger.Warn("Job execution was cancelled", zap.Error(err))
-				} else {
-					finalStatus = models.TaskRunStatusFailed
-					failureMsg = "Task run cancelled (worker shutdown?)"
-					msgLogger.Warn("Job execution cancelled by parent context", zap.Error(err))
-				}
-			} else {
-				finalStatus = models.TaskRunStatusFailed
-				failureMsg = err.Error()
-				msgLogger.Error("Task execution resulted in error", zap.Error(err))
-			}
-		} else {
-			finalStatus = models.TaskRunStatusFinished
-			msgLogger.Info("Task execution finished successfully")
+	// The final result is only published once the run got this far. A setup
+	// failure before it is retried, and a Failed result published with the
+	// final msg-ID would make the result of the redelivered run a duplicate.
+	defer func() {
+		finalStatus, failureMsg := taskOutcome(ctxWithCancel, err, msgLogger)
+		metrics.JobDuration.WithLabelValues(string(finalStatus)).Observe(time.Since(startTime).Seconds())
+		span.SetAttributes(attribute.String("task.status", string(finalStatus)))
+		if finalStatus == models.TaskRunStatusFailed {
+			span.SetStatus(codes.Error, failureMsg)
 		}
 
 		response.Status = finalStatus
@@ -181,45 +325,72 @@ func (w *Worker) ProcessMessage(ctx context.Context, msg jetstream.Msg) (err err
 			return
 		}
 
-		produceCtx, produceCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		produceCtx, produceCancel := context.WithTimeout(tracing.Detach(ctx), 30*time.Second)
 		defer produceCancel()
+		produceCtx, publishSpan := tracing.Tracer().Start(produceCtx, "task.publish_result",
+			trace.WithSpanKind(trace.SpanKindProducer))
+		defer publishSpan.End()
 
 		msgId := fmt.Sprintf("task-run-result-%d", runID)
-		if _, pubErr := w.jq.Produce(produceCtx, envs.ResultTopicName, responseJson, msgId); pubErr != nil {
-			msgLogger.Error("failed to publish final job result", zap.String("jobResult", string(responseJson)), zap.Error(pubErr))
+		if pubErr := w.publishResult(produceCtx, responseJson, msgId); pubErr != nil {
+			if w.outbox != nil {
+				msgLogger.Warn("failed to publish final job result, kept in the outbox for retry", zap.String("msgId", msgId), zap.Error(pubErr))
+			} else {
+				msgLogger.Error("failed to publish final job result", zap.String("jobResult", string(responseJson)), zap.Error(pubErr))
+			}
+			publishSpan.RecordError(pubErr)
+			publishSpan.SetStatus(codes.Error, pubErr.Error())
 		} else {
 			msgLogger.Info("Published final job result", zap.String("status", string(finalStatus)), zap.String("msgId", msgId))
 		}
 	}()
 
-	responseJson, err := json.Marshal(response)
-	if err != nil {
-		msgLogger.Error("failed to create initial InProgress response json", zap.Error(err))
-		return err
-	}
-	msgId := fmt.Sprintf("task-run-inprogress-%d", runID)
-	if _, err = w.jq.Produce(ctx, envs.ResultTopicName, responseJson, msgId); err != nil { // Use original ctx
-		msgLogger.Error("failed to publish initial InProgress job status", zap.String("response", string(responseJson)), zap.Error(err))
-		return err
-	}
-	msgLogger.Info("Published initial InProgress job status via Produce")
+	// Registered after the publisher above so it runs first and the final
+	// TaskResponse reports the panic.
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := newPanicError(r)
+			msgLogger.Error("Task panicked", zap.Any("panic", r), zap.String("stack", panicErr.Stack))
+			metrics.JobPanics.Inc()
+			err = &taskRunError{err: panicErr}
+		}
+	}()
+
+	heartbeat := w.health.trackJob(runID)
+	defer w.health.untrackJob(heartbeat)
 
 	msgLogger.Info("Sending initial InProgress ACK extension")
 	if err = msg.InProgress(); err != nil {
 		msgLogger.Error("failed to send the initial InProgress ACK notification", zap.Error(err))
+		metrics.HeartbeatFailures.Inc()
 		err = nil
 	}
 
 	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 
+	// A panic in the heartbeat goroutine cannot be recovered by the deferred
+	// handler above, so it is recovered here and fails the job instead.
+	var heartbeatPanic atomic.Pointer[PanicError]
 	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicErr := newPanicError(r)
+				msgLogger.Error("InProgress heartbeat panicked", zap.Any("panic", r), zap.String("stack", panicErr.Stack))
+				metrics.JobPanics.Inc()
+				heartbeatPanic.Store(panicErr)
+				cancel(panicErr)
+			}
+		}()
 		for {
 			select {
 			case <-ticker.C:
 				msgLogger.Debug("Sending periodic InProgress ACK extension")
 				if pingErr := msg.InProgress(); pingErr != nil {
 					msgLogger.Error("failed to send periodic InProgress ACK notification", zap.Error(pingErr))
+					metrics.HeartbeatFailures.Inc()
+				} else {
+					heartbeat.beat()
 				}
 			case <-ctxWithCancel.Done():
 				msgLogger.Info("Job context cancelled or finished, stopping InProgress ticker.")
@@ -229,7 +400,59 @@ func (w *Worker) ProcessMessage(ctx context.Context, msg jetstream.Msg) (err err
 	}()
 
 	msgLogger.Info("Starting task execution")
-	err = task.RunTask(ctxWithCancel, w.jq, envs.InventoryServiceEndpoint, w.esClient, msgLogger, request, response)
+	execCtx, execSpan := tracing.Tracer().Start(ctxWithCancel, "task.execute")
+	err = w.registry.Run(execCtx, w.jq, w.cfg.InventoryServiceEndpoint, w.esClient, msgLogger, request, response)
+	if panicErr := heartbeatPanic.Load(); panicErr != nil {
+		err = panicErr
+	}
+	if err != nil {
+		execSpan.RecordError(err)
+		execSpan.SetStatus(codes.Error, err.Error())
+		err = &taskRunError{err: err}
+	}
+	execSpan.End()
 
 	return err
 }
+
+// publishResult publishes a final TaskResponse, through the outbox when one
+// is configured.
+func (w *Worker) publishResult(ctx context.Context, responseJson []byte, msgId string) error {
+	if w.outbox != nil {
+		return w.outbox.Publish(ctx, w.cfg.Nats.ResultTopicName, responseJson, msgId)
+	}
+	_, err := w.jq.Produce(ctx, w.cfg.Nats.ResultTopicName, responseJson, msgId)
+	return err
+}
+
+// newSender builds the Sender of a task run, writing to the configured result
+// sinks.
+func (w *Worker) newSender(ctx context.Context, request tasks.TaskRequest, logger *zap.Logger, opts ...results.ResourceSenderOption) (results.Sender, error) {
+	return results.NewTaskSender(ctx, w.cfg.Results, w.esClient.ES(), request, logger, opts...)
+}
+
+// taskOutcome maps the error a task run ended with to its final status and
+// failure message. runCtx is the run's own context, whose cancellation cause
+// tells a requested cancellation apart from a worker shutdown.
+func taskOutcome(runCtx context.Context, err error, logger *zap.Logger) (models.TaskRunStatus, string) {
+	if err == nil {
+		logger.Info("Task execution finished successfully")
+		return models.TaskRunStatusFinished, ""
+	}
+	if errors.Is(err, context.Canceled) {
+		if errors.Is(context.Cause(runCtx), errCancelRequested) {
+			logger.Warn("Job execution was cancelled", zap.Error(err))
+			return models.TaskRunStatusCancelled, ""
+		}
+		logger.Warn("Job execution cancelled by parent context", zap.Error(err), zap.NamedError("cause", context.Cause(runCtx)))
+		return models.TaskRunStatusFailed, "Task run cancelled (worker shutdown?)"
+	}
+	var deliveryErr *results.DeliveryError
+	if errors.As(err, &deliveryErr) && deliveryErr.Partial() {
+		logger.Error("Task results were partially delivered", zap.Int("sent", deliveryErr.Report.Sent),
+			zap.Int("failed", deliveryErr.Report.Failed), zap.Int("dropped", deliveryErr.Report.Dropped), zap.Int("rejected", deliveryErr.Report.Rejected))
+		return models.TaskRunStatusFailed, "Task run partially failed: " + err.Error()
+	}
+	logger.Error("Task execution resulted in error", zap.Error(err))
+	return models.TaskRunStatusFailed, err.Error()
+}
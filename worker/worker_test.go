@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opengovern/og-task-template/config"
+	"github.com/opengovern/og-task-template/results"
+	"github.com/opengovern/opensecurity/services/tasks/db/models"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name         string
+		jobDuration  time.Duration
+		drainTimeout time.Duration
+		wantCause    error
+	}{
+		{name: "jobs finish in time", jobDuration: 10 * time.Millisecond, drainTimeout: time.Minute},
+		{name: "jobs outlive the timeout", jobDuration: time.Hour, drainTimeout: 20 * time.Millisecond, wantCause: errWorkerShutdown},
+		{name: "zero timeout cancels right away", jobDuration: time.Hour, wantCause: errWorkerShutdown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Worker.DrainTimeout = tt.drainTimeout
+			w := &Worker{logger: zap.NewNop(), cfg: cfg}
+
+			// The main context is already cancelled, as it is when drain runs.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			jobCtx, cancelJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+			defer cancelJobs(nil)
+
+			var jobErr error
+			w.jobs.Add(1)
+			go func() {
+				defer w.jobs.Done()
+				select {
+				case <-time.After(tt.jobDuration):
+				case <-jobCtx.Done():
+					jobErr = context.Cause(jobCtx)
+				}
+			}()
+
+			done := make(chan struct{})
+			go func() {
+				w.drain(cancelJobs)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("drain did not return")
+			}
+			if !errors.Is(jobErr, tt.wantCause) || (tt.wantCause == nil && jobErr != nil) {
+				t.Errorf("job ended with %v, want %v", jobErr, tt.wantCause)
+			}
+		})
+	}
+}
+
+func TestTaskOutcome(t *testing.T) {
+	cancelled := func(cause error) context.Context {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(cause)
+		return ctx
+	}
+	shutdown := func() context.Context {
+		parent, cancel := context.WithCancelCause(context.Background())
+		ctx, cancelRun := context.WithCancelCause(parent)
+		defer cancelRun(nil)
+		cancel(errWorkerShutdown)
+		return ctx
+	}
+	partial := &results.DeliveryError{Report: results.DeliveryReport{Sent: 2, Failed: 1}}
+
+	tests := []struct {
+		name        string
+		runCtx      context.Context
+		err         error
+		wantStatus  models.TaskRunStatus
+		wantMessage string
+	}{
+		{name: "success", runCtx: context.Background(), wantStatus: models.TaskRunStatusFinished},
+		{name: "failure", runCtx: context.Background(), err: errors.New("boom"), wantStatus: models.TaskRunStatusFailed, wantMessage: "boom"},
+		{name: "requested cancellation", runCtx: cancelled(errCancelRequested), err: context.Canceled, wantStatus: models.TaskRunStatusCancelled},
+		{name: "wrapped requested cancellation", runCtx: cancelled(errCancelRequested), err: fmt.Errorf("listing: %w", context.Canceled), wantStatus: models.TaskRunStatusCancelled},
+		{name: "worker shutdown", runCtx: shutdown(), err: context.Canceled, wantStatus: models.TaskRunStatusFailed, wantMessage: "Task run cancelled (worker shutdown?)"},
+		{name: "cancelled without a cause", runCtx: cancelled(nil), err: context.Canceled, wantStatus: models.TaskRunStatusFailed, wantMessage: "Task run cancelled (worker shutdown?)"},
+		{name: "partial delivery", runCtx: context.Background(), err: partial, wantStatus: models.TaskRunStatusFailed, wantMessage: "Task run partially failed: " + partial.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := taskOutcome(tt.runCtx, tt.err, zap.NewNop())
+			if status != tt.wantStatus || message != tt.wantMessage {
+				t.Errorf("taskOutcome() = %q, %q, want %q, %q", status, message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}